@@ -0,0 +1,717 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/P1coFly/LoadBalancer/internal/config"
+	"github.com/P1coFly/LoadBalancer/pkg/backends"
+	"github.com/P1coFly/LoadBalancer/pkg/backends/strategies"
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+	"github.com/P1coFly/LoadBalancer/pkg/discovery"
+	"github.com/P1coFly/LoadBalancer/pkg/forwarded"
+	"github.com/P1coFly/LoadBalancer/pkg/handlers"
+	"github.com/P1coFly/LoadBalancer/pkg/identity"
+	"github.com/P1coFly/LoadBalancer/pkg/middleware"
+	"github.com/P1coFly/LoadBalancer/pkg/rewrite"
+	"github.com/P1coFly/LoadBalancer/pkg/router"
+)
+
+// defaultPool — имя пула из server.backends
+const defaultPool = "default"
+
+var errInvalidConfig = errors.New("invalid config")
+
+// balancer владеет той частью конфигурации, которая применяется без рестарта:
+// пулы и их состав, стратегия, health check, маршруты и сплиты, лимиты по умолчанию,
+// определение клиента. Клиенты и их бакеты при перезагрузке сохраняются
+type balancer struct {
+	log        *slog.Logger
+	fwdPolicy  *forwarded.Policy
+	clientRepo client.ClientRepo
+	// policyRepo — отдельные бакеты маршрутов (политики с capacity и rate_per_sec)
+	policyRepo client.ClientRepo
+	// parents — лимиты организаций и общий
+	parents *client.Hierarchy
+
+	// reloadMu сериализует применение конфигов, cfg — последний применённый
+	reloadMu sync.Mutex
+	cfg      *config.Config
+
+	poolsMu sync.RWMutex
+	pools   map[string]*backends.BackendsPool
+	// shadow — теневой пул зеркалирования, nil если зеркалирование выключено
+	shadow *backends.BackendsPool
+	// mirror оборачивает обработчики маршрутов: зеркалируется запрос после выбора
+	// маршрута, с его правилами перезаписи. nil — без зеркалирования
+	mirror func(http.Handler) http.Handler
+	// groups — обнаружение бекендов для пулов с dns+, file: или poll+ записями
+	groups map[string]*discovery.Group
+
+	splits   *router.SplitRegistry
+	splitCfg map[string]config.Split
+	router   atomic.Pointer[router.Router]
+	identity atomic.Pointer[identity.Chain]
+	policies atomic.Pointer[middleware.RatePolicies]
+
+	health *time.Ticker
+	evict  *time.Ticker
+}
+
+func newBalancer(cfg *config.Config, fwdPolicy *forwarded.Policy, clientRepo, policyRepo client.ClientRepo, parents *client.Hierarchy, log *slog.Logger) (*balancer, error) {
+	b := &balancer{
+		log:        log,
+		fwdPolicy:  fwdPolicy,
+		clientRepo: clientRepo,
+		policyRepo: policyRepo,
+		parents:    parents,
+		pools:      make(map[string]*backends.BackendsPool),
+		groups:     make(map[string]*discovery.Group),
+		splits:     router.NewSplitRegistry(),
+		splitCfg:   make(map[string]config.Split),
+	}
+	if cfg.Mirror.Enabled {
+		if discovery.HasDynamic(cfg.Mirror.Backends) {
+			return nil, fmt.Errorf("%w: mirror: discovery is not supported", errInvalidConfig)
+		}
+		strat, err := newStrategy(cfg.Server.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		b.shadow, err = backends.NewPool(strat, backends.HTTP, cfg.Mirror.Backends, log, backends.WithForwarded(fwdPolicy))
+		if err != nil {
+			return nil, fmt.Errorf("shadow pool: %w", err)
+		}
+		mc := cfg.Mirror
+		b.mirror = middleware.NewMirror(middleware.MirrorConfig{
+			Percent:        mc.Percent,
+			MaxConcurrency: mc.MaxConcurrency,
+			MaxBodyBytes:   mc.MaxBodyBytes,
+			Timeout:        mc.Timeout,
+		}, http.HandlerFunc(b.shadow.LoadBalancerHandler), log)
+	}
+	if err := b.apply(cfg); err != nil {
+		return nil, err
+	}
+
+	b.health = time.NewTicker(cfg.Server.HealthInterval)
+	go func() {
+		for range b.health.C {
+			for _, p := range b.allPools() {
+				p.HealthCheck(2 * time.Second)
+			}
+		}
+	}()
+	b.evict = time.NewTicker(evictInterval(cfg.RateLimit.IdleTTL))
+	go func() {
+		for range b.evict.C {
+			clientRepo.EvictIdle()
+			policyRepo.EvictIdle()
+			parents.Tenants.EvictIdle()
+		}
+	}()
+	return b, nil
+}
+
+// evictInterval — как часто искать простаивающих клиентов: вдвое чаще idle_ttl,
+// но не чаще раза в секунду и не реже раза в минуту
+func evictInterval(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return time.Minute
+	}
+	return min(max(ttl/2, time.Second), time.Minute)
+}
+
+// ServeHTTP отдаёт запрос текущему роутеру
+func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.router.Load().ServeHTTP(w, r)
+}
+
+// Identify определяет клиента запроса по текущему rate_limit.identity
+func (b *balancer) Identify(r *http.Request) (string, error) {
+	return b.identity.Load().Identify(r)
+}
+
+// MatchPolicy выбирает политику лимита по текущему rate_limit.policies
+func (b *balancer) MatchPolicy(r *http.Request) *middleware.RatePolicy {
+	return b.policies.Load().MatchPolicy(r)
+}
+
+// Pool ищет пул по имени для admin API
+func (b *balancer) Pool(name string) (handlers.BackendManager, bool) {
+	b.poolsMu.RLock()
+	defer b.poolsMu.RUnlock()
+	p, ok := b.pools[name]
+	return p, ok
+}
+
+func (b *balancer) allPools() []*backends.BackendsPool {
+	b.poolsMu.RLock()
+	defer b.poolsMu.RUnlock()
+	pools := make([]*backends.BackendsPool, 0, len(b.pools)+1)
+	for _, p := range b.pools {
+		pools = append(pools, p)
+	}
+	if b.shadow != nil {
+		pools = append(pools, b.shadow)
+	}
+	return pools
+}
+
+// reload перечитывает конфиг и применяет его. Невалидный конфиг отклоняется,
+// продолжает работать предыдущий
+func (b *balancer) reload(path string) {
+	cfg, err := config.Load(path)
+	if err == nil {
+		err = b.apply(cfg)
+	}
+	if err != nil {
+		b.log.Error("config reload rejected, keeping previous config", "path", path, "error", err)
+		return
+	}
+	b.log.Info("config reloaded", "path", path)
+}
+
+// apply проверяет конфиг целиком и только потом применяет его. Состав существующих
+// пулов меняется по разнице, запросы в работе не прерываются
+func (b *balancer) apply(cfg *config.Config) (err error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+	old := b.cfg
+
+	// проверяем всё, что может не примениться, до первого изменения
+	if _, err := newStrategy(cfg.Server.Strategy); err != nil {
+		return err
+	}
+	if cfg.Server.HealthInterval <= 0 {
+		return fmt.Errorf("%w: intervals must be positive", errInvalidConfig)
+	}
+	if cfg.RateLimit.DefaultCapacity < 0 || cfg.RateLimit.DefaultRPS < 0 || cfg.RateLimit.IdleTTL < 0 || cfg.RateLimit.MaxClients < 0 {
+		return fmt.Errorf("%w: rate_limit defaults must not be negative", errInvalidConfig)
+	}
+	ident, err := b.newIdentityChain(cfg.RateLimit.Identity)
+	if err != nil {
+		return fmt.Errorf("rate_limit.identity: %w", err)
+	}
+	for i, sc := range cfg.RateLimit.Identity.Sources {
+		// без проверки клиентских сертификатов на сервере источник никогда не сработает
+		if sc.Type == "mtls" && cfg.Server.TLS.ClientCAFile == "" {
+			return fmt.Errorf("%w: rate_limit.identity: source %d: mtls requires server.tls.client_ca_file", errInvalidConfig, i)
+		}
+	}
+	policies, err := newRatePolicies(cfg.RateLimit.Policies)
+	if err != nil {
+		return fmt.Errorf("rate_limit.policies: %w", err)
+	}
+	if tc := cfg.RateLimit.Tenants; tc.DefaultCapacity < 0 || tc.DefaultRPS < 0 {
+		return fmt.Errorf("%w: rate_limit.tenants defaults must not be negative", errInvalidConfig)
+	}
+	global, err := globalLimit(cfg.RateLimit.Global)
+	if err != nil {
+		return fmt.Errorf("rate_limit.global: %w", err)
+	}
+	poolCfgs := map[string]config.Pool{
+		defaultPool: {Backends: cfg.Server.Backends, StickySession: cfg.Server.StickySession},
+	}
+	for name, pc := range cfg.Pools {
+		if name == defaultPool {
+			return fmt.Errorf("%w: pool name %q is reserved", errInvalidConfig, name)
+		}
+		poolCfgs[name] = pc
+	}
+	cookies := make(map[string]string, len(poolCfgs))
+	for name, pc := range poolCfgs {
+		if err := validateBackends(pc.Backends); err != nil {
+			return fmt.Errorf("pool %q: %w", name, err)
+		}
+		if !pc.StickySession.Enabled {
+			continue
+		}
+		cookie := stickyCookie(name, pc.StickySession)
+		if other, ok := cookies[cookie]; ok {
+			return fmt.Errorf("%w: pools %q and %q share sticky cookie %q", errInvalidConfig, other, name, cookie)
+		}
+		cookies[cookie] = name
+	}
+	if b.shadow != nil && cfg.Mirror.Enabled {
+		if err := validateBackends(cfg.Mirror.Backends); err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
+		if discovery.HasDynamic(cfg.Mirror.Backends) {
+			return fmt.Errorf("%w: mirror: discovery is not supported", errInvalidConfig)
+		}
+	}
+
+	// обнаружение перезапускаем, если изменились записи пула или настройки обнаружения.
+	// Новые группы уже работают, поэтому при отказе конфига их надо остановить
+	groups := make(map[string]*discovery.Group)
+	var started []*discovery.Group
+	defer func() {
+		if err != nil {
+			for _, g := range started {
+				g.Stop()
+			}
+		}
+	}()
+	for name, pc := range poolCfgs {
+		if !discovery.HasDynamic(pc.Backends) {
+			continue
+		}
+		if g, ok := b.groups[name]; ok && slices.Equal(g.Entries(), pc.Backends) && old.Discovery == cfg.Discovery {
+			groups[name] = g
+			continue
+		}
+		g, err := discovery.NewGroup(pc.Backends, discoveryOptions(cfg.Discovery), b.log)
+		if err != nil {
+			return fmt.Errorf("pool %q: %w", name, err)
+		}
+		if err := g.Start(cfg.Discovery.StartTimeout); err != nil {
+			return fmt.Errorf("pool %q: %w", name, err)
+		}
+		started = append(started, g)
+		groups[name] = g
+		if len(g.Instances()) == 0 {
+			return fmt.Errorf("%w: pool %q: no backends discovered", errInvalidConfig, name)
+		}
+	}
+
+	// новые пулы создаём заранее: до коммита они ни с чем не связаны
+	pools := make(map[string]*backends.BackendsPool, len(poolCfgs))
+	var created []string
+	for name, pc := range poolCfgs {
+		if p, ok := b.pools[name]; ok {
+			pools[name] = p
+			continue
+		}
+		urls := pc.Backends
+		if g, ok := groups[name]; ok {
+			// вес и метаданные пул получит при Attach
+			urls = urls[:0:0]
+			for _, in := range g.Instances() {
+				urls = append(urls, in.URL)
+			}
+		}
+		strat, _ := newStrategy(cfg.Server.Strategy)
+		p, err := newPool(name, urls, pc.StickySession, strat, b.fwdPolicy, b.log)
+		if err != nil {
+			return fmt.Errorf("pool %q: %w", name, err)
+		}
+		pools[name] = p
+		created = append(created, name)
+	}
+	routes, splits, err := b.buildRoutes(cfg.Routes, pools)
+	if err != nil {
+		return err
+	}
+	if err := b.splits.Replace(splits); err != nil {
+		return err
+	}
+
+	// применяем
+	for name, g := range b.groups {
+		if groups[name] != g {
+			g.Stop()
+		}
+	}
+	for name, p := range pools {
+		g, discovered := groups[name]
+		switch {
+		case discovered:
+			// новая группа сразу передаёт пулу свой состав, прежняя продолжает работать
+			if g != b.groups[name] {
+				g.Attach(p)
+			}
+		case !slices.Contains(created, name):
+			if _, _, err := p.SetBackends(poolCfgs[name].Backends); err != nil {
+				b.log.Error("failed to update pool backends", "pool", name, "error", err)
+			}
+		}
+		if old != nil && old.Server.Strategy != cfg.Server.Strategy {
+			strat, _ := newStrategy(cfg.Server.Strategy)
+			p.SetStrategy(strat)
+		}
+	}
+	if b.shadow != nil && cfg.Mirror.Enabled {
+		if _, _, err := b.shadow.SetBackends(cfg.Mirror.Backends); err != nil {
+			b.log.Error("failed to update shadow pool backends", "error", err)
+		}
+		if old != nil && old.Server.Strategy != cfg.Server.Strategy {
+			strat, _ := newStrategy(cfg.Server.Strategy)
+			b.shadow.SetStrategy(strat)
+		}
+	}
+	var removed []string
+	for name := range b.pools {
+		if _, ok := pools[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	b.poolsMu.Lock()
+	b.pools = pools
+	b.poolsMu.Unlock()
+	b.groups = groups
+
+	b.splitCfg = make(map[string]config.Split, len(cfg.Routes))
+	for _, rc := range cfg.Routes {
+		if len(rc.Split.Weights) > 0 {
+			b.splitCfg[splitName(rc)] = rc.Split
+		}
+	}
+	b.router.Store(router.New(b.mirrored(http.HandlerFunc(pools[defaultPool].LoadBalancerHandler)), routes))
+
+	b.identity.Store(ident)
+	b.policies.Store(policies)
+	b.clientRepo.SetDefaults(cfg.RateLimit.DefaultCapacity, cfg.RateLimit.DefaultRPS)
+	b.clientRepo.SetEviction(cfg.RateLimit.IdleTTL, cfg.RateLimit.MaxClients)
+	b.policyRepo.SetEviction(cfg.RateLimit.IdleTTL, cfg.RateLimit.MaxClients)
+	b.parents.Tenants.SetDefaults(cfg.RateLimit.Tenants.DefaultCapacity, cfg.RateLimit.Tenants.DefaultRPS)
+	b.parents.Tenants.SetEviction(cfg.RateLimit.IdleTTL, cfg.RateLimit.MaxClients)
+	b.parents.SetGlobal(global)
+	if old != nil {
+		if old.Server.HealthInterval != cfg.Server.HealthInterval {
+			b.health.Reset(cfg.Server.HealthInterval)
+		}
+		if old.RateLimit.IdleTTL != cfg.RateLimit.IdleTTL {
+			b.evict.Reset(evictInterval(cfg.RateLimit.IdleTTL))
+		}
+		for _, section := range restartRequired(old, cfg) {
+			b.log.Warn("config change requires restart, ignored", "section", section)
+		}
+		b.log.Info("config applied", "pools_created", created, "pools_removed", removed, "routes", len(routes))
+	}
+	b.cfg = cfg
+	return nil
+}
+
+// buildRoutes компилирует маршруты из конфига. Маршрут ведёт в указанный пул,
+// в сплит между пулами или в пул по умолчанию. Сплит, чей конфиг не изменился,
+// переиспользуется вместе с весами, выставленными через admin API
+func (b *balancer) buildRoutes(cfgRoutes []config.Route, pools map[string]*backends.BackendsPool) ([]router.Route, []*router.Split, error) {
+	routes := make([]router.Route, 0, len(cfgRoutes))
+	var splits []*router.Split
+	for _, rc := range cfgRoutes {
+		rules, err := rewrite.New(rewriteSpec(rc.Rewrite))
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %q: %w", rc.PathPrefix, err)
+		}
+		handler, split, err := b.routeHandler(rc, pools)
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %q: %w", rc.PathPrefix, err)
+		}
+		if split != nil {
+			splits = append(splits, split)
+		}
+		routes = append(routes, router.Route{
+			PathPrefix: rc.PathPrefix,
+			Rules:      rules,
+			Handler:    b.mirrored(handler),
+		})
+	}
+	return routes, splits, nil
+}
+
+// mirrored оборачивает обработчик маршрута зеркалированием, если оно включено
+func (b *balancer) mirrored(h http.Handler) http.Handler {
+	if b.mirror == nil {
+		return h
+	}
+	return b.mirror(h)
+}
+
+func (b *balancer) routeHandler(rc config.Route, pools map[string]*backends.BackendsPool) (http.Handler, *router.Split, error) {
+	if len(rc.Split.Weights) == 0 {
+		name := rc.Pool
+		if name == "" {
+			name = defaultPool
+		}
+		pool, ok := pools[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown pool %q", name)
+		}
+		return http.HandlerFunc(pool.LoadBalancerHandler), nil, nil
+	}
+	if rc.Pool != "" {
+		return nil, nil, errors.New("pool and split are mutually exclusive")
+	}
+
+	name := splitName(rc)
+	if prev, ok := b.splitCfg[name]; ok && reflect.DeepEqual(prev, rc.Split) {
+		if split, err := b.splits.Get(name); err == nil {
+			return split, split, nil
+		}
+	}
+	targets := make([]router.SplitTarget, 0, len(rc.Split.Weights))
+	for pool, weight := range rc.Split.Weights {
+		p, ok := pools[pool]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown pool %q", pool)
+		}
+		targets = append(targets, router.SplitTarget{Name: pool, Weight: weight, Handler: http.HandlerFunc(p.LoadBalancerHandler)})
+	}
+	split, err := router.NewSplit(name, targets, router.Pin{
+		By:       router.PinBy(rc.Split.PinBy),
+		Key:      rc.Split.PinKey,
+		ClientID: middleware.ClientID,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return split, split, nil
+}
+
+func splitName(rc config.Route) string {
+	if rc.Split.Name != "" {
+		return rc.Split.Name
+	}
+	return rc.PathPrefix
+}
+
+// watch перечитывает конфиг при изменении файла. Следим за каталогом, а не за
+// файлом: редакторы и ConfigMap в Kubernetes подменяют файл через rename
+func (b *balancer) watch(path string, debounce time.Duration) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return err
+	}
+	go func() {
+		defer w.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(path) && filepath.Base(ev.Name) != "..data" {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(debounce, func() { b.reload(path) })
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				b.log.Error("config watcher error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// newStrategy возвращает стратегию выбора бекенда по имени из конфига
+func newStrategy(name string) (backends.Strategy, error) {
+	switch name {
+	case "round_robin", "":
+		return strategies.NewRoundRobin(), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown strategy %q", errInvalidConfig, name)
+	}
+}
+
+// stickyCookie — имя cookie sticky-сессий пула name: из конфига, иначе у пула по
+// умолчанию lb_backend, у именованного — своё (backends.StickyCookieName)
+func stickyCookie(name string, sc config.StickySession) string {
+	switch {
+	case sc.CookieName != "":
+		return sc.CookieName
+	case name == defaultPool:
+		return backends.DefaultStickyCookie
+	}
+	return backends.StickyCookieName(name)
+}
+
+// newPool создаёт пул HTTP-бекендов и, если включено, sticky-сессии
+func newPool(name string, urls []string, sc config.StickySession, strat backends.Strategy, fwdPolicy *forwarded.Policy, log *slog.Logger) (*backends.BackendsPool, error) {
+	poolOpts := []backends.PoolOption{backends.WithForwarded(fwdPolicy)}
+	if sc.Enabled {
+		if sc.Secret == "" {
+			log.Warn("sticky session secret is empty, cookies will not survive restart")
+		}
+		sticky, err := backends.NewStickySessions(stickyCookie(name, sc), sc.Secret, sc.TTL)
+		if err != nil {
+			return nil, err
+		}
+		poolOpts = append(poolOpts, backends.WithStickySessions(sticky))
+	}
+	return backends.NewPool(strat, backends.HTTP, urls, log, poolOpts...)
+}
+
+// newIdentityChain собирает источники ID клиента из конфига
+func (b *balancer) newIdentityChain(c config.Identity) (*identity.Chain, error) {
+	chain := &identity.Chain{
+		Known: func(id string) bool { return b.clientRepo.GetClient(id) != nil },
+	}
+	switch c.Fallback {
+	case "", "ip":
+		chain.Fallback = middleware.ClientIP
+	case "none":
+	default:
+		return nil, fmt.Errorf("%w: fallback %q: want ip or none", errInvalidConfig, c.Fallback)
+	}
+	configs := make([]identity.SourceConfig, 0, len(c.Sources))
+	for i, sc := range c.Sources {
+		secret := sc.Secret
+		if sc.SecretEnv != "" {
+			secret = os.Getenv(sc.SecretEnv)
+		}
+		src, err := identity.NewSource(identity.SourceConfig{
+			Type:          sc.Type,
+			Name:          sc.Name,
+			Claim:         sc.Claim,
+			Secret:        secret,
+			PublicKeyFile: sc.PublicKeyFile,
+			Field:         sc.Field,
+			Prefix:        sc.Prefix,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("source %d: %w", i, err)
+		}
+		chain.Sources = append(chain.Sources, src)
+		configs = append(configs, identity.SourceConfig{Type: sc.Type})
+	}
+	unknown, err := identity.ParsePolicy(c.Unknown, configs)
+	if err != nil {
+		return nil, err
+	}
+	chain.Unknown = unknown
+	return chain, nil
+}
+
+// newRatePolicies переводит политики лимитов из конфига
+func newRatePolicies(cs []config.RatePolicy) (*middleware.RatePolicies, error) {
+	policies := make([]middleware.RatePolicy, 0, len(cs))
+	for _, c := range cs {
+		p := middleware.RatePolicy{Name: c.Name, Pattern: c.Pattern, Cost: c.Cost}
+		if c.Capacity != 0 || c.RPS != 0 || c.Algorithm != "" {
+			algo, err := client.ParseAlgorithm(c.Algorithm)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", c.Pattern, err)
+			}
+			p.Limit = &client.Limit{Algorithm: algo, Capacity: c.Capacity, RPS: c.RPS}
+		}
+		policies = append(policies, p)
+	}
+	return middleware.NewRatePolicies(policies)
+}
+
+// globalLimit проверяет общий лимит, nil — выключен
+func globalLimit(c config.GlobalLimit) (*client.Limit, error) {
+	if c.Capacity == 0 {
+		return nil, nil
+	}
+	algo, err := client.ParseAlgorithm(c.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if c.Capacity < 0 || c.RPS <= 0 {
+		return nil, fmt.Errorf("%w: capacity and rate_per_sec must be positive", errInvalidConfig)
+	}
+	return &client.Limit{Algorithm: algo, Capacity: c.Capacity, RPS: c.RPS}, nil
+}
+
+// discoveryOptions переводит настройки обнаружения из конфига
+func discoveryOptions(c config.Discovery) discovery.Options {
+	return discovery.Options{
+		DNS: discovery.DNSOptions{
+			Server:     c.DNS.Server,
+			Timeout:    c.DNS.Timeout,
+			MinRefresh: c.DNS.MinRefresh,
+			MaxRefresh: c.DNS.MaxRefresh,
+		},
+		HTTP:   discovery.HTTPOptions{Interval: c.HTTP.Interval, Timeout: c.HTTP.Timeout},
+		File:   discovery.FileOptions{Debounce: c.File.Debounce},
+		Consul: discovery.ConsulOptions{Token: c.Consul.Token, Wait: c.Consul.Wait, Retry: c.Consul.Retry},
+		Etcd:   discovery.EtcdOptions{Timeout: c.Etcd.Timeout, Retry: c.Etcd.Retry},
+		Kubernetes: discovery.KubernetesOptions{
+			Kubeconfig: c.Kubernetes.Kubeconfig,
+			Timeout:    c.Kubernetes.Timeout,
+			Retry:      c.Kubernetes.Retry,
+		},
+	}
+}
+
+// validateBackends проверяет адреса бекендов. Источники обнаружения разбираются
+// при создании discovery.Group, здесь — только на повторы
+func validateBackends(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("%w: empty backends list", errInvalidConfig)
+	}
+	seen := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		if discovery.IsDynamic(u) {
+			if seen[u] {
+				return fmt.Errorf("%w: duplicate backend %q", errInvalidConfig, u)
+			}
+			seen[u] = true
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("%w: backend %q: %w", errInvalidConfig, u, err)
+		}
+		if parsed.Host == "" {
+			return fmt.Errorf("%w: backend %q has no host", errInvalidConfig, u)
+		}
+		if seen[parsed.Host] {
+			return fmt.Errorf("%w: duplicate backend %q", errInvalidConfig, u)
+		}
+		seen[parsed.Host] = true
+	}
+	return nil
+}
+
+// restartRequired возвращает изменённые секции, которые применяются только при рестарте
+func restartRequired(old, cfg *config.Config) []string {
+	var sections []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			sections = append(sections, name)
+		}
+	}
+	check("env", old.Env, cfg.Env)
+	check("server.port", old.Server.Port, cfg.Server.Port)
+	check("server.timeouts",
+		[]time.Duration{old.Server.ReadTimeout, old.Server.WriteTimeout, old.Server.IdleTimeout},
+		[]time.Duration{cfg.Server.ReadTimeout, cfg.Server.WriteTimeout, cfg.Server.IdleTimeout})
+	check("server.sticky_session", old.Server.StickySession, cfg.Server.StickySession)
+	check("server.tls", old.Server.TLS, cfg.Server.TLS)
+	check("server.debug_addr", old.Server.DebugAddr, cfg.Server.DebugAddr)
+	check("server.admin_addr", old.Server.AdminAddr, cfg.Server.AdminAddr)
+	check("server.admin_token", old.Server.AdminToken, cfg.Server.AdminToken)
+	for name, pc := range cfg.Pools {
+		if prev, ok := old.Pools[name]; ok {
+			check("pools."+name+".sticky_session", prev.StickySession, pc.StickySession)
+		}
+	}
+	check("proxy", old.Proxy, cfg.Proxy)
+	check("compression", old.Compression, cfg.Compression)
+	check("cache", old.Cache, cfg.Cache)
+	check("coalescing", old.Coalescing, cfg.Coalescing)
+	oldMirror, newMirror := old.Mirror, cfg.Mirror
+	oldMirror.Backends, newMirror.Backends = nil, nil
+	check("mirror", oldMirror, newMirror)
+	check("reload", old.Reload, cfg.Reload)
+	check("rate_limit.repository",
+		[]any{old.RateLimit.Repository, old.RateLimit.Redis, old.RateLimit.Bolt},
+		[]any{cfg.RateLimit.Repository, cfg.RateLimit.Redis, cfg.RateLimit.Bolt})
+	return sections
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/P1coFly/LoadBalancer/internal/config"
+	"github.com/P1coFly/LoadBalancer/pkg/cache"
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+	"github.com/P1coFly/LoadBalancer/pkg/forwarded"
+	"github.com/P1coFly/LoadBalancer/pkg/identity"
+	"github.com/P1coFly/LoadBalancer/pkg/middleware"
+)
+
+const baseConfig = `
+env: "dev"
+server:
+  port: ":0"
+  backends: [ "{{b1}}" ]
+pools:
+  v2:
+    backends: [ "{{b2}}" ]
+rate_limit:
+  default_capacity: 10
+  default_rps: 1
+routes:
+  - path_prefix: "/api"
+    split:
+      name: "api"
+      weights: { default: 100, v2: 0 }
+`
+
+func newTestBalancer(t *testing.T) (*balancer, string, func(string) string) {
+	t.Helper()
+	newBackend := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend", name)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	b1, b2, b3 := newBackend("b1"), newBackend("b2"), newBackend("b3")
+	render := func(tmpl string) string {
+		return strings.NewReplacer("{{b1}}", b1.URL, "{{b2}}", b2.URL, "{{b3}}", b3.URL).Replace(tmpl)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig(t, path, render(baseConfig))
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	repo := client.NewMemoryRepo(cfg.RateLimit.DefaultCapacity, cfg.RateLimit.DefaultRPS, logger)
+	parents := client.NewHierarchy(client.NewMemoryRepo(1000, 100, logger), client.NewMemoryRepo(0, 0, logger))
+	lb, err := newBalancer(cfg, (*forwarded.Policy)(nil), repo, client.NewMemoryRepo(0, 0, logger), parents, logger)
+	if err != nil {
+		t.Fatalf("newBalancer: %v", err)
+	}
+	return lb, path, render
+}
+
+// loadBalancer создаёт балансировщик из конфига data
+func loadBalancer(t *testing.T, data string) (*balancer, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig(t, path, data)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	parents := client.NewHierarchy(client.NewMemoryRepo(1000, 100, logger), client.NewMemoryRepo(0, 0, logger))
+	return newBalancer(cfg, (*forwarded.Policy)(nil), client.NewMemoryRepo(10, 1, logger), client.NewMemoryRepo(0, 0, logger), parents, logger)
+}
+
+func writeConfig(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func servedBy(lb *balancer, path string) string {
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Header().Get("X-Backend")
+}
+
+func TestBalancer_MirrorAfterRewrite(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+	shadowPaths := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowPaths <- r.URL.Path
+	}))
+	defer shadow.Close()
+
+	lb, err := loadBalancer(t, strings.NewReplacer("{{primary}}", primary.URL, "{{shadow}}", shadow.URL).Replace(`
+env: "dev"
+server:
+  port: ":0"
+  backends: [ "{{primary}}" ]
+mirror:
+  enabled: true
+  percent: 100
+  backends: [ "{{shadow}}" ]
+routes:
+  - path_prefix: "/legacy"
+    rewrite:
+      path: { regex: "^/legacy(/.*)?$", replacement: "$1" }
+`))
+	if err != nil {
+		t.Fatalf("newBalancer: %v", err)
+	}
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/legacy/users", nil))
+	select {
+	case got := <-shadowPaths:
+		if got != "/users" {
+			t.Errorf("shadow got path %q; want rewritten /users", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
+
+func TestBalancer_StickyCookiePerPool(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	const tmpl = `
+env: "dev"
+server:
+  port: ":0"
+  backends: [ "{{b}}" ]
+  sticky_session: { enabled: true, secret: "s" }
+pools:
+  v2:
+    backends: [ "{{b}}" ]
+    sticky_session: { enabled: true, secret: "s"{{v2cookie}} }
+routes:
+  - path_prefix: "/beta"
+    pool: "v2"
+`
+	render := func(v2cookie string) string {
+		return strings.NewReplacer("{{b}}", backend.URL, "{{v2cookie}}", v2cookie).Replace(tmpl)
+	}
+	lb, err := loadBalancer(t, render(""))
+	if err != nil {
+		t.Fatalf("newBalancer: %v", err)
+	}
+	for path, want := range map[string]string{"/": "lb_backend", "/beta/x": "lb_backend_v2"} {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != want {
+			t.Errorf("%s: cookies = %v; want only %s", path, cookies, want)
+		}
+	}
+
+	// общее имя cookie у двух пулов — ошибка конфига
+	if _, err := loadBalancer(t, render(`, cookie_name: "lb_backend"`)); !errors.Is(err, errInvalidConfig) {
+		t.Errorf("shared cookie name: err = %v; want errInvalidConfig", err)
+	}
+}
+
+func TestBalancer_ReloadAppliesChanges(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+	lb.clientRepo.AddClient("alice", client.Limit{Capacity: 5, RPS: 0.001}, "")
+	lb.clientRepo.Consume("alice", 3)
+
+	// вес, выставленный через admin API, переживает перезагрузку с тем же сплитом
+	split, err := lb.splits.Get("api")
+	if err != nil {
+		t.Fatalf("split not registered: %v", err)
+	}
+	if err := split.SetWeights(map[string]int{"default": 0, "v2": 1}); err != nil {
+		t.Fatalf("SetWeights: %v", err)
+	}
+
+	updated := strings.Replace(baseConfig, `backends: [ "{{b1}}" ]`, `backends: [ "{{b1}}", "{{b3}}" ]`, 1)
+	updated = strings.Replace(updated, "default_capacity: 10", "default_capacity: 42", 1)
+	updated += `  - path_prefix: "/beta"
+    pool: "v2"
+`
+	writeConfig(t, path, render(updated))
+	lb.reload(path)
+
+	if got := len(lb.pools[defaultPool].Backends()); got != 2 {
+		t.Errorf("default pool has %d backends; want 2", got)
+	}
+	if got := servedBy(lb, "/beta/x"); got != "b2" {
+		t.Errorf("/beta served by %q; want b2", got)
+	}
+	if got := servedBy(lb, "/api/x"); got != "b2" {
+		t.Errorf("/api served by %q; want b2 (runtime weights kept)", got)
+	}
+	if got := lb.clientRepo.DefaultCapacity(); got != 42 {
+		t.Errorf("default capacity = %d; want 42", got)
+	}
+	if cl := lb.clientRepo.GetClient("alice"); cl == nil || cl.Remaining != 2 {
+		t.Errorf("client state lost on reload: %+v", cl)
+	}
+}
+
+func TestBalancer_ReloadRejectsInvalidConfig(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+	before := lb.cfg
+
+	invalid := []string{
+		"env: [",
+		strings.Replace(baseConfig, "weights: { default: 100, v2: 0 }", "weights: { default: 100, v3: 1 }", 1),
+		strings.Replace(baseConfig, `backends: [ "{{b2}}" ]`, `backends: [ "not a url" ]`, 1),
+		strings.Replace(baseConfig, "port:", "strategy: random\n  port:", 1),
+		strings.Replace(baseConfig, "default_rps: 1", "default_rps: 1\n  identity: { sources: [ { type: jwt } ] }", 1),
+		strings.Replace(baseConfig, "default_rps: 1", "default_rps: 1\n  policies: [ { pattern: \"GET /a\" }, { pattern: \"GET /a\" } ]", 1),
+		strings.Replace(baseConfig, "default_rps: 1", "default_rps: 1\n  global: { capacity: 10 }", 1),
+		// клиентские сертификаты не проверяются без server.tls.client_ca_file
+		strings.Replace(baseConfig, "default_rps: 1", "default_rps: 1\n  identity: { sources: [ { type: mtls } ] }", 1),
+	}
+	for _, data := range invalid {
+		writeConfig(t, path, render(data))
+		lb.reload(path)
+		if lb.cfg != before {
+			t.Fatalf("invalid config was applied:\n%s", data)
+		}
+	}
+	if got := servedBy(lb, "/api/x"); got != "b1" {
+		t.Errorf("/api served by %q after rejected reloads; want b1", got)
+	}
+	if got := len(lb.pools["v2"].Backends()); got != 1 {
+		t.Errorf("v2 pool has %d backends; want 1", got)
+	}
+}
+
+func TestBalancer_WatchReloadsOnChange(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+	if err := lb.watch(path, 10*time.Millisecond); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	updated := strings.Replace(baseConfig, "weights: { default: 100, v2: 0 }", "weights: { default: 0, v2: 100 }", 1)
+	writeConfig(t, path, render(updated))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for servedBy(lb, "/api/x") != "b2" {
+		if time.Now().After(deadline) {
+			t.Fatal("config change was not picked up by watcher")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// srvStub — DNS-сервер с одной SRV-записью, порт которой можно менять
+type srvStub struct {
+	mu   sync.Mutex
+	port uint16
+}
+
+func (s *srvStub) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	if q := req.Question[0]; q.Qtype == dns.TypeSRV {
+		s.mu.Lock()
+		rr, _ := dns.NewRR(fmt.Sprintf("%s 0 IN SRV 0 0 %d local.test.", q.Name, s.port))
+		s.mu.Unlock()
+		glue, _ := dns.NewRR("local.test. 0 IN A 127.0.0.1")
+		resp.Answer, resp.Extra = []dns.RR{rr}, []dns.RR{glue}
+	}
+	w.WriteMsg(resp)
+}
+
+func (s *srvStub) setPort(u string) {
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(u, "http://"))
+	var p uint16
+	fmt.Sscan(port, &p)
+	s.mu.Lock()
+	s.port = p
+	s.mu.Unlock()
+}
+
+func TestBalancer_DNSDiscovery(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+
+	stub := &srvStub{}
+	stub.setPort(render("{{b3}}"))
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: stub}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	updated := strings.Replace(baseConfig, `backends: [ "{{b1}}" ]`, `backends: [ "dns+srv://_http._tcp.api.test" ]`, 1)
+	updated += fmt.Sprintf(`discovery:
+  dns:
+    server: %q
+    min_refresh: "10ms"
+`, pc.LocalAddr().String())
+	writeConfig(t, path, render(updated))
+	lb.reload(path)
+	defer func() {
+		for _, g := range lb.groups {
+			g.Stop()
+		}
+	}()
+
+	if got := servedBy(lb, "/x"); got != "b3" {
+		t.Fatalf("served by %q after switching to dns; want b3", got)
+	}
+
+	// запись сменилась — пул переходит на новый адрес
+	stub.setPort(render("{{b1}}"))
+	deadline := time.Now().Add(2 * time.Second)
+	for servedBy(lb, "/x") != "b1" {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool did not follow SRV change: %+v", lb.pools[defaultPool].Backends())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(lb.pools[defaultPool].Backends()); got != 1 {
+		t.Errorf("default pool has %d backends; want vanished one removed", got)
+	}
+}
+
+func TestBalancer_ReloadAppliesIdentity(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", "k1")
+	if id, err := lb.Identify(req); err != nil || id != "192.0.2.1" {
+		t.Fatalf("default identity = %q, %v; want client IP", id, err)
+	}
+
+	updated := strings.Replace(baseConfig, "default_rps: 1", `default_rps: 1
+  identity:
+    sources: [ { type: header, name: X-API-Key, prefix: "key:" } ]
+    fallback: none`, 1)
+	writeConfig(t, path, render(updated))
+	lb.reload(path)
+
+	// unknown не задан: ключ из заголовка, не заведённый через /clients, отклоняется
+	if _, err := lb.Identify(req); !errors.Is(err, identity.ErrUnknownClient) {
+		t.Errorf("unregistered key after reload: err = %v; want ErrUnknownClient", err)
+	}
+	if _, err := lb.clientRepo.AddClient("key:k1", client.Limit{Capacity: 1, RPS: 1}, ""); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if id, err := lb.Identify(req); err != nil || id != "key:k1" {
+		t.Errorf("identity after reload = %q, %v; want key:k1", id, err)
+	}
+	if _, err := lb.Identify(httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		t.Error("request without key identified with fallback none")
+	}
+}
+
+func TestBalancer_ReloadAppliesPolicies(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	if p := lb.MatchPolicy(req); p != nil {
+		t.Fatalf("policy without config = %+v; want nil", p)
+	}
+
+	updated := strings.Replace(baseConfig, "default_rps: 1", `default_rps: 1
+  policies:
+    - { pattern: "POST /upload", cost: 10 }
+    - { name: search, pattern: "GET /search", algorithm: gcra, capacity: 5, rate_per_sec: 5 }`, 1)
+	writeConfig(t, path, render(updated))
+	lb.reload(path)
+
+	if p := lb.MatchPolicy(req); p == nil || p.Cost != 10 || p.Limit != nil {
+		t.Errorf("upload policy = %+v; want cost 10 from client limit", p)
+	}
+	p := lb.MatchPolicy(httptest.NewRequest(http.MethodGet, "/search", nil))
+	if p == nil || p.Name != "search" || p.Limit == nil || p.Limit.Algorithm != client.AlgorithmGCRA {
+		t.Errorf("search policy = %+v; want separate gcra bucket", p)
+	}
+}
+
+func TestBalancer_ReloadAppliesTenantsAndGlobal(t *testing.T) {
+	lb, path, render := newTestBalancer(t)
+	if !lb.parents.ConsumeParents("", 1).Allowed {
+		t.Fatal("request rejected without global limit")
+	}
+
+	updated := strings.Replace(baseConfig, "default_rps: 1", `default_rps: 1
+  tenants: { default_capacity: 7, default_rps: 2 }
+  global: { capacity: 1, rate_per_sec: 0.001 }`, 1)
+	writeConfig(t, path, render(updated))
+	lb.reload(path)
+
+	if c, rps := lb.parents.Tenants.DefaultCapacity(), lb.parents.Tenants.DefaultRPS(); c != 7 || rps != 2 {
+		t.Errorf("tenant defaults = %d, %v; want 7, 2", c, rps)
+	}
+	if !lb.parents.ConsumeParents("", 1).Allowed || lb.parents.ConsumeParents("", 1).Allowed {
+		t.Error("global limit of 1 not applied")
+	}
+}
+
+// writeCert выпускает сертификат, подписанный parent (nil — самоподписанный CA),
+// и пишет его в dir как <name>.pem и <name>-key.pem
+func writeCert(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, filepath.Join(dir, name+".pem"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeConfig(t, filepath.Join(dir, name+"-key.pem"), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestServerTLS_ClientCertIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test ca"}}, nil, nil)
+	writeCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "svc-a"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	if _, err := serverTLSConfig(config.ServerTLS{ClientCAFile: filepath.Join(dir, "ca.pem")}); err == nil {
+		t.Error("client_ca_file without cert_file accepted")
+	}
+	tc, err := serverTLSConfig(config.ServerTLS{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	})
+	if err != nil {
+		t.Fatalf("serverTLSConfig: %v", err)
+	}
+
+	lb, _, _ := newTestBalancer(t)
+	cfg := *lb.cfg
+	cfg.Server.TLS.ClientCAFile = filepath.Join(dir, "ca.pem")
+	cfg.RateLimit.Identity.Sources = []config.IdentitySource{{Type: "mtls"}}
+	if err := lb.apply(&cfg); err != nil {
+		t.Fatalf("apply mtls identity: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := lb.Identify(r)
+		fmt.Fprint(w, id)
+	}))
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certs ...tls.Certificate) string {
+		t.Helper()
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := get(pair); id != "svc-a" {
+		t.Errorf("client with certificate identified as %q; want svc-a", id)
+	}
+	// без сертификата клиент определяется по IP
+	if id := get(); id != "127.0.0.1" {
+		t.Errorf("client without certificate identified as %q; want 127.0.0.1", id)
+	}
+}
+
+func TestAdminAPI_RequiresToken(t *testing.T) {
+	lb, _, _ := newTestBalancer(t)
+	httpCache, err := cache.New(cache.Config{MaxBytes: 1 << 20}, lb.log)
+	if err != nil {
+		t.Fatalf("cache.New: %v", err)
+	}
+	srv := httptest.NewServer(middleware.AdminAuth("s3cret", newAdminMux(lb, httpCache, lb.log)))
+	defer srv.Close()
+
+	do := func(method, path, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct{ method, path string }{
+		{http.MethodGet, "/admin/backends"},
+		{http.MethodGet, "/admin/splits"},
+		{http.MethodGet, "/tenants"},
+		{http.MethodDelete, "/admin/cache"},
+	}
+	for _, tt := range tests {
+		if code := do(tt.method, tt.path, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: status %d; want 401", tt.method, tt.path, code)
+		}
+		if code := do(tt.method, tt.path, "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("%s %s with wrong token: status %d; want 401", tt.method, tt.path, code)
+		}
+		if code := do(tt.method, tt.path, "s3cret"); code == http.StatusUnauthorized || code == http.StatusNotFound {
+			t.Errorf("%s %s with token: status %d", tt.method, tt.path, code)
+		}
+	}
+}
@@ -44,9 +44,8 @@ func startTestServer(t *testing.T) (addr string, shutdown func()) {
 			Backends:       []string{"http://invalid"},
 		},
 		RateLimit: config.RateLimit{
-			DefaultCapacity:   10,
-			DefaultRPS:        5,
-			ReplenishInterval: 100 * time.Millisecond,
+			DefaultCapacity: 10,
+			DefaultRPS:      5,
 		},
 	}
 
@@ -54,21 +53,6 @@ func startTestServer(t *testing.T) (addr string, shutdown func()) {
 
 	// собираем mux из main()
 	clientRepo := client.NewMemoryRepo(cfg.RateLimit.DefaultCapacity, cfg.RateLimit.DefaultRPS, logger)
-	// запускаем replenish
-	stopRepl := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(cfg.RateLimit.ReplenishInterval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				clientRepo.Replenish()
-			case <-stopRepl:
-				return
-			}
-		}
-	}()
-
 	// handlers
 	ch := &handlers.ClientHandler{Repo: clientRepo, Logger: logger}
 
@@ -115,7 +99,6 @@ func startTestServer(t *testing.T) (addr string, shutdown func()) {
 	}()
 
 	shutdown = func() {
-		close(stopRepl)
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
 		if err := srv.Shutdown(ctx); err != nil {
@@ -1,17 +1,22 @@
 package main_test
 
 import (
+	"encoding/base64"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/P1coFly/LoadBalancer/pkg/backends"
 	"github.com/P1coFly/LoadBalancer/pkg/backends/strategies"
+	"github.com/P1coFly/LoadBalancer/pkg/handlers"
+	"github.com/P1coFly/LoadBalancer/pkg/rewrite"
+	"github.com/P1coFly/LoadBalancer/pkg/router"
 )
 
 func TestLoadBalancer_DistributesTraffic(t *testing.T) {
@@ -214,3 +219,324 @@ func TestLoadBalancer_AllDown(t *testing.T) {
 		t.Errorf("expected %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
 	}
 }
+
+// TestLoadBalancer_StickySession проверяет, что клиент с cookie попадает на тот же бэкенд,
+// а при падении бэкенда переезжает на другой и получает новую cookie.
+func TestLoadBalancer_StickySession(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := w.Write([]byte(name)); err != nil {
+				t.Logf("Error write to responseWriter, err: %t", err)
+			}
+		}))
+	}
+	srv1 := newBackend("1")
+	defer srv1.Close()
+	srv2 := newBackend("2")
+	defer srv2.Close()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	sticky, err := backends.NewStickySessions("lb_backend", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create sticky sessions: %v", err)
+	}
+	pool, err := backends.NewPool(strategies.NewRoundRobin(), backends.HTTP, []string{srv1.URL, srv2.URL}, logger,
+		backends.WithStickySessions(sticky))
+	if err != nil {
+		t.Fatalf("failed to create backend pool: %v", err)
+	}
+
+	lb := httptest.NewServer(http.HandlerFunc(pool.LoadBalancerHandler))
+	defer lb.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	get := func(cookie *http.Cookie) (string, *http.Cookie) {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed read response body: %v", err)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == "lb_backend" {
+				return string(b), c
+			}
+		}
+		return string(b), nil
+	}
+
+	first, cookie := get(nil)
+	if cookie == nil {
+		t.Fatal("expected sticky cookie on first response")
+	}
+	// cookie не раскрывает адреса бэкендов
+	for _, srv := range []*httptest.Server{srv1, srv2} {
+		host := strings.TrimPrefix(srv.URL, "http://")
+		if strings.Contains(cookie.Value, host) || strings.Contains(cookie.Value, base64.RawURLEncoding.EncodeToString([]byte(host))) {
+			t.Errorf("cookie %q exposes backend address %s", cookie.Value, host)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		body, c := get(cookie)
+		if body != first {
+			t.Errorf("request %d: expected sticky backend %s, got %s", i, first, body)
+		}
+		if c != nil {
+			t.Errorf("request %d: cookie should not be reissued", i)
+		}
+	}
+
+	// подделанная cookie игнорируется
+	forged := &http.Cookie{Name: "lb_backend", Value: cookie.Value + "x"}
+	if _, c := get(forged); c == nil {
+		t.Error("expected new cookie for forged value")
+	}
+
+	// бэкенд падает — клиента переносит на другой и перевыдаёт cookie
+	if first == "1" {
+		srv1.Close()
+	} else {
+		srv2.Close()
+	}
+	body, c := get(cookie)
+	if body == first {
+		t.Errorf("expected failover away from %s", first)
+	}
+	if c == nil {
+		t.Error("expected cookie to be reissued after failover")
+	}
+}
+
+// TestLoadBalancer_RouteRewrite проверяет монтирование бэкенда под префиксом с перезаписью пути и ответа.
+func TestLoadBalancer_RouteRewrite(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Path", r.URL.Path)
+		w.Header().Set("X-Seen-Query", r.URL.RawQuery)
+		w.Header().Set("X-Seen-Mount", r.Header.Get("X-Mounted-At"))
+		w.Header().Set("Location", "/login")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer backend.Close()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	pool, err := backends.NewPool(strategies.NewRoundRobin(), backends.HTTP, []string{backend.URL}, logger)
+	if err != nil {
+		t.Fatalf("failed to create backend pool: %v", err)
+	}
+	rules, err := rewrite.New(rewrite.Spec{
+		PathRegex:       "^/legacy(/.*)?$",
+		PathReplacement: "$1",
+		Query:           map[string]string{"source": "lb"},
+		RequestHeaders:  rewrite.HeaderOps{Set: map[string]string{"X-Mounted-At": "/legacy"}},
+		Location:        []rewrite.Replacement{{From: "/", To: "/legacy/"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to compile rules: %v", err)
+	}
+	lbHandler := http.HandlerFunc(pool.LoadBalancerHandler)
+	rt := router.New(lbHandler, []router.Route{{PathPrefix: "/legacy", Rules: rules, Handler: lbHandler}})
+
+	lb := httptest.NewServer(rt)
+	defer lb.Close()
+
+	client := &http.Client{
+		Timeout:       time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp, err := client.Get(lb.URL + "/legacy/app?id=1")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Seen-Path"); got != "/app" {
+		t.Errorf("backend saw path %q; want /app", got)
+	}
+	if got := resp.Header.Get("X-Seen-Query"); got != "id=1&source=lb" {
+		t.Errorf("backend saw query %q", got)
+	}
+	if got := resp.Header.Get("X-Seen-Mount"); got != "/legacy" {
+		t.Errorf("backend saw X-Mounted-At %q", got)
+	}
+	if got := resp.Header.Get("Location"); got != "/legacy/login" {
+		t.Errorf("Location = %q; want /legacy/login", got)
+	}
+
+	// вне маршрута правила не применяются
+	resp, err = client.Get(lb.URL + "/plain")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Seen-Path"); got != "/plain" {
+		t.Errorf("backend saw path %q; want /plain", got)
+	}
+	if got := resp.Header.Get("Location"); got != "/login" {
+		t.Errorf("Location = %q; want /login", got)
+	}
+}
+
+// TestLoadBalancer_CanarySplit проверяет деление трафика между пулами и смену весов через admin API.
+func TestLoadBalancer_CanarySplit(t *testing.T) {
+	newVersion := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Version", name)
+		}))
+	}
+	v1, v2 := newVersion("v1"), newVersion("v2")
+	defer v1.Close()
+	defer v2.Close()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	targets := make([]router.SplitTarget, 0, 2)
+	for name, weight := range map[string]int{"v1": 100, "v2": 0} {
+		backend := v1
+		if name == "v2" {
+			backend = v2
+		}
+		pool, err := backends.NewPool(strategies.NewRoundRobin(), backends.HTTP, []string{backend.URL}, logger)
+		if err != nil {
+			t.Fatalf("failed to create backend pool: %v", err)
+		}
+		targets = append(targets, router.SplitTarget{Name: name, Weight: weight, Handler: http.HandlerFunc(pool.LoadBalancerHandler)})
+	}
+	split, err := router.NewSplit("api", targets, router.Pin{By: router.PinHeader, Key: "X-User"})
+	if err != nil {
+		t.Fatalf("failed to create split: %v", err)
+	}
+	splits := router.NewSplitRegistry()
+	if err := splits.Add(split); err != nil {
+		t.Fatalf("failed to register split: %v", err)
+	}
+
+	splitHandler := &handlers.SplitHandler{Splits: splits, Logger: logger}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/splits", splitHandler.Update)
+	mux.Handle("/", router.New(http.NotFoundHandler(), []router.Route{{PathPrefix: "/api", Handler: split}}))
+	lb := httptest.NewServer(mux)
+	defer lb.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	version := func(user string) string {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/api/items", nil)
+		req.Header.Set("X-User", user)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("X-Version")
+	}
+
+	if v := version("alice"); v != "v1" {
+		t.Fatalf("got %s with v2 weight 0; want v1", v)
+	}
+
+	// переключаем весь трафик на v2 через admin API
+	req, _ := http.NewRequest(http.MethodPut, lb.URL+"/admin/splits?name=api", strings.NewReader(`{"weights":{"v1":0,"v2":100}}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("admin request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin status = %d; want 200", resp.StatusCode)
+	}
+	if v := version("alice"); v != "v2" {
+		t.Errorf("got %s after switching weights; want v2", v)
+	}
+
+	// неизвестная версия отклоняется
+	req, _ = http.NewRequest(http.MethodPut, lb.URL+"/admin/splits?name=api", strings.NewReader(`{"weights":{"v3":1}}`))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("admin request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("admin status = %d; want 400", resp.StatusCode)
+	}
+}
+
+// TestLoadBalancer_AdminBackends проверяет добавление, drain и удаление бекенда через admin API.
+func TestLoadBalancer_AdminBackends(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend", name)
+		}))
+	}
+	b1, b2 := newBackend("b1"), newBackend("b2")
+	defer b1.Close()
+	defer b2.Close()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	pool, err := backends.NewPool(strategies.NewRoundRobin(), backends.HTTP, []string{b1.URL}, logger)
+	if err != nil {
+		t.Fatalf("failed to create backend pool: %v", err)
+	}
+	backendHandler := &handlers.BackendHandler{
+		Pools:  handlers.PoolMap{"default": pool},
+		Logger: logger,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/backends", backendHandler.List)
+	mux.HandleFunc("POST /admin/backends", backendHandler.Add)
+	mux.HandleFunc("PUT /admin/backends", backendHandler.Drain)
+	mux.HandleFunc("DELETE /admin/backends", backendHandler.Remove)
+	mux.HandleFunc("/", pool.LoadBalancerHandler)
+	lb := httptest.NewServer(mux)
+	defer lb.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, lb.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	served := func() map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < 10; i++ {
+			counts[do(http.MethodGet, "/", "").Header.Get("X-Backend")]++
+		}
+		return counts
+	}
+
+	if resp := do(http.MethodPost, "/admin/backends", `{"url":"`+b2.URL+`"}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add status = %d; want 201", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/admin/backends", `{"url":"`+b2.URL+`"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("duplicate add status = %d; want 409", resp.StatusCode)
+	}
+	if counts := served(); counts["b1"] == 0 || counts["b2"] == 0 {
+		t.Errorf("expected traffic on both backends, got %v", counts)
+	}
+
+	b1ID := strings.TrimPrefix(b1.URL, "http://")
+	if resp := do(http.MethodPut, "/admin/backends?url="+b1ID, `{"draining":true}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("drain status = %d; want 200", resp.StatusCode)
+	}
+	if counts := served(); counts["b1"] != 0 {
+		t.Errorf("drained backend still got traffic: %v", counts)
+	}
+
+	if resp := do(http.MethodDelete, "/admin/backends?url="+b1ID, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("remove status = %d; want 204", resp.StatusCode)
+	}
+	if resp := do(http.MethodDelete, "/admin/backends?url="+b1ID, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second remove status = %d; want 404", resp.StatusCode)
+	}
+	if got := len(pool.Backends()); got != 1 {
+		t.Errorf("len(Backends) = %d; want 1", got)
+	}
+}
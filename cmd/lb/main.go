@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,11 +16,14 @@ import (
 	"time"
 
 	"github.com/P1coFly/LoadBalancer/internal/config"
-	"github.com/P1coFly/LoadBalancer/pkg/backends"
-	"github.com/P1coFly/LoadBalancer/pkg/backends/strategies"
+	"github.com/P1coFly/LoadBalancer/pkg/cache"
 	"github.com/P1coFly/LoadBalancer/pkg/client"
+	"github.com/P1coFly/LoadBalancer/pkg/forwarded"
 	"github.com/P1coFly/LoadBalancer/pkg/handlers"
 	"github.com/P1coFly/LoadBalancer/pkg/middleware"
+	"github.com/P1coFly/LoadBalancer/pkg/rewrite"
+	"github.com/P1coFly/LoadBalancer/pkg/upgrade"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -25,40 +34,58 @@ func main() {
 	log.Info("starting loud balancer", "env", cfg.Env)
 	log.Debug("cfg data", "data", cfg)
 
-	// инициализируем репозиторий клиентов
-	clientRepo := client.NewMemoryRepo(cfg.RateLimit.DefaultCapacity, cfg.RateLimit.DefaultRPS, log)
-	go func() {
-		ticker := time.NewTicker(cfg.RateLimit.ReplenishInterval)
-		for range ticker.C {
-			clientRepo.Replenish()
+	// файл bolt открывается один раз и общий для репозиториев клиентов и организаций
+	var boltStore *client.BoltStore
+	if cfg.RateLimit.Repository == "bolt" {
+		var err error
+		if boltStore, err = client.OpenBoltStore(cfg.RateLimit.Bolt.Path); err != nil {
+			log.Error("failed to open rate_limit.bolt", "error", err)
+			os.Exit(1)
 		}
-	}()
-
-	// инициализируем стратегию RoundRobin
-	strat := strategies.NewRoundRobin()
+	}
+	// инициализируем репозитории клиентов, бакетов маршрутов, организаций и общего лимита
+	clientRepo, policyRepo, parents, err := newRepos(cfg.RateLimit, boltStore, log)
+	if err != nil {
+		log.Error("invalid rate_limit config", "error", err)
+		os.Exit(1)
+	}
 
-	// инициализируем пул бекендов
-	backendsPool, err := backends.NewPool(strat, backends.HTTP, cfg.Server.Backends, log)
+	// инициализируем политику forwarded-заголовков
+	fwdPolicy, err := forwarded.NewPolicy(cfg.Proxy.TrustedProxies, cfg.Proxy.ForwardedHeaders, cfg.Proxy.TrustedHeader)
 	if err != nil {
-		log.Error("failed to create backends pool", "error", err)
+		log.Error("invalid proxy config", "error", err)
 		os.Exit(1)
 	}
 
-	// запускаем HealthCheck
+	// инициализируем пулы бекендов, маршруты и HealthCheck. Всё это перечитывается без рестарта
+	lb, err := newBalancer(cfg, fwdPolicy, clientRepo, policyRepo, parents, log)
+	if err != nil {
+		log.Error("failed to init balancer", "error", err)
+		os.Exit(1)
+	}
+	configPath := config.Path()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		ticker := time.NewTicker(cfg.Server.HealthInterval)
-		for range ticker.C {
-			backendsPool.HealthCheck(2 * time.Second)
+		for range hup {
+			log.Info("received SIGHUP, reloading config", "path", configPath)
+			lb.reload(configPath)
 		}
 	}()
+	if cfg.Reload.Watch {
+		if err := lb.watch(configPath, cfg.Reload.Debounce); err != nil {
+			log.Error("failed to watch config file", "path", configPath, "error", err)
+		}
+	}
 
 	// создаём mux
 	mux := http.NewServeMux()
 
 	// Регистрируем CRUD‑хендлеры для /clients
 	clientHandler := &handlers.ClientHandler{
-		Repo:   clientRepo,
-		Logger: log,
+		Repo:    clientRepo,
+		Tenants: parents.Tenants,
+		Logger:  log,
 	}
 
 	clientsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -77,32 +104,320 @@ func main() {
 	})
 	mux.Handle("/clients", middleware.AccessLog(log, clientsHandler))
 
-	// создаём lb-хендлер
-	lbHandlerFunc := http.HandlerFunc(backendsPool.LoadBalancerHandler)
-	lbHandler := middleware.RateLimitMiddleware(clientRepo, log, lbHandlerFunc)
+	// создаём lb-хендлер с маршрутами
+	var proxyHandler http.Handler = lb
+	var httpCache *cache.Cache
+	if cc := cfg.Coalescing; cc.Enabled {
+		proxyHandler = middleware.Coalesce(middleware.CoalesceConfig{
+			VaryHeaders:      cc.VaryHeaders,
+			MaxResponseBytes: cc.MaxResponseBytes,
+		}, proxyHandler)
+	}
+	if cc := cfg.Cache; cc.Enabled {
+		httpCache, err = cache.New(cache.Config{
+			MaxBytes:             cc.MaxBytes,
+			MaxObjectBytes:       cc.MaxObjectBytes,
+			DiskPath:             cc.DiskPath,
+			DiskMaxBytes:         cc.DiskMaxBytes,
+			StaleWhileRevalidate: cc.StaleWhileRevalidate,
+			StaleIfError:         cc.StaleIfError,
+		}, log)
+		if err != nil {
+			log.Error("failed to init cache", "error", err)
+			os.Exit(1)
+		}
+		proxyHandler = httpCache.Handler(proxyHandler)
+	}
+	lbHandler := middleware.RateLimitMiddleware(clientRepo, policyRepo, lb, lb, parents, log, proxyHandler)
+	if cc := cfg.Compression; cc.Enabled {
+		lbHandler, err = middleware.Compress(middleware.CompressConfig{
+			MinSize:   cc.MinSize,
+			Encodings: cc.Encodings,
+			MIMETypes: cc.MIMETypes,
+		}, lbHandler)
+		if err != nil {
+			log.Error("invalid compression config", "error", err)
+			os.Exit(1)
+		}
+	}
 	lbHandler = middleware.AccessLog(log, lbHandler)
 	mux.Handle("/", lbHandler)
 
+	// admin API слушает отдельный адрес, чтобы клиенты прокси до него не дотянулись
+	adminMux := newAdminMux(lb, httpCache, log)
+
 	// инициализируем server и запускаем
 	srv := &http.Server{
 		Addr:         cfg.Server.Port,
-		Handler:      mux,
+		Handler:      middleware.RealIP(fwdPolicy, mux),
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
+	// Слушающий сокет наследуется от старого процесса при обновлении бинаря
+	ln, inherited, err := upgrade.Listen(cfg.Server.Port)
+	if err != nil {
+		log.Error("failed to listen", "addr", cfg.Server.Port, "error", err)
+		os.Exit(1)
+	}
+	// ConnState нужен Handoff, чтобы не бросить соединения без ответа при передаче сокета
+	srv.ConnState = ln.ConnState
+	srv.TLSConfig, err = serverTLSConfig(cfg.Server.TLS)
+	if err != nil {
+		log.Error("invalid server.tls config", "error", err)
+		os.Exit(1)
+	}
+
 	// Запускаем HTTP‑сервер в горутине
 	go func() {
-		log.Info("server starting", "addr", cfg.Server.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Info("server starting", "addr", ln.Addr().String(), "inherited", inherited, "tls", srv.TLSConfig != nil)
+		serve := srv.Serve
+		if srv.TLSConfig != nil {
+			// сертификаты уже в TLSConfig
+			serve = func(l net.Listener) error { return srv.ServeTLS(l, "", "") }
+		}
+		if err := serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Error("server error", "err", err)
 		}
 	}()
+	if inherited {
+		if err := upgrade.Ready(); err != nil {
+			log.Error("failed to notify old process", "error", err)
+		}
+	}
+
+	// счётчики (зеркалирование и т.п.) — на отдельном адресе, не рядом с проксируемыми путями
+	var debugSrv, adminSrv *http.Server
+	if cfg.Server.DebugAddr != "" {
+		debugMux := http.NewServeMux()
+		debugMux.Handle("/debug/vars", expvar.Handler())
+		debugSrv = serveAside("debug", cfg.Server.DebugAddr, debugMux, log)
+	}
+	if cfg.Server.AdminAddr != "" {
+		adminSrv = serveAside("admin", cfg.Server.AdminAddr, middleware.AdminAuth(cfg.Server.AdminToken, adminMux), log)
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	handleUpgrade(ln, boltStore, log, stop)
 	gracefulShutdown(srv, log, 15*time.Second, stop)
+	for _, aside := range []*http.Server{debugSrv, adminSrv} {
+		if aside != nil {
+			_ = aside.Close()
+		}
+	}
+	if boltStore != nil {
+		if err := boltStore.Close(); err != nil {
+			log.Error("failed to close rate_limit.bolt", "error", err)
+		}
+	}
+}
+
+// serveAside запускает вспомогательный сервер name (счётчики, admin API) на addr.
+// При обновлении бинаря адрес ещё занят старым процессом, поэтому новый ждёт,
+// пока тот его освободит
+func serveAside(name, addr string, h http.Handler, log *slog.Logger) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Warn("address is busy, retrying", "server", name, "addr", addr, "error", err)
+		}
+		for err != nil {
+			time.Sleep(time.Second)
+			ln, err = net.Listen("tcp", addr)
+		}
+		log.Info("server starting", "server", name, "addr", ln.Addr().String())
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error("server error", "server", name, "err", err)
+		}
+	}()
+	return srv
+}
+
+// newAdminMux регистрирует хендлеры admin API; /admin/cache — только с httpCache
+func newAdminMux(lb *balancer, httpCache *cache.Cache, log *slog.Logger) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Регистрируем хендлеры управления составом пулов
+	backendHandler := &handlers.BackendHandler{Pools: lb, Logger: log}
+	mux.Handle("/admin/backends", middleware.AccessLog(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			backendHandler.List(w, r)
+		case http.MethodPost:
+			backendHandler.Add(w, r)
+		case http.MethodPut:
+			backendHandler.Drain(w, r)
+		case http.MethodDelete:
+			backendHandler.Remove(w, r)
+		default:
+			handlers.SendJSONError(w, http.StatusMethodNotAllowed, "Allow: GET, POST, PUT, DELETE")
+		}
+	})))
+
+	// Регистрируем хендлеры управления весами сплитов
+	splitHandler := &handlers.SplitHandler{Splits: lb.splits, Logger: log}
+	mux.Handle("/admin/splits", middleware.AccessLog(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			splitHandler.Get(w, r)
+		case http.MethodPut:
+			splitHandler.Update(w, r)
+		default:
+			handlers.SendJSONError(w, http.StatusMethodNotAllowed, "Allow: GET, PUT")
+		}
+	})))
+
+	// Регистрируем CRUD‑хендлеры для /tenants
+	tenantHandler := &handlers.TenantHandler{Repo: lb.parents.Tenants, Logger: log}
+	mux.Handle("/tenants", middleware.AccessLog(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			tenantHandler.Create(w, r)
+		case http.MethodGet:
+			tenantHandler.Get(w, r)
+		case http.MethodPut:
+			tenantHandler.Update(w, r)
+		case http.MethodDelete:
+			tenantHandler.Delete(w, r)
+		default:
+			handlers.SendJSONError(w, http.StatusMethodNotAllowed, "Allow: POST, GET, PUT, DELETE")
+		}
+	})))
+
+	if httpCache != nil {
+		// Регистрируем хендлер очистки кеша
+		cacheHandler := &handlers.CacheHandler{Cache: httpCache, Logger: log}
+		mux.Handle("/admin/cache", middleware.AccessLog(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodDelete {
+				handlers.SendJSONError(w, http.StatusMethodNotAllowed, "Allow: DELETE")
+				return
+			}
+			cacheHandler.Purge(w, r)
+		})))
+	}
+
+	return mux
+}
+
+// handleUpgrade по сигналу (SIGUSR2) запускает новую версию бинаря с тем же
+// сокетом. Когда она готова, текущий процесс уходит в graceful shutdown через stop.
+// Файл bolt (если есть) на это время закрывается, чтобы новый процесс смог его открыть
+func handleUpgrade(ln *upgrade.Listener, boltStore *client.BoltStore, log *slog.Logger, stop chan<- os.Signal) {
+	signals := upgrade.Signals()
+	if len(signals) == 0 {
+		return
+	}
+	upg := make(chan os.Signal, 1)
+	signal.Notify(upg, signals...)
+	go func() {
+		for sig := range upg {
+			log.Info("received signal, starting binary upgrade", "signal", sig)
+			if boltStore != nil {
+				if err := boltStore.Close(); err != nil {
+					log.Error("failed to close rate_limit.bolt for upgrade", "error", err)
+				}
+			}
+			child, err := upgrade.Spawn(ln, 30*time.Second)
+			if err != nil {
+				log.Error("binary upgrade failed, keep serving", "error", err)
+				if boltStore != nil {
+					if err := boltStore.Reopen(); err != nil {
+						log.Error("failed to reopen rate_limit.bolt, client changes are rejected until restart", "error", err)
+					}
+				}
+				continue
+			}
+			log.Info("new process is ready, draining", "child_pid", child.Pid)
+			ln.Handoff(5 * time.Second)
+			signal.Stop(upg)
+			select {
+			case stop <- sig:
+			default: // уже завершаемся по другому сигналу
+			}
+			return
+		}
+	}()
+}
+
+// newRepos создаёт репозитории клиентов, бакетов маршрутов, организаций и общего лимита
+// в хранилище rc.Repository; для bolt — в открытом файле boltStore. Бакеты маршрутов
+// хранятся отдельно от клиентов, чтобы их не было видно через /clients. Общий лимит и
+// бакеты маршрутов задаются конфигом, поэтому в bolt не сохраняются
+func newRepos(rc config.RateLimit, boltStore *client.BoltStore, log *slog.Logger) (client.ClientRepo, client.ClientRepo, *client.Hierarchy, error) {
+	tc := rc.Tenants
+	switch rc.Repository {
+	case "", "memory":
+		return client.NewMemoryRepo(rc.DefaultCapacity, rc.DefaultRPS, log), client.NewMemoryRepo(0, 0, log), client.NewHierarchy(
+			client.NewMemoryRepo(tc.DefaultCapacity, tc.DefaultRPS, log), client.NewMemoryRepo(0, 0, log)), nil
+	case "redis":
+		fallback, err := client.ParseFallback(rc.Redis.Fallback)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rdb := redis.NewClient(&redis.Options{
+			Addr:         rc.Redis.Addr,
+			Username:     rc.Redis.Username,
+			Password:     rc.Redis.Password,
+			DB:           rc.Redis.DB,
+			DialTimeout:  rc.Redis.Timeout,
+			ReadTimeout:  rc.Redis.Timeout,
+			WriteTimeout: rc.Redis.Timeout,
+		})
+		opts := client.RedisOptions{Prefix: rc.Redis.Prefix, Fallback: fallback, Retry: rc.Redis.Retry}
+		withPrefix := func(prefix string) client.RedisOptions {
+			o := opts
+			o.Prefix += prefix
+			return o
+		}
+		parents := client.NewHierarchy(
+			client.NewRedisRepo(rdb, tc.DefaultCapacity, tc.DefaultRPS, withPrefix("tenant:"), log),
+			client.NewRedisRepo(rdb, 0, 0, withPrefix("global:"), log))
+		return client.NewRedisRepo(rdb, rc.DefaultCapacity, rc.DefaultRPS, opts, log),
+			client.NewRedisRepo(rdb, 0, 0, withPrefix("policy:"), log), parents, nil
+	case "bolt":
+		clients, err := client.NewBoltRepo(boltStore, "clients", rc.DefaultCapacity, rc.DefaultRPS, log)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		tenants, err := client.NewBoltRepo(boltStore, "tenants", tc.DefaultCapacity, tc.DefaultRPS, log)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return clients, client.NewMemoryRepo(0, 0, log), client.NewHierarchy(tenants, client.NewMemoryRepo(0, 0, log)), nil
+	}
+	return nil, nil, nil, fmt.Errorf("unknown repository %q: want memory, redis or bolt", rc.Repository)
+}
+
+// serverTLSConfig собирает TLS сервера, nil — без cert_file (обычный HTTP). С CA
+// клиентов сертификат не обязателен, но присланный проверяется: без него клиента
+// определят следующие источники identity
+func serverTLSConfig(c config.ServerTLS) (*tls.Config, error) {
+	if c.CertFile == "" {
+		if c.KeyFile != "" || c.ClientCAFile != "" {
+			return nil, errors.New("cert_file is required")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientCAs = x509.NewCertPool()
+		if !tc.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.ClientCAFile)
+		}
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
 }
 
 // setupLogger инициализирует логер *slog.Logger
@@ -123,6 +438,28 @@ func setupLogger(env string) *slog.Logger {
 	return log
 }
 
+func rewriteSpec(rc config.Rewrite) rewrite.Spec {
+	replacements := func(rs []config.Replacement) []rewrite.Replacement {
+		out := make([]rewrite.Replacement, 0, len(rs))
+		for _, r := range rs {
+			out = append(out, rewrite.Replacement{From: r.From, To: r.To})
+		}
+		return out
+	}
+	headerOps := func(h config.HeaderOps) rewrite.HeaderOps {
+		return rewrite.HeaderOps{Set: h.Set, Add: h.Add, Remove: h.Remove}
+	}
+	return rewrite.Spec{
+		RequestHeaders:  headerOps(rc.RequestHeaders),
+		PathRegex:       rc.Path.Regex,
+		PathReplacement: rc.Path.Replacement,
+		Query:           rc.Query,
+		ResponseHeaders: headerOps(rc.ResponseHeaders),
+		Location:        replacements(rc.Location),
+		CookieDomain:    replacements(rc.CookieDomain),
+	}
+}
+
 func gracefulShutdown(srv *http.Server, logger *slog.Logger, timeout time.Duration, stopCh <-chan os.Signal) {
 	sig := <-stopCh
 	logger.Info("received signal, shutting down", "signal", sig)
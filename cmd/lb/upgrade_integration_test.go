@@ -0,0 +1,164 @@
+//go:build unix
+
+package main_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestLoadBalancer_BinaryUpgrade запускает бинарь, по SIGUSR2 передаёт сокет новой
+// копии и проверяет, что во время передачи ни одно соединение не было отклонено.
+func TestLoadBalancer_BinaryUpgrade(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and spawns the binary")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "lb")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfgPath := filepath.Join(dir, "config.yml")
+	cfg := fmt.Sprintf(`env: "prod"
+server:
+  port: %q
+  backends: [ %q ]
+rate_limit:
+  default_capacity: 1000000
+  default_rps: 1000000
+`, addr, backend.URL)
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// лог пишем в файл: пайп закрылся бы вместе со старым процессом, а новый
+	// наследует тот же stdout
+	logPath := filepath.Join(dir, "lb.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	old := exec.Command(bin)
+	old.Env = append(os.Environ(), "CONFIG_PATH="+cfgPath)
+	old.Stdout = logFile
+	old.Stderr = logFile
+	if err := old.Start(); err != nil {
+		t.Fatal(err)
+	}
+	childPID := func() int {
+		re := regexp.MustCompile(`"child_pid":(\d+)`)
+		data, _ := os.ReadFile(logPath)
+		if m := re.FindSubmatch(data); m != nil {
+			pid, _ := strconv.Atoi(string(m[1]))
+			return pid
+		}
+		return 0
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() error {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for get() != nil {
+		if time.Now().After(deadline) {
+			old.Process.Kill()
+			t.Fatal("balancer did not start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// непрерывная нагрузка на время передачи сокета
+	var stop atomic.Bool
+	var ok, failed atomic.Int64
+	var firstErr atomic.Value
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if err := get(); err != nil {
+					failed.Add(1)
+					firstErr.CompareAndSwap(nil, err.Error())
+				} else {
+					ok.Add(1)
+				}
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := old.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	deadline = time.Now().Add(10 * time.Second)
+	for pid = childPID(); pid == 0; pid = childPID() {
+		if time.Now().After(deadline) {
+			old.Process.Kill()
+			t.Fatal("new process did not report readiness")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer func() {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Signal(syscall.SIGTERM)
+		}
+	}()
+
+	exited := make(chan error, 1)
+	go func() { exited <- old.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(20 * time.Second):
+		old.Process.Kill()
+		t.Fatal("old process did not exit after upgrade")
+	}
+
+	// после выхода старого процесса обслуживает новый
+	time.Sleep(200 * time.Millisecond)
+	stop.Store(true)
+	wg.Wait()
+
+	if failed.Load() > 0 {
+		t.Errorf("%d of %d requests failed during upgrade, first: %v", failed.Load(), failed.Load()+ok.Load(), firstErr.Load())
+	}
+	if err := get(); err != nil {
+		t.Errorf("new process does not serve: %v", err)
+	}
+}
@@ -12,8 +12,79 @@ import (
 type Config struct {
 	Env string `yaml:"env" env-required:"true"`
 
-	Server    Server    `yaml:"server"`
-	RateLimit RateLimit `yaml:"rate_limit"`
+	Server      Server          `yaml:"server"`
+	Pools       map[string]Pool `yaml:"pools"`
+	Proxy       Proxy           `yaml:"proxy"`
+	RateLimit   RateLimit       `yaml:"rate_limit"`
+	Routes      []Route         `yaml:"routes"`
+	Compression Compression     `yaml:"compression"`
+	Cache       Cache           `yaml:"cache"`
+	Coalescing  Coalescing      `yaml:"coalescing"`
+	Mirror      Mirror          `yaml:"mirror"`
+	Reload      Reload          `yaml:"reload"`
+	Discovery   Discovery       `yaml:"discovery"`
+}
+
+// Discovery содержит настройки обнаружения бекендов. Вместо адреса в списке бекендов
+// пула можно указать источник: dns+srv://_http._tcp.api.internal, dns+a://api.internal:8080,
+// file:///etc/lb/api.yaml или poll+https://deploy.internal/instances
+type Discovery struct {
+	// StartTimeout — сколько ждать первый состав от источников при применении конфига
+	StartTimeout time.Duration       `yaml:"start_timeout" env-default:"10s"`
+	DNS          DNSDiscovery        `yaml:"dns"`
+	HTTP         HTTPDiscovery       `yaml:"http"`
+	File         FileDiscovery       `yaml:"file"`
+	Consul       ConsulDiscovery     `yaml:"consul"`
+	Etcd         EtcdDiscovery       `yaml:"etcd"`
+	Kubernetes   KubernetesDiscovery `yaml:"kubernetes"`
+}
+
+// KubernetesDiscovery — настройки обнаружения через EndpointSlice
+type KubernetesDiscovery struct {
+	// Kubeconfig — путь к kubeconfig; пустой — конфигурация пода (in-cluster)
+	Kubeconfig string        `yaml:"kubeconfig" env:"KUBECONFIG"`
+	Timeout    time.Duration `yaml:"timeout" env-default:"5s"`
+	Retry      time.Duration `yaml:"retry" env-default:"1s"`
+}
+
+// ConsulDiscovery — настройки обнаружения через Consul
+type ConsulDiscovery struct {
+	Token string        `yaml:"token" env:"CONSUL_HTTP_TOKEN"`
+	Wait  time.Duration `yaml:"wait" env-default:"5m"`
+	Retry time.Duration `yaml:"retry" env-default:"1s"`
+}
+
+// EtcdDiscovery — настройки обнаружения через etcd
+type EtcdDiscovery struct {
+	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+	Retry   time.Duration `yaml:"retry" env-default:"1s"`
+}
+
+// HTTPDiscovery — настройки опроса HTTP-источников
+type HTTPDiscovery struct {
+	Interval time.Duration `yaml:"interval" env-default:"10s"`
+	Timeout  time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// FileDiscovery — настройки файловых источников
+type FileDiscovery struct {
+	Debounce time.Duration `yaml:"debounce" env-default:"200ms"`
+}
+
+// DNSDiscovery — настройки DNS-обнаружения
+type DNSDiscovery struct {
+	// Server — DNS-сервер host:port, пустой — из /etc/resolv.conf
+	Server     string        `yaml:"server"`
+	Timeout    time.Duration `yaml:"timeout" env-default:"2s"`
+	MinRefresh time.Duration `yaml:"min_refresh" env-default:"1s"`
+	MaxRefresh time.Duration `yaml:"max_refresh" env-default:"60s"`
+}
+
+// Reload содержит настройки перезагрузки конфига без рестарта (по SIGHUP доступна всегда)
+type Reload struct {
+	// Watch — перечитывать конфиг при изменении файла
+	Watch    bool          `yaml:"watch"`
+	Debounce time.Duration `yaml:"debounce" env-default:"500ms"`
 }
 
 // Server содержит настройки HTTP-сервера
@@ -23,21 +94,243 @@ type Server struct {
 	WriteTimeout   time.Duration `yaml:"timeouts.write" env-default:"10s"`
 	IdleTimeout    time.Duration `yaml:"timeouts.idle" env-default:"60s"`
 	HealthInterval time.Duration `yaml:"health_interval" env-default:"30s"`
+	Strategy       string        `yaml:"strategy" env-default:"round_robin"`
 	Backends       []string      `yaml:"backends" env-required:"true"`
+	StickySession  StickySession `yaml:"sticky_session"`
+	TLS            ServerTLS     `yaml:"tls"`
+	// DebugAddr — отдельный адрес для /debug/vars, пустой — счётчики не отдаются
+	DebugAddr string `yaml:"debug_addr"`
+	// AdminAddr — отдельный адрес admin API (/admin/...), пустой — admin API выключен
+	AdminAddr string `yaml:"admin_addr" env-default:"127.0.0.1:8090"`
+	// AdminToken — если задан, admin API требует "Authorization: Bearer <token>"
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+}
+
+// ServerTLS включает HTTPS на server.port. С ClientCAFile сервер запрашивает клиентский
+// сертификат и проверяет его, если клиент его прислал (нужно для identity типа mtls)
+type ServerTLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// Pool описывает именованный пул бекендов (версию приложения) для разделения трафика.
+// Пул из server.backends доступен под именем "default"
+type Pool struct {
+	Backends      []string      `yaml:"backends"`
+	StickySession StickySession `yaml:"sticky_session"`
+}
+
+// StickySession содержит настройки привязки клиента к бекенду через cookie
+type StickySession struct {
+	Enabled bool `yaml:"enabled"`
+	// CookieName — пустое: lb_backend у server, lb_backend_<имя пула> у пулов из pools
+	CookieName string        `yaml:"cookie_name"`
+	Secret     string        `yaml:"secret" env:"STICKY_SESSION_SECRET"`
+	TTL        time.Duration `yaml:"ttl" env-default:"0s"`
+}
+
+// Proxy содержит настройки работы за другими прокси
+type Proxy struct {
+	// TrustedProxies — CIDR (или IP) прокси, чьим X-Forwarded-For / Forwarded мы доверяем
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// ForwardedHeaders — append (дописывать хоп к цепочке) или overwrite (схлопнуть до клиента)
+	ForwardedHeaders string `yaml:"forwarded_headers" env-default:"append"`
+	// TrustedHeader — xff или forwarded: из какого заголовка доверенного прокси брать цепочку клиентов
+	TrustedHeader string `yaml:"trusted_header" env-default:"xff"`
+}
+
+// Compression содержит настройки сжатия проксируемых ответов
+type Compression struct {
+	Enabled   bool     `yaml:"enabled"`
+	MinSize   int      `yaml:"min_size" env-default:"1024"`
+	Encodings []string `yaml:"encodings"`
+	MIMETypes []string `yaml:"mime_types"`
+}
+
+// Cache содержит настройки HTTP-кеша для GET-ответов бекендов
+type Cache struct {
+	Enabled              bool          `yaml:"enabled"`
+	MaxBytes             int64         `yaml:"max_bytes" env-default:"67108864"`
+	MaxObjectBytes       int64         `yaml:"max_object_bytes" env-default:"1048576"`
+	DiskPath             string        `yaml:"disk_path"`
+	DiskMaxBytes         int64         `yaml:"disk_max_bytes" env-default:"1073741824"`
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate" env-default:"0s"`
+	StaleIfError         time.Duration `yaml:"stale_if_error" env-default:"0s"`
+}
+
+// Coalescing содержит настройки схлопывания одинаковых одновременных GET-запросов
+type Coalescing struct {
+	Enabled          bool     `yaml:"enabled"`
+	VaryHeaders      []string `yaml:"vary_headers"`
+	MaxResponseBytes int64    `yaml:"max_response_bytes" env-default:"1048576"`
+}
+
+// Mirror содержит настройки зеркалирования трафика в теневой пул
+type Mirror struct {
+	Enabled        bool          `yaml:"enabled"`
+	Percent        float64       `yaml:"percent" env-default:"0"`
+	Backends       []string      `yaml:"backends"`
+	MaxConcurrency int           `yaml:"max_concurrency" env-default:"50"`
+	MaxBodyBytes   int64         `yaml:"max_body_bytes" env-default:"1048576"`
+	Timeout        time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+// Route описывает маршрут по префиксу пути и правила перезаписи для него
+type Route struct {
+	PathPrefix string  `yaml:"path_prefix"`
+	Rewrite    Rewrite `yaml:"rewrite"`
+	// Pool — именованный пул для маршрута, пустой — пул по умолчанию
+	Pool  string `yaml:"pool"`
+	Split Split  `yaml:"split"`
+}
+
+// Split делит трафик маршрута между пулами по весам (canary / blue-green)
+type Split struct {
+	// Name — имя для admin API, по умолчанию префикс маршрута
+	Name    string         `yaml:"name"`
+	Weights map[string]int `yaml:"weights"`
+	// PinBy — header | cookie | client_id: закрепление клиента за версией, пустой — без закрепления
+	PinBy  string `yaml:"pin_by"`
+	PinKey string `yaml:"pin_key"`
+}
+
+// Rewrite содержит правила перезаписи запроса и ответа
+type Rewrite struct {
+	RequestHeaders  HeaderOps         `yaml:"request_headers"`
+	Path            PathRewrite       `yaml:"path"`
+	Query           map[string]string `yaml:"query"`
+	ResponseHeaders HeaderOps         `yaml:"response_headers"`
+	Location        []Replacement     `yaml:"location"`
+	CookieDomain    []Replacement     `yaml:"cookie_domain"`
+}
+
+// HeaderOps — добавить, заменить или удалить заголовки
+type HeaderOps struct {
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+	Remove []string          `yaml:"remove"`
+}
+
+// PathRewrite — замена пути по регулярному выражению
+type PathRewrite struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+}
+
+// Replacement — замена префикса from на to
+type Replacement struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
 }
 
 // RateLimit содержит параметры Token Bucket
 type RateLimit struct {
-	DefaultCapacity   int           `yaml:"default_capacity" env-default:"10"`
-	DefaultRPS        int           `yaml:"default_rps" env-default:"1"`
-	ReplenishInterval time.Duration `yaml:"replenish_interval" env-default:"1s"`
+	// Repository — где хранятся клиенты: memory (в процессе), redis (общие лимиты для реплик)
+	// или bolt (настройки клиентов сохраняются в файл и переживают перезапуск)
+	Repository      string         `yaml:"repository" env-default:"memory"`
+	Redis           RedisRateLimit `yaml:"redis"`
+	Bolt            BoltRateLimit  `yaml:"bolt"`
+	DefaultCapacity int            `yaml:"default_capacity" env-default:"10"`
+	// DefaultRPS — скорость пополнения, допускаются дробные значения (0.5 — токен в 2 секунды)
+	DefaultRPS float64 `yaml:"default_rps" env-default:"1"`
+	// IdleTTL — через сколько простоя удаляется клиент, созданный на первом запросе; 0 — никогда.
+	// Клиенты из POST /clients не удаляются
+	IdleTTL time.Duration `yaml:"idle_ttl" env-default:"10m"`
+	// MaxClients — лимит автоматически созданных клиентов, лишние вытесняются по LRU; 0 — без лимита
+	MaxClients int      `yaml:"max_clients" env-default:"100000"`
+	Identity   Identity `yaml:"identity"`
+	// Policies — стоимость и отдельные лимиты для маршрутов
+	Policies []RatePolicy `yaml:"policies"`
+	Tenants  Tenants      `yaml:"tenants"`
+	Global   GlobalLimit  `yaml:"global"`
+}
+
+// Tenants — лимиты организаций. Сами организации заводятся через /tenants и хранятся
+// в том же repository, что и клиенты
+type Tenants struct {
+	// DefaultCapacity и DefaultRPS — для организации, на которую ссылается клиент, но
+	// которой нет в /tenants (например, удалённой)
+	DefaultCapacity int     `yaml:"default_capacity" env-default:"1000"`
+	DefaultRPS      float64 `yaml:"default_rps" env-default:"100"`
+}
+
+// GlobalLimit — общий лимит на все запросы балансировщика, с redis — на все реплики.
+// Capacity 0 — выключен
+type GlobalLimit struct {
+	Algorithm string  `yaml:"algorithm"`
+	Capacity  int     `yaml:"capacity"`
+	RPS       float64 `yaml:"rate_per_sec"`
+}
+
+// RatePolicy — лимит для запросов, подходящих под шаблон
+type RatePolicy struct {
+	// Pattern — шаблон http.ServeMux "[METHOD ][host]/path": "POST /upload", "GET /items/{id}", "/api/"
+	Pattern string `yaml:"pattern"`
+	// Name — имя политики, по умолчанию Pattern; отдельный бакет клиента — "<client_id>#<name>"
+	Name string `yaml:"name"`
+	// Cost — сколько токенов стоит запрос, по умолчанию 1
+	Cost int `yaml:"cost"`
+	// Capacity и RPS задают отдельный бакет маршрута на каждого клиента; без них Cost
+	// списывается с основного лимита клиента
+	Algorithm string  `yaml:"algorithm"`
+	Capacity  int     `yaml:"capacity"`
+	RPS       float64 `yaml:"rate_per_sec"`
+}
+
+// Identity — как определить клиента для лимитов. Без источников — по IP
+type Identity struct {
+	// Sources — источники по порядку, первый найденный в запросе задаёт клиента
+	Sources []IdentitySource `yaml:"sources"`
+	// Fallback — ip (клиент по IP) или none (401), если источники ничего не нашли
+	Fallback string `yaml:"fallback" env-default:"ip"`
+	// Unknown — default (лимиты по умолчанию) или reject (403) для ключей, не заведённых через /clients.
+	// Пусто — reject, если есть источник header или query (иначе каждый новый ключ — свежий бакет), или default
+	Unknown string `yaml:"unknown"`
+}
+
+// IdentitySource — источник ID клиента
+type IdentitySource struct {
+	// Type — header (API-ключ), query, jwt или mtls
+	Type string `yaml:"type"`
+	// Name — заголовок для header и jwt (по умолчанию Authorization) или параметр для query
+	Name string `yaml:"name"`
+	// Claim — claim JWT с ID клиента, по умолчанию sub
+	Claim string `yaml:"claim"`
+	// Secret или SecretEnv (имя переменной окружения) — ключ HMAC для JWT HS*
+	Secret    string `yaml:"secret"`
+	SecretEnv string `yaml:"secret_env"`
+	// PublicKeyFile — PEM с ключом RSA/ECDSA или сертификатом для JWT RS*, PS*, ES*
+	PublicKeyFile string `yaml:"public_key_file"`
+	// Field — cn или dn для mtls
+	Field string `yaml:"field"`
+	// Prefix дописывается к ID, чтобы ключи разных источников не совпадали
+	Prefix string `yaml:"prefix"`
+}
+
+// RedisRateLimit — подключение к Redis для общих лимитов
+type RedisRateLimit struct {
+	Addr     string `yaml:"addr" env-default:"localhost:6379"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db"`
+	// Prefix — префикс ключей, чтобы несколько инсталляций делили один Redis
+	Prefix  string        `yaml:"prefix" env-default:"lb:"`
+	Timeout time.Duration `yaml:"timeout" env-default:"100ms"`
+	// Retry — сколько после ошибки не обращаться к Redis
+	Retry time.Duration `yaml:"retry" env-default:"1s"`
+	// Fallback — local | open | closed: лимиты реплики, пропускать всё или отклонять всё
+	Fallback string `yaml:"fallback" env-default:"local"`
+}
+
+// BoltRateLimit — файл BoltDB с настройками клиентов
+type BoltRateLimit struct {
+	Path string `yaml:"path" env-default:"data/clients.db"`
 }
 
 // MustLoad читает конфиг из файла и проводит валидацию. Путь до конфига берёт из переменой окружения CONFIG_PATH
 func MustLoad() *Config {
-	var cfg Config
-
-	configPath := os.Getenv("CONFIG_PATH")
+	configPath := Path()
 	log.Printf("%s", configPath)
 	if configPath == "" {
 		log.Fatal("CONFIG_PATH is not set")
@@ -47,9 +340,24 @@ func MustLoad() *Config {
 		log.Fatalf("config file does not exist: %s", configPath)
 	}
 
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
+	cfg, err := Load(configPath)
+	if err != nil {
 		log.Fatalf("can't read config: %s", err)
 	}
 
-	return &cfg
+	return cfg
+}
+
+// Path возвращает путь до конфига из переменой окружения CONFIG_PATH
+func Path() string {
+	return os.Getenv("CONFIG_PATH")
+}
+
+// Load читает конфиг из файла. Используется и при старте, и при перезагрузке
+func Load(path string) (*Config, error) {
+	var cfg Config
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
 }
@@ -2,32 +2,68 @@ package httpbackend
 
 import (
 	"net"
+	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"sync"
 	"time"
+
+	"github.com/P1coFly/LoadBalancer/pkg/forwarded"
+	"github.com/P1coFly/LoadBalancer/pkg/rewrite"
 )
 
 // Структура HTTP бекенда. Реализовывает интерфейс Backend
 type backend struct {
-	url   *url.URL
-	alive bool
-	mu    sync.RWMutex
-	rp    *httputil.ReverseProxy
+	url       *url.URL
+	alive     bool
+	mu        sync.RWMutex
+	rp        *httputil.ReverseProxy
+	forwarded *forwarded.Policy
+}
+
+// Option настраивает бекенд при создании
+type Option func(*backend)
+
+// WithForwarded задаёт политику выставления X-Forwarded-* / Forwarded
+func WithForwarded(p *forwarded.Policy) Option {
+	return func(b *backend) {
+		b.forwarded = p
+	}
 }
 
 // Создаёт и возвращает новый http бекенд
-func NewBackend(rawUrl string) (*backend, error) {
+func NewBackend(rawUrl string, opts ...Option) (*backend, error) {
 	parsedURL, err := url.Parse(rawUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	return &backend{
+	b := &backend{
 		url:   parsedURL,
 		alive: true,
-		rp:    httputil.NewSingleHostReverseProxy(parsedURL),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	// переписываем URL так же, как NewSingleHostReverseProxy, а forwarded-заголовки
+	// выставляем сами, поэтому используем Rewrite вместо Director.
+	// Правила маршрута (rewrite.Rules) приходят через контекст запроса
+	director := httputil.NewSingleHostReverseProxy(parsedURL).Director
+	b.rp = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			rules := rewrite.FromContext(pr.In.Context())
+			rules.RewriteURL(pr.Out.URL)
+			director(pr.Out)
+			b.forwarded.Apply(pr.Out, pr.In)
+			rules.ApplyRequestHeaders(pr.Out.Header)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rewrite.FromContext(resp.Request.Context()).ApplyResponse(resp)
+			return nil
+		},
+	}
+	return b, nil
 }
 
 func (b *backend) SetAlive(alive bool) {
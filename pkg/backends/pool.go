@@ -5,11 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	httpbackend "github.com/P1coFly/LoadBalancer/pkg/backends/http"
+	"github.com/P1coFly/LoadBalancer/pkg/backends/state"
+	"github.com/P1coFly/LoadBalancer/pkg/discovery"
+	"github.com/P1coFly/LoadBalancer/pkg/forwarded"
 	"github.com/P1coFly/LoadBalancer/pkg/handlers"
 )
 
@@ -19,6 +26,7 @@ type contextKey string
 const (
 	HTTP        BackendType = "HTTP"
 	AttemptsKey contextKey  = "attempts"
+	RequestKey  contextKey  = "request"
 	MaxRetries  int         = 3
 )
 
@@ -26,6 +34,10 @@ var (
 	ErrWrongType    = errors.New("unsupported backend type")
 	ErrNoBackends   = errors.New("at least one backend required")
 	ErrInvalidInput = errors.New("invalid input parameters")
+	// ошибки состава пула общие с admin API, чтобы оно различало их без импорта пулов
+	ErrBackendExist   = state.ErrExists
+	ErrNoBackend      = state.ErrNotFound
+	ErrBackendInvalid = state.ErrInvalid
 )
 
 type Backend interface {
@@ -41,57 +53,427 @@ type Strategy interface {
 }
 
 type BackendsPool struct {
-	backends []Backend
-	strategy Strategy
+	// members меняется целиком (copy-on-write), чтобы Next и HealthCheck
+	// читали снимок без блокировок. mu сериализует только изменения
+	members  atomic.Pointer[poolMembers]
+	mu       sync.Mutex
+	bType    BackendType
+	strategy atomic.Pointer[strategyBox]
 	Logger   *slog.Logger
+	httpOpts []httpbackend.Option
+	sticky   *StickySessions
 }
 
-func NewPool(strategy Strategy, bType BackendType, urls []string, logger *slog.Logger) (*BackendsPool, error) {
+// strategyBox позволяет атомарно подменять стратегию любого типа
+type strategyBox struct {
+	Strategy
+}
+
+// poolMembers — неизменяемый снимок состава пула
+type poolMembers struct {
+	all []*member
+	// active — бекенды не в drain-режиме, среди них стратегия выбирает новый запрос.
+	// Бекенд с весом w встречается w раз (после сокращения весов на общий делитель),
+	// вперемешку с остальными, как в smooth weighted round-robin
+	active []Backend
+}
+
+// member — бекенд пула с состоянием drain и счётчиком запросов в работе
+type member struct {
+	Backend
+	draining atomic.Bool
+	inFlight atomic.Int64
+	// retired — бекенд пропал из обнаружения и будет удалён, когда запросы на нём доработают
+	retired atomic.Bool
+	// terminating — обнаружение сообщило, что экземпляр завершается; drain снимается,
+	// когда экземпляр снова станет готовым
+	terminating atomic.Bool
+	// weight и metadata задаёт обнаружение, у остальных бекендов вес 1
+	weight   atomic.Int64
+	metadata atomic.Pointer[map[string]string]
+}
+
+func newMember(b Backend) *member {
+	m := &member{Backend: b}
+	m.weight.Store(1)
+	return m
+}
+
+// PoolOption настраивает пул при создании
+type PoolOption func(*BackendsPool)
+
+// WithForwarded задаёт политику forwarded-заголовков для HTTP бекендов пула
+func WithForwarded(p *forwarded.Policy) PoolOption {
+	return func(bp *BackendsPool) {
+		bp.httpOpts = append(bp.httpOpts, httpbackend.WithForwarded(p))
+	}
+}
+
+func NewPool(strategy Strategy, bType BackendType, urls []string, logger *slog.Logger, opts ...PoolOption) (*BackendsPool, error) {
 	if len(urls) == 0 {
 		return nil, fmt.Errorf("%w: %s", ErrInvalidInput, "empty URLs list")
 	}
 
-	var bs []Backend
-	var err error
 	bp := &BackendsPool{
-		strategy: strategy,
-		Logger:   logger,
+		bType:  bType,
+		Logger: logger,
+	}
+	bp.SetStrategy(strategy)
+	for _, opt := range opts {
+		opt(bp)
+	}
+
+	bs, err := bp.createBackends(urls)
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*member, 0, len(bs))
+	for _, b := range bs {
+		ms = append(ms, newMember(b))
+	}
+	bp.members.Store(newPoolMembers(ms))
+	return bp, nil
+}
+
+func newPoolMembers(all []*member) *poolMembers {
+	pm := &poolMembers{all: all}
+	var active []*member
+	for _, m := range all {
+		if !m.draining.Load() {
+			active = append(active, m)
+		}
 	}
+	pm.active = expandWeights(active)
+	return pm
+}
 
-	switch bType {
+// expandWeights раскладывает бекенды в цикл длиной в сумму весов так, чтобы
+// бекенд с большим весом не шёл подряд (smooth weighted round-robin)
+func expandWeights(ms []*member) []Backend {
+	div := int64(0)
+	for _, m := range ms {
+		div = gcd(div, m.weight.Load())
+	}
+	total := int64(0)
+	weights := make([]int64, len(ms))
+	for i, m := range ms {
+		weights[i] = m.weight.Load() / div
+		total += weights[i]
+	}
+	out := make([]Backend, 0, total)
+	current := make([]int64, len(ms))
+	for range total {
+		best := 0
+		for i := range ms {
+			current[i] += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		out = append(out, ms[best])
+	}
+	return out
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func (p *BackendsPool) createBackends(urls []string) ([]Backend, error) {
+	switch p.bType {
 	case HTTP:
-		bs, err = createHTTPBackends(urls, bp)
+		bs, err := createHTTPBackends(urls, p)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create HTTP backends: %w", err)
 		}
+		return bs, nil
 	default:
-		return nil, fmt.Errorf("%w: %s", ErrWrongType, bType)
+		return nil, fmt.Errorf("%w: %s", ErrWrongType, p.bType)
 	}
-
-	bp.backends = bs
-	return bp, nil
 }
 
 func (p *BackendsPool) Next() Backend {
-	return p.strategy.Next(p.backends)
+	return p.strategy.Load().Next(p.members.Load().active)
+}
+
+// SetStrategy атомарно меняет стратегию выбора бекенда
+func (p *BackendsPool) SetStrategy(s Strategy) {
+	p.strategy.Store(&strategyBox{Strategy: s})
+}
+
+// SetBackends приводит состав пула к urls: новые бекенды добавляются, отсутствующие
+// в urls удаляются (запросы в работе на них дорабатывают), остальные сохраняют
+// состояние. При ошибке в любом URL состав пула не меняется
+func (p *BackendsPool) SetBackends(urls []string) (added, removed []string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.members.Load()
+
+	all := make([]*member, 0, len(urls))
+	keep := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		bs, err := p.createBackends([]string{u})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrBackendInvalid, err)
+		}
+		id := bs[0].URLString()
+		if id == "" {
+			return nil, nil, fmt.Errorf("%w: no host in %q", ErrBackendInvalid, u)
+		}
+		if keep[id] {
+			return nil, nil, fmt.Errorf("%w: %s", ErrBackendExist, id)
+		}
+		keep[id] = true
+		m := cur.find(id)
+		if m == nil {
+			m = newMember(bs[0])
+			added = append(added, id)
+		}
+		all = append(all, m)
+	}
+	for _, m := range cur.all {
+		if !keep[m.URLString()] {
+			removed = append(removed, m.URLString())
+		}
+	}
+	p.members.Store(newPoolMembers(all))
+	if len(added) > 0 || len(removed) > 0 {
+		p.Logger.Info("backends updated", "added", added, "removed", removed)
+	}
+	return added, removed, nil
+}
+
+// ReconcileBackends приводит состав пула к экземплярам из обнаружения сервисов.
+// В отличие от SetBackends пропавшие бекенды не удаляются сразу, а уходят в drain
+// и удаляются, когда на них не останется запросов в работе (при следующей сверке
+// или health check). Вернувшийся бекенд выходит из drain, у оставшихся
+// обновляются вес и метаданные. Экземпляр с Draining остаётся в пуле в drain
+func (p *BackendsPool) ReconcileBackends(instances []discovery.Instance) (added, drained []string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.members.Load()
+
+	all := make([]*member, 0, len(instances))
+	keep := make(map[string]bool, len(instances))
+	for _, in := range instances {
+		u, err := url.Parse(in.URL)
+		if err != nil || u.Host == "" {
+			return nil, nil, fmt.Errorf("%w: bad url %q", ErrBackendInvalid, in.URL)
+		}
+		id := u.Host
+		if keep[id] {
+			continue
+		}
+		keep[id] = true
+		m := cur.find(id)
+		switch {
+		case m == nil:
+			bs, err := p.createBackends([]string{in.URL})
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrBackendInvalid, err)
+			}
+			m = newMember(bs[0])
+			added = append(added, id)
+		case m.retired.Load():
+			m.retired.Store(false)
+			m.terminating.Store(false)
+			m.draining.Store(false)
+			added = append(added, id)
+		}
+		switch {
+		case in.Draining && !m.terminating.Swap(true):
+			m.draining.Store(true)
+			drained = append(drained, id)
+		case !in.Draining && m.terminating.Swap(false):
+			m.draining.Store(false)
+		}
+		m.weight.Store(int64(max(in.Weight, 1)))
+		if meta := m.metadata.Load(); meta == nil || !maps.Equal(*meta, in.Metadata) {
+			md := maps.Clone(in.Metadata)
+			m.metadata.Store(&md)
+		}
+		all = append(all, m)
+	}
+	var removed []string
+	for _, m := range cur.all {
+		id := m.URLString()
+		if keep[id] {
+			continue
+		}
+		if m.inFlight.Load() == 0 {
+			if !m.retired.Load() {
+				drained = append(drained, id)
+			}
+			removed = append(removed, id)
+			continue
+		}
+		if !m.retired.Load() {
+			m.retired.Store(true)
+			m.draining.Store(true)
+			drained = append(drained, id)
+		}
+		all = append(all, m)
+	}
+	p.members.Store(newPoolMembers(all))
+	if len(added) > 0 || len(drained) > 0 || len(removed) > 0 {
+		p.Logger.Info("backends reconciled", "added", added, "drained", drained, "removed", removed)
+	}
+	return added, drained, nil
+}
+
+// pruneRetired удаляет бекенды, пропавшие из обнаружения и дождавшиеся конца drain
+func (p *BackendsPool) pruneRetired() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.members.Load()
+	all := make([]*member, 0, len(cur.all))
+	var removed []string
+	for _, m := range cur.all {
+		if m.retired.Load() && m.inFlight.Load() == 0 {
+			removed = append(removed, m.URLString())
+			continue
+		}
+		all = append(all, m)
+	}
+	if len(removed) > 0 {
+		p.members.Store(newPoolMembers(all))
+		p.Logger.Info("drained backends removed", "removed", removed)
+	}
+}
+
+// Backends возвращает состояние всех бекендов пула
+func (p *BackendsPool) Backends() []state.Backend {
+	all := p.members.Load().all
+	infos := make([]state.Backend, 0, len(all))
+	for _, m := range all {
+		state := state.Backend{
+			URL:      m.URLString(),
+			Alive:    m.IsAlive(),
+			Draining: m.draining.Load(),
+			InFlight: m.inFlight.Load(),
+			Weight:   int(m.weight.Load()),
+		}
+		if md := m.metadata.Load(); md != nil {
+			state.Metadata = *md
+		}
+		infos = append(infos, state)
+	}
+	return infos
+}
+
+// AddBackend добавляет бекенд в пул. Бекенд сразу начинает получать запросы
+func (p *BackendsPool) AddBackend(rawURL string) (state.Backend, error) {
+	bs, err := p.createBackends([]string{rawURL})
+	if err != nil {
+		return state.Backend{}, fmt.Errorf("%w: %w", ErrBackendInvalid, err)
+	}
+	m := newMember(bs[0])
+	if m.URLString() == "" {
+		return state.Backend{}, fmt.Errorf("%w: no host in %q", ErrBackendInvalid, rawURL)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.members.Load()
+	if cur.find(m.URLString()) != nil {
+		return state.Backend{}, fmt.Errorf("%w: %s", ErrBackendExist, m.URLString())
+	}
+	all := make([]*member, 0, len(cur.all)+1)
+	all = append(all, cur.all...)
+	all = append(all, m)
+	p.members.Store(newPoolMembers(all))
+	p.Logger.Info("backend added", "url", m.URLString())
+	return state.Backend{URL: m.URLString(), Alive: m.IsAlive(), Weight: 1}, nil
+}
+
+// RemoveBackend убирает бекенд из пула. Запросы, уже отправленные на него, дорабатывают
+func (p *BackendsPool) RemoveBackend(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.members.Load()
+	if cur.find(id) == nil {
+		return fmt.Errorf("%w: %s", ErrNoBackend, id)
+	}
+	all := make([]*member, 0, len(cur.all)-1)
+	for _, m := range cur.all {
+		if m.URLString() != id {
+			all = append(all, m)
+		}
+	}
+	p.members.Store(newPoolMembers(all))
+	p.Logger.Info("backend removed", "url", id)
+	return nil
+}
+
+// SetDraining включает или выключает drain-режим: бекенд в drain не получает
+// новых запросов (в т.ч. по sticky-cookie), но запросы в работе завершаются
+func (p *BackendsPool) SetDraining(id string, draining bool) (state.Backend, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.members.Load()
+	m := cur.find(id)
+	if m == nil {
+		return state.Backend{}, fmt.Errorf("%w: %s", ErrNoBackend, id)
+	}
+	m.draining.Store(draining)
+	p.members.Store(newPoolMembers(cur.all))
+	p.Logger.Info("backend drain mode changed", "url", id, "draining", draining, "in_flight", m.inFlight.Load())
+	return state.Backend{URL: id, Alive: m.IsAlive(), Draining: draining, InFlight: m.inFlight.Load(), Weight: int(m.weight.Load())}, nil
+}
+
+func (pm *poolMembers) find(id string) *member {
+	for _, m := range pm.all {
+		if m.URLString() == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// serve проксирует запрос на бекенд, учитывая его в счётчике запросов в работе
+func serve(b Backend, w http.ResponseWriter, r *http.Request) {
+	if m, ok := b.(*member); ok {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+	}
+	b.ReverseProxy().ServeHTTP(w, r)
 }
 
 func (p *BackendsPool) LoadBalancerHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.WithValue(r.Context(), AttemptsKey, 0)
+	ctx = context.WithValue(ctx, RequestKey, r)
 	r = r.WithContext(ctx)
 
-	peer := p.Next()
+	peer := p.pick(r)
 	if peer != nil {
-		rp := peer.ReverseProxy()
-		rp.ServeHTTP(w, r)
+		serve(peer, w, r)
 		return
 	}
 	p.Logger.Error(ErrNoBackends.Error())
 	handlers.SendJSONError(w, http.StatusServiceUnavailable, "Service not available")
 }
 
+// pick выбирает бекенд для запроса: живой бекенд из sticky-cookie, иначе по стратегии
+func (p *BackendsPool) pick(r *http.Request) Backend {
+	if p.sticky != nil {
+		if token, ok := p.sticky.cookieToken(r); ok {
+			for _, b := range p.members.Load().active {
+				if b.IsAlive() && p.sticky.matches(token, b.URLString()) {
+					return b
+				}
+			}
+		}
+	}
+	return p.Next()
+}
+
 func (p *BackendsPool) HealthCheck(timeout time.Duration) {
-	for _, b := range p.backends {
+	p.pruneRetired()
+	for _, b := range p.members.Load().all {
 		go func(be Backend) {
 			alive, err := be.CheckHealth(timeout)
 			if err != nil {
@@ -99,18 +481,30 @@ func (p *BackendsPool) HealthCheck(timeout time.Duration) {
 			}
 			be.SetAlive(alive)
 			p.Logger.Info("Backend status check", "url", be.URLString(), "alive", alive)
-		}(b)
+		}(b.Backend)
 	}
 }
 
 func createHTTPBackends(urls []string, p *BackendsPool) ([]Backend, error) {
 	backends := make([]Backend, 0, len(urls))
 	for _, u := range urls {
-		b, err := httpbackend.NewBackend(u)
+		b, err := httpbackend.NewBackend(u, p.httpOpts...)
 		if err != nil {
 			return nil, fmt.Errorf("invalid URL %q: %w", u, err)
 		}
 
+		if p.sticky != nil {
+			// cookie выставляет тот бекенд, который реально ответил (в т.ч. после ретрая)
+			modify := b.ReverseProxy().ModifyResponse
+			b.ReverseProxy().ModifyResponse = func(resp *http.Response) error {
+				p.sticky.bind(resp, b.URLString())
+				if modify != nil {
+					return modify(resp)
+				}
+				return nil
+			}
+		}
+
 		b.ReverseProxy().ErrorHandler = func(rw http.ResponseWriter, req *http.Request, e error) {
 			p.Logger.Error("proxy error", "url", b.URLString(), "err", e)
 
@@ -122,7 +516,8 @@ func createHTTPBackends(urls []string, p *BackendsPool) ([]Backend, error) {
 				ctx := context.WithValue(req.Context(), AttemptsKey, attempts)
 				nextPeer := p.Next()
 				if nextPeer != nil {
-					nextPeer.ReverseProxy().ServeHTTP(rw, req.WithContext(ctx))
+					// повторяем исходный входящий запрос, а не уже переписанный исходящий
+					serve(nextPeer, rw, getRequestFromContext(req).WithContext(ctx))
 					return
 				}
 				p.Logger.Error(ErrNoBackends.Error())
@@ -145,3 +540,11 @@ func GetAttemptsFromContext(r *http.Request) int {
 	}
 	return 0
 }
+
+// getRequestFromContext возвращает входящий запрос, сохранённый в LoadBalancerHandler
+func getRequestFromContext(r *http.Request) *http.Request {
+	if v, ok := r.Context().Value(RequestKey).(*http.Request); ok {
+		return v
+	}
+	return r
+}
@@ -0,0 +1,335 @@
+package backends
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/P1coFly/LoadBalancer/pkg/discovery"
+)
+
+// firstAlive — простая стратегия для тестов: первый живой бекенд
+type firstAlive struct{}
+
+func (firstAlive) Next(bs []Backend) Backend {
+	for _, b := range bs {
+		if b.IsAlive() {
+			return b
+		}
+	}
+	return nil
+}
+
+// instances — экземпляры обнаружения с весом 1
+func instances(urls ...string) []discovery.Instance {
+	out := make([]discovery.Instance, 0, len(urls))
+	for _, u := range urls {
+		out = append(out, discovery.Instance{URL: u, Weight: 1})
+	}
+	return out
+}
+
+func newTestPool(t *testing.T, urls ...string) *BackendsPool {
+	t.Helper()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	p, err := NewPool(firstAlive{}, HTTP, urls, logger)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	return p
+}
+
+func TestPool_AddRemoveBackend(t *testing.T) {
+	p := newTestPool(t, "http://127.0.0.1:8081")
+
+	state, err := p.AddBackend("http://127.0.0.1:8082")
+	if err != nil {
+		t.Fatalf("AddBackend: %v", err)
+	}
+	if state.URL != "127.0.0.1:8082" || !state.Alive {
+		t.Errorf("unexpected state %+v", state)
+	}
+	if _, err := p.AddBackend("http://127.0.0.1:8082"); !errors.Is(err, ErrBackendExist) {
+		t.Errorf("duplicate add: err = %v; want ErrBackendExists", err)
+	}
+	if _, err := p.AddBackend("not a url"); !errors.Is(err, ErrBackendInvalid) {
+		t.Errorf("invalid add: err = %v; want ErrBackendInvalid", err)
+	}
+	if got := len(p.Backends()); got != 2 {
+		t.Fatalf("len(Backends) = %d; want 2", got)
+	}
+
+	if err := p.RemoveBackend("127.0.0.1:8081"); err != nil {
+		t.Fatalf("RemoveBackend: %v", err)
+	}
+	if err := p.RemoveBackend("127.0.0.1:8081"); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("second remove: err = %v; want ErrBackendNotFound", err)
+	}
+	if b := p.Next(); b == nil || b.URLString() != "127.0.0.1:8082" {
+		t.Errorf("Next = %v; want 127.0.0.1:8082", b)
+	}
+}
+
+func TestPool_DrainWaitsForInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("X-Backend", "slow")
+	}))
+	defer slow.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "other")
+	}))
+	defer other.Close()
+
+	p := newTestPool(t, slow.URL, other.URL)
+	slowID := strings.TrimPrefix(slow.URL, "http://")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		p.LoadBalancerHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- rec
+	}()
+	<-started
+
+	state, err := p.SetDraining(slowID, true)
+	if err != nil {
+		t.Fatalf("SetDraining: %v", err)
+	}
+	if !state.Draining || state.InFlight != 1 {
+		t.Errorf("state = %+v; want draining with 1 in flight", state)
+	}
+
+	// новые запросы идут мимо бекенда в drain
+	rec := httptest.NewRecorder()
+	p.LoadBalancerHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-Backend"); got != "other" {
+		t.Errorf("new request went to %q; want other", got)
+	}
+
+	// запрос в работе завершается
+	close(release)
+	if got := (<-done).Header().Get("X-Backend"); got != "slow" {
+		t.Errorf("in-flight request answered by %q; want slow", got)
+	}
+	for _, b := range p.Backends() {
+		if b.URL == slowID && b.InFlight != 0 {
+			t.Errorf("in_flight = %d after completion; want 0", b.InFlight)
+		}
+	}
+
+	if _, err := p.SetDraining(slowID, false); err != nil {
+		t.Fatalf("SetDraining(false): %v", err)
+	}
+	if b := p.Next(); b == nil || b.URLString() != slowID {
+		t.Errorf("Next = %v; want %s after undrain", b, slowID)
+	}
+}
+
+func TestPool_ConcurrentMutations(t *testing.T) {
+	p := newTestPool(t, "http://127.0.0.1:9001")
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				p.Next()
+				p.Backends()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			p.HealthCheck(10 * time.Millisecond)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		if _, err := p.AddBackend("http://127.0.0.1:9002"); err != nil {
+			t.Fatalf("AddBackend: %v", err)
+		}
+		if _, err := p.SetDraining("127.0.0.1:9002", true); err != nil {
+			t.Fatalf("SetDraining: %v", err)
+		}
+		if err := p.RemoveBackend("127.0.0.1:9002"); err != nil {
+			t.Fatalf("RemoveBackend: %v", err)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestPool_ReconcileDrainsVanished(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer slow.Close()
+
+	p := newTestPool(t, slow.URL, "http://127.0.0.1:9001")
+	slowID := strings.TrimPrefix(slow.URL, "http://")
+
+	done := make(chan struct{})
+	go func() {
+		p.LoadBalancerHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	<-started
+
+	// оба бекенда пропали: свободный удаляется сразу, занятый уходит в drain
+	added, drained, err := p.ReconcileBackends(instances("http://127.0.0.1:9003"))
+	if err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	if len(added) != 1 || len(drained) != 2 {
+		t.Errorf("added = %v, drained = %v; want 1 added, 2 drained", added, drained)
+	}
+	states := p.Backends()
+	if len(states) != 2 {
+		t.Fatalf("backends = %+v; want new one and draining %s", states, slowID)
+	}
+	for _, s := range states {
+		if s.URL == slowID && (!s.Draining || s.InFlight != 1) {
+			t.Errorf("vanished busy backend state = %+v; want draining with 1 in flight", s)
+		}
+	}
+	if b := p.Next(); b == nil || b.URLString() != "127.0.0.1:9003" {
+		t.Errorf("Next = %v; want 127.0.0.1:9003", b)
+	}
+
+	close(release)
+	<-done
+	if _, _, err := p.ReconcileBackends(instances("http://127.0.0.1:9003")); err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	if got := len(p.Backends()); got != 1 {
+		t.Errorf("len(Backends) = %d after in-flight finished; want 1", got)
+	}
+}
+
+func TestPool_ReconcileRestoresReturned(t *testing.T) {
+	p := newTestPool(t, "http://127.0.0.1:9001")
+	m := p.members.Load().find("127.0.0.1:9001")
+	m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+
+	if _, _, err := p.ReconcileBackends(instances("http://127.0.0.1:9002")); err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	added, _, err := p.ReconcileBackends(instances("http://127.0.0.1:9001", "http://127.0.0.1:9002"))
+	if err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	if len(added) != 1 || added[0] != "127.0.0.1:9001" {
+		t.Errorf("added = %v; want returned 127.0.0.1:9001", added)
+	}
+	for _, s := range p.Backends() {
+		if s.Draining {
+			t.Errorf("backend %s still draining after it came back", s.URL)
+		}
+	}
+}
+
+func TestPool_ReconcileTerminatingInstances(t *testing.T) {
+	p := newTestPool(t, "http://127.0.0.1:9001")
+	_, drained, err := p.ReconcileBackends([]discovery.Instance{
+		{URL: "http://127.0.0.1:9001", Weight: 1, Draining: true},
+		{URL: "http://127.0.0.1:9002", Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	if len(drained) != 1 || drained[0] != "127.0.0.1:9001" {
+		t.Errorf("drained = %v; want terminating 127.0.0.1:9001", drained)
+	}
+	// завершающийся бекенд остаётся в пуле, но новых запросов не получает
+	if states := p.Backends(); len(states) != 2 {
+		t.Fatalf("backends = %+v; want both", states)
+	}
+	for range 4 {
+		if b := p.Next(); b == nil || b.URLString() != "127.0.0.1:9002" {
+			t.Fatalf("Next = %v; want 127.0.0.1:9002", b)
+		}
+	}
+
+	if _, _, err := p.ReconcileBackends(instances("http://127.0.0.1:9001", "http://127.0.0.1:9002")); err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	for _, s := range p.Backends() {
+		if s.Draining {
+			t.Errorf("backend %s still draining after it became ready", s.URL)
+		}
+	}
+}
+
+func TestPool_ReconcileWeightsAndMetadata(t *testing.T) {
+	p := newTestPool(t, "http://127.0.0.1:9001")
+	_, _, err := p.ReconcileBackends([]discovery.Instance{
+		{URL: "http://127.0.0.1:9001", Weight: 4, Metadata: map[string]string{"zone": "a"}},
+		{URL: "http://127.0.0.1:9002", Weight: 2},
+	})
+	if err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+
+	// веса 4:2 сокращаются до 2:1 и перемешиваются
+	var seq []string
+	for _, b := range p.members.Load().active {
+		seq = append(seq, b.URLString())
+	}
+	want := []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9001"}
+	if strings.Join(seq, ",") != strings.Join(want, ",") {
+		t.Errorf("active = %v; want %v", seq, want)
+	}
+
+	for _, s := range p.Backends() {
+		switch s.URL {
+		case "127.0.0.1:9001":
+			if s.Weight != 4 || s.Metadata["zone"] != "a" {
+				t.Errorf("state = %+v; want weight 4 and zone=a", s)
+			}
+		case "127.0.0.1:9002":
+			if s.Weight != 2 || s.Metadata != nil {
+				t.Errorf("state = %+v; want weight 2 without metadata", s)
+			}
+		}
+	}
+
+	// обновление веса без смены состава
+	if _, _, err := p.ReconcileBackends(instances("http://127.0.0.1:9001", "http://127.0.0.1:9002")); err != nil {
+		t.Fatalf("ReconcileBackends: %v", err)
+	}
+	if got := len(p.members.Load().active); got != 2 {
+		t.Errorf("len(active) = %d after weights reset to 1; want 2", got)
+	}
+}
+
+func TestStickyCookieName(t *testing.T) {
+	for pool, want := range map[string]string{
+		"v2":         "lb_backend_v2",
+		"blue green": "lb_backend_blue_green",
+		"a;b=c":      "lb_backend_a_b_c",
+	} {
+		if got := StickyCookieName(pool); got != want {
+			t.Errorf("StickyCookieName(%q) = %q; want %q", pool, got, want)
+		}
+	}
+}
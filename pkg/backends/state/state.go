@@ -0,0 +1,20 @@
+package state
+
+import "errors"
+
+var (
+	ErrNotFound = errors.New("backend not found")
+	ErrExists   = errors.New("backend already exist")
+	ErrInvalid  = errors.New("invalid backend")
+)
+
+// Backend — состояние бекенда пула. Общее для пулов (backends) и admin API (handlers)
+type Backend struct {
+	URL      string `json:"url"`
+	Alive    bool   `json:"alive"`
+	Draining bool   `json:"draining"`
+	InFlight int64  `json:"in_flight"`
+	// Weight и Metadata задаёт обнаружение сервисов, у бекендов из конфига и admin API вес 1
+	Weight   int               `json:"weight"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
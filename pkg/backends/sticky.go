@@ -0,0 +1,113 @@
+package backends
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultStickyCookie = "lb_backend"
+
+// StickyCookieName — имя cookie по умолчанию для именованного пула. Cookie ставится
+// на Path=/, поэтому с общим именем пулы разных маршрутов перезаписывали бы привязку
+// друг друга. Символы, недопустимые в имени cookie, заменяются на '_'
+func StickyCookieName(pool string) string {
+	return DefaultStickyCookie + "_" + strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return r
+		}
+		return '_'
+	}, pool)
+}
+
+// StickySessions привязывает клиента к бекенду через cookie с непрозрачным ID
+// бекенда; сам бекенд по ID находит пул
+type StickySessions struct {
+	cookieName string
+	secret     []byte
+	ttl        time.Duration
+	// tokens — кеш ID по адресу бекенда, чтобы не считать HMAC на каждый запрос
+	tokens sync.Map
+}
+
+// NewStickySessions создаёт привязку сессий. Пустой secret заменяется случайным —
+// тогда cookie перестают быть валидными после рестарта балансировщика
+func NewStickySessions(cookieName, secret string, ttl time.Duration) (*StickySessions, error) {
+	if cookieName == "" {
+		cookieName = DefaultStickyCookie
+	}
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &StickySessions{
+		cookieName: cookieName,
+		secret:     key,
+		ttl:        ttl,
+	}, nil
+}
+
+// WithStickySessions включает привязку сессий для пула
+func WithStickySessions(s *StickySessions) PoolOption {
+	return func(bp *BackendsPool) {
+		bp.sticky = s
+	}
+}
+
+// token — непрозрачный ID бекенда id для cookie: HMAC его адреса. Адрес из него
+// не узнать, а подобрать ID другого бекенда нельзя без secret
+func (s *StickySessions) token(id string) string {
+	if t, ok := s.tokens.Load(id); ok {
+		return t.(string)
+	}
+	t := s.sign(id)
+	s.tokens.Store(id, t)
+	return t
+}
+
+// cookieToken возвращает ID бекенда из cookie запроса
+func (s *StickySessions) cookieToken(r *http.Request) (string, bool) {
+	c, err := r.Cookie(s.cookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// matches — token из cookie указывает на бекенд id
+func (s *StickySessions) matches(token, id string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token(id))) == 1
+}
+
+// bind выставляет cookie на ответ бекенда id, если клиент ещё не привязан к нему
+func (s *StickySessions) bind(resp *http.Response, id string) {
+	if current, ok := s.cookieToken(resp.Request); ok && s.matches(current, id) {
+		return
+	}
+	c := &http.Cookie{
+		Name:     s.cookieName,
+		Value:    s.token(id),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.ttl > 0 {
+		c.MaxAge = int(s.ttl.Seconds())
+	}
+	resp.Header.Add("Set-Cookie", c.String())
+}
+
+func (s *StickySessions) sign(id string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
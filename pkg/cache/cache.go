@@ -0,0 +1,581 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderXCache = "X-Cache"
+
+	StatusHit         = "HIT"
+	StatusMiss        = "MISS"
+	StatusStale       = "STALE"
+	StatusRevalidated = "REVALIDATED"
+
+	// passTTL — сколько помнить, что ответ по ключу некешируемый, чтобы не схлопывать такие запросы
+	passTTL = 30 * time.Second
+)
+
+var ErrInvalidConfig = errors.New("invalid cache config")
+
+// Config — параметры кеша
+type Config struct {
+	// MaxBytes — ограничение памяти под записи
+	MaxBytes int64
+	// MaxObjectBytes — ответы больше этого размера не кешируются
+	MaxObjectBytes int64
+	// DiskPath — каталог для записей на диске; пустой — только память
+	DiskPath string
+	// DiskMaxBytes — ограничение файлов записей на диске; 0 — как MaxBytes
+	DiskMaxBytes int64
+	// StaleWhileRevalidate и StaleIfError применяются, если бекенд не задал их сам
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+// Cache — HTTP-кеш для GET-ответов бекендов
+type Cache struct {
+	store          Store
+	policy         policy
+	maxObjectBytes int64
+	logger         *slog.Logger
+
+	mu      sync.Mutex
+	flights map[string]*flight
+	pass    map[string]time.Time
+
+	now func() time.Time
+}
+
+// flight — один запрос к бекенду, результат которого ждут остальные
+type flight struct {
+	done  chan struct{}
+	once  sync.Once
+	entry *Entry
+}
+
+func New(cfg Config, logger *slog.Logger) (*Cache, error) {
+	if cfg.MaxBytes <= 0 {
+		return nil, fmt.Errorf("%w: max_bytes must be positive", ErrInvalidConfig)
+	}
+	if cfg.MaxObjectBytes <= 0 || cfg.MaxObjectBytes > cfg.MaxBytes {
+		cfg.MaxObjectBytes = cfg.MaxBytes
+	}
+
+	var store Store = NewMemoryStore(cfg.MaxBytes)
+	if cfg.DiskPath != "" {
+		if cfg.DiskMaxBytes <= 0 {
+			cfg.DiskMaxBytes = cfg.MaxBytes
+		}
+		disk, err := NewDiskStore(cfg.DiskPath, cfg.DiskMaxBytes, logger)
+		if err != nil {
+			return nil, err
+		}
+		store = &tieredStore{mem: store.(*MemoryStore), disk: disk}
+	}
+
+	return &Cache{
+		store: store,
+		policy: policy{
+			staleWhileRevalidate: cfg.StaleWhileRevalidate,
+			staleIfError:         cfg.StaleIfError,
+		},
+		maxObjectBytes: cfg.MaxObjectBytes,
+		logger:         logger,
+		flights:        make(map[string]*flight),
+		pass:           make(map[string]time.Time),
+		now:            time.Now,
+	}, nil
+}
+
+// Handler оборачивает обработчик, проксирующий запросы на бекенды
+func (c *Cache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			c.invalidateOnWrite(w, r, next)
+			return
+		}
+		reqCC := parseCacheControl(r.Header)
+		if reqCC.has("no-store") || streaming(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := primaryKey(r)
+		entry := c.lookup(key, r)
+		now := c.now()
+		if entry == nil {
+			if reqCC.has("only-if-cached") {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c.fetch(w, r, key, next)
+			return
+		}
+
+		maxAge, hasMaxAge := reqCC.seconds("max-age")
+		forceRevalidate := reqCC.has("no-cache") || (hasMaxAge && entry.Age(now) > maxAge)
+		switch {
+		case !forceRevalidate && entry.Fresh(now):
+			c.serve(w, r, entry, StatusHit)
+		case !forceRevalidate && entry.WithinStaleWhileRevalidate(now):
+			c.serve(w, r, entry, StatusStale)
+			c.revalidateAsync(r, key, entry, next)
+		default:
+			c.revalidate(w, r, key, entry, next)
+		}
+	})
+}
+
+// Purge удаляет записи, путь которых начинается с prefix. Пустой prefix очищает весь кеш
+func (c *Cache) Purge(prefix string) int {
+	purged := 0
+	for _, key := range c.store.Keys() {
+		if strings.HasPrefix(keyURI(key), prefix) {
+			c.store.Delete(key)
+			purged++
+		}
+	}
+	c.mu.Lock()
+	for key := range c.pass {
+		if strings.HasPrefix(keyURI(key), prefix) {
+			delete(c.pass, key)
+		}
+	}
+	c.mu.Unlock()
+	c.logger.Info("cache purged", "prefix", prefix, "entries", purged)
+	return purged
+}
+
+// lookup находит запись по ключу с учётом Vary
+func (c *Cache) lookup(key string, r *http.Request) *Entry {
+	e, ok := c.store.Get(key)
+	if !ok {
+		return nil
+	}
+	if e.VaryIndex {
+		e, ok = c.store.Get(variantKey(key, r, e.VaryFields))
+		if !ok {
+			return nil
+		}
+	}
+	if !e.matchesVary(r) {
+		return nil
+	}
+	return e
+}
+
+func (c *Cache) save(key string, r *http.Request, e *Entry) {
+	if len(e.VaryFields) == 0 {
+		c.store.Set(key, e)
+		return
+	}
+	c.store.Set(key, &Entry{VaryIndex: true, VaryFields: e.VaryFields, Header: http.Header{}})
+	c.store.Set(variantKey(key, r, e.VaryFields), e)
+}
+
+// fetch обрабатывает промах: одновременные промахи по ключу ждут один запрос к бекенду
+func (c *Cache) fetch(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	f, leader := c.join(key)
+	if f == nil {
+		// ключ недавно оказался некешируемым — идём на бекенд без очереди
+		next.ServeHTTP(w, r)
+		return
+	}
+	if !leader {
+		select {
+		case <-f.done:
+		case <-r.Context().Done():
+			return
+		}
+		if f.entry != nil && f.entry.matchesVary(r) {
+			c.serve(w, r, f.entry, StatusHit)
+			return
+		}
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	// ждущие держатся за лидера, только пока ответ может попасть в кеш:
+	// некешируемый, потоковый или слишком большой ответ отпускает их к бекенду сразу
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			c.leave(key, f)
+		}
+	}
+	defer release()
+	cw := newCaptureWriter(w, c.maxObjectBytes, nil)
+	cw.onHeader = func(status int) {
+		if !c.shareable(r, status, cw.header) {
+			release()
+		}
+	}
+	cw.onOverflow = release
+	cw.Header().Set(HeaderXCache, StatusMiss)
+	next.ServeHTTP(cw, upstreamRequest(r, r.Context()))
+	cw.finish()
+
+	if cw.complete() {
+		if e, ok := c.policy.newEntry(r, cw.status, cw.header, cw.body.Bytes(), c.now()); ok {
+			c.save(key, r, e)
+			if !released {
+				f.entry = e
+			}
+			return
+		}
+	}
+	c.markPass(key)
+}
+
+// shareable — по заголовкам ответа его тело целиком попадёт в кеш и его можно раздать ждущим
+func (c *Cache) shareable(r *http.Request, status int, header http.Header) bool {
+	if mt, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mt == "text/event-stream" {
+		return false
+	}
+	cl, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || cl > c.maxObjectBytes {
+		return false
+	}
+	_, ok := c.policy.newEntry(r, status, header, nil, c.now())
+	return ok
+}
+
+// streaming — запрос на апгрейд соединения или поток событий, такие кеш не трогает
+func streaming(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, v := range r.Header.Values("Accept") {
+		if strings.Contains(v, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+// revalidate синхронно проверяет устаревшую запись условным запросом
+func (c *Cache) revalidate(w http.ResponseWriter, r *http.Request, key string, entry *Entry, next http.Handler) {
+	now := c.now()
+	// 304 не отдаём клиенту как есть, 5xx перехватываем, если можно отдать устаревший ответ
+	cw := newCaptureWriter(w, c.maxObjectBytes, func(status int) bool {
+		return status == http.StatusNotModified || (status >= 500 && entry.WithinStaleIfError(now))
+	})
+	cw.Header().Set(HeaderXCache, StatusMiss)
+	req := conditionalRequest(r, r.Context(), entry)
+	next.ServeHTTP(cw, req)
+	cw.finish()
+
+	switch {
+	case cw.status == http.StatusNotModified:
+		if updated, ok := c.policy.revalidated(entry, req, cw.header, c.now()); ok {
+			c.save(key, req, updated)
+			entry = updated
+		} else {
+			c.store.Delete(key)
+		}
+		c.serve(w, r, entry, StatusRevalidated)
+	case cw.intercepted:
+		c.logger.Warn("serving stale response on upstream error", "key", key, "status", cw.status)
+		c.serve(w, r, entry, StatusStale)
+	case cw.status >= 500:
+		// ошибку уже отдали клиенту, устаревшую запись оставляем
+	case cw.complete():
+		if e, ok := c.policy.newEntry(req, cw.status, cw.header, cw.body.Bytes(), c.now()); ok {
+			c.save(key, req, e)
+		} else {
+			c.store.Delete(key)
+		}
+	}
+}
+
+// revalidateAsync обновляет запись в фоне; на ключ — не больше одной фоновой ревалидации
+func (c *Cache) revalidateAsync(r *http.Request, key string, entry *Entry, next http.Handler) {
+	f, leader := c.join(key)
+	if f == nil || !leader {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	req := conditionalRequest(r, ctx, entry)
+	go func() {
+		defer c.leave(key, f)
+		defer func() {
+			// ReverseProxy паникует с ErrAbortHandler при обрыве копирования тела
+			if rec := recover(); rec != nil {
+				c.logger.Error("background revalidation aborted", "key", key, "panic", rec)
+			}
+		}()
+
+		cw := newCaptureWriter(nil, c.maxObjectBytes, nil)
+		next.ServeHTTP(cw, req)
+		cw.finish()
+
+		switch {
+		case cw.status == http.StatusNotModified:
+			if updated, ok := c.policy.revalidated(entry, req, cw.header, c.now()); ok {
+				c.save(key, req, updated)
+				f.entry = updated
+			}
+		case cw.status >= 500 || !cw.complete():
+			// оставляем устаревшую запись, её ещё можно отдать по stale-if-error
+		default:
+			if e, ok := c.policy.newEntry(req, cw.status, cw.header, cw.body.Bytes(), c.now()); ok {
+				c.save(key, req, e)
+				f.entry = e
+			} else {
+				c.store.Delete(key)
+			}
+		}
+	}()
+}
+
+// serve отдаёт запись клиенту, отвечая 304 на совпавший условный запрос
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *Entry, status string) {
+	h := w.Header()
+	for k, vs := range e.Header {
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set("Age", strconv.FormatInt(int64(e.Age(c.now())/time.Second), 10))
+	h.Set(HeaderXCache, status)
+
+	if notModified(r, e) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+// invalidateOnWrite проксирует небезопасный метод и сбрасывает запись по URL при успехе
+func (c *Cache) invalidateOnWrite(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	cw := newCaptureWriter(w, 0, nil)
+	next.ServeHTTP(cw, r)
+	cw.finish()
+	if cw.status < 400 {
+		c.store.Delete(primaryKey(r))
+	}
+}
+
+func (c *Cache) join(key string) (*flight, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until, ok := c.pass[key]; ok {
+		if c.now().Before(until) {
+			return nil, false
+		}
+		delete(c.pass, key)
+	}
+	if f, ok := c.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	return f, true
+}
+
+func (c *Cache) leave(key string, f *flight) {
+	c.mu.Lock()
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+	c.mu.Unlock()
+	f.once.Do(func() { close(f.done) })
+}
+
+func (c *Cache) markPass(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pass[key] = c.now().Add(passTTL)
+}
+
+// primaryKey — "host /path?query"; пробел не встречается ни в Host, ни в RequestURI
+func primaryKey(r *http.Request) string {
+	return r.Host + " " + r.URL.RequestURI()
+}
+
+// variantKey — ключ варианта ответа для значений заголовков из Vary
+func variantKey(key string, r *http.Request, fields []string) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for i, v := range varyValues(r, fields) {
+		b.WriteString("\n" + fields[i] + ":" + v)
+	}
+	return b.String()
+}
+
+// keyURI достаёт путь с query из ключа записи
+func keyURI(key string) string {
+	_, uri, _ := strings.Cut(key, " ")
+	uri, _, _ = strings.Cut(uri, "\n")
+	return uri
+}
+
+// upstreamRequest убирает условные заголовки клиента: кешу нужен полный ответ
+func upstreamRequest(r *http.Request, ctx context.Context) *http.Request {
+	req := r.Clone(ctx)
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		req.Header.Del(h)
+	}
+	return req
+}
+
+// conditionalRequest строит запрос ревалидации по валидаторам записи
+func conditionalRequest(r *http.Request, ctx context.Context, e *Entry) *http.Request {
+	req := upstreamRequest(r, ctx)
+	req.Method = http.MethodGet
+	if etag := e.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+	}
+	return req
+}
+
+// notModified проверяет условные заголовки клиента против записи
+func notModified(r *http.Request, e *Entry) bool {
+	if e.Status != http.StatusOK {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+			return !lm.After(ims)
+		}
+	}
+	return false
+}
+
+// captureWriter записывает ответ бекенда, параллельно отдавая его клиенту.
+// Если intercept вернул true для статуса, ответ клиенту не уходит
+type captureWriter struct {
+	w         http.ResponseWriter
+	limit     int64
+	intercept func(status int) bool
+
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	overflow    bool
+	intercepted bool
+	wroteHeader bool
+
+	// onHeader и onOverflow вызываются, когда известен статус и когда тело превысило лимит
+	onHeader   func(status int)
+	onOverflow func()
+}
+
+func newCaptureWriter(w http.ResponseWriter, limit int64, intercept func(int) bool) *captureWriter {
+	cw := &captureWriter{w: w, limit: limit, intercept: intercept, header: http.Header{}}
+	if w == nil {
+		cw.intercepted = true
+	}
+	return cw
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.wroteHeader || (code >= 100 && code < 200) {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	if cw.onHeader != nil {
+		cw.onHeader(code)
+	}
+	if cw.w == nil || (cw.intercept != nil && cw.intercept(code)) {
+		cw.intercepted = true
+		return
+	}
+	h := cw.w.Header()
+	for k, vs := range cw.header {
+		h[k] = vs
+	}
+	cw.w.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.overflow {
+		if int64(cw.body.Len()+len(p)) > cw.limit {
+			cw.overflow = true
+			cw.body.Reset()
+			if cw.onOverflow != nil {
+				cw.onOverflow()
+			}
+		} else {
+			cw.body.Write(p)
+		}
+	}
+	if cw.intercepted {
+		return len(p), nil
+	}
+	return cw.w.Write(p)
+}
+
+func (cw *captureWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.intercepted {
+		_ = http.NewResponseController(cw.w).Flush()
+	}
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.w
+}
+
+// complete — тело записано целиком: не превышен лимит и совпал Content-Length
+func (cw *captureWriter) complete() bool {
+	if cw.overflow {
+		return false
+	}
+	if cl, err := strconv.Atoi(cw.header.Get("Content-Length")); err == nil && cl != cw.body.Len() {
+		return false
+	}
+	return true
+}
+
+// finish фиксирует статус, если обработчик ничего не написал
+func (cw *captureWriter) finish() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,468 @@
+package cache
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// clock — управляемое время для тестов
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(t *testing.T, cfg Config) (*Cache, *clock) {
+	t.Helper()
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	c, err := New(cfg, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	clk := &clock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c.now = clk.Now
+	return c, clk
+}
+
+func do(h http.Handler, method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCache_HitAndExpire(t *testing.T) {
+	c, clk := newTestCache(t, Config{})
+	var calls int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "body")
+	})
+	h := c.Handler(upstream)
+
+	if rr := do(h, http.MethodGet, "/a", nil); rr.Header().Get(HeaderXCache) != StatusMiss {
+		t.Fatalf("first request X-Cache = %q", rr.Header().Get(HeaderXCache))
+	}
+	clk.Advance(30 * time.Second)
+	rr := do(h, http.MethodGet, "/a", nil)
+	if rr.Header().Get(HeaderXCache) != StatusHit || rr.Body.String() != "body" {
+		t.Errorf("expected HIT with body, got %q %q", rr.Header().Get(HeaderXCache), rr.Body.String())
+	}
+	if rr.Header().Get("Age") != "30" {
+		t.Errorf("Age = %q; want 30", rr.Header().Get("Age"))
+	}
+	if calls != 1 {
+		t.Errorf("upstream calls = %d; want 1", calls)
+	}
+
+	clk.Advance(31 * time.Second)
+	do(h, http.MethodGet, "/a", nil)
+	if calls != 2 {
+		t.Errorf("upstream calls after expiry = %d; want 2", calls)
+	}
+}
+
+func TestCache_NotStorable(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		reqHdr  map[string]string
+	}{
+		{"no-store", map[string]string{"Cache-Control": "no-store"}, nil},
+		{"private", map[string]string{"Cache-Control": "private, max-age=60"}, nil},
+		{"set-cookie", map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "a=b"}, nil},
+		{"vary star", map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, nil},
+		{"no freshness", nil, nil},
+		{"authorization", map[string]string{"Cache-Control": "max-age=60"}, map[string]string{"Authorization": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache(t, Config{})
+			var calls int32
+			h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				_, _ = io.WriteString(w, "body")
+			}))
+			do(h, http.MethodGet, "/a", tt.reqHdr)
+			do(h, http.MethodGet, "/a", tt.reqHdr)
+			if calls != 2 {
+				t.Errorf("upstream calls = %d; want 2", calls)
+			}
+		})
+	}
+}
+
+func TestCache_RevalidateETag(t *testing.T) {
+	c, clk := newTestCache(t, Config{})
+	var calls, conditional int32
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = io.WriteString(w, "body")
+	}))
+
+	do(h, http.MethodGet, "/a", nil)
+	clk.Advance(20 * time.Second)
+	rr := do(h, http.MethodGet, "/a", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "body" {
+		t.Fatalf("revalidated response = %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get(HeaderXCache) != StatusRevalidated {
+		t.Errorf("X-Cache = %q", rr.Header().Get(HeaderXCache))
+	}
+	if conditional != 1 {
+		t.Errorf("conditional requests = %d; want 1", conditional)
+	}
+
+	// после ревалидации запись снова свежая
+	clk.Advance(5 * time.Second)
+	if rr := do(h, http.MethodGet, "/a", nil); rr.Header().Get(HeaderXCache) != StatusHit {
+		t.Errorf("X-Cache after revalidation = %q", rr.Header().Get(HeaderXCache))
+	}
+
+	// условный запрос клиента получает 304 из кеша
+	rr = do(h, http.MethodGet, "/a", map[string]string{"If-None-Match": `"v1"`})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("client conditional = %d; want 304", rr.Code)
+	}
+	if calls != 2 {
+		t.Errorf("upstream calls = %d; want 2", calls)
+	}
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	c, clk := newTestCache(t, Config{})
+	var version int32
+	refreshed := make(chan struct{}, 1)
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := atomic.AddInt32(&version, 1)
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		_, _ = io.WriteString(w, string(rune('0'+v)))
+		if v > 1 {
+			refreshed <- struct{}{}
+		}
+	}))
+
+	do(h, http.MethodGet, "/a", nil)
+	clk.Advance(30 * time.Second)
+	rr := do(h, http.MethodGet, "/a", nil)
+	if rr.Header().Get(HeaderXCache) != StatusStale || rr.Body.String() != "1" {
+		t.Fatalf("expected stale body 1, got %q %q", rr.Header().Get(HeaderXCache), rr.Body.String())
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("background revalidation did not happen")
+	}
+	// дожидаемся, пока фоновая ревалидация сохранит запись
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		rr = do(h, http.MethodGet, "/a", nil)
+		if rr.Body.String() == "2" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rr.Body.String() != "2" || rr.Header().Get(HeaderXCache) != StatusHit {
+		t.Errorf("expected fresh body 2, got %q %q", rr.Header().Get(HeaderXCache), rr.Body.String())
+	}
+}
+
+func TestCache_StaleIfError(t *testing.T) {
+	c, clk := newTestCache(t, Config{StaleIfError: time.Minute})
+	var fail atomic.Bool
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10")
+		_, _ = io.WriteString(w, "good")
+	}))
+
+	do(h, http.MethodGet, "/a", nil)
+	fail.Store(true)
+	clk.Advance(30 * time.Second)
+	rr := do(h, http.MethodGet, "/a", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "good" || rr.Header().Get(HeaderXCache) != StatusStale {
+		t.Errorf("expected stale good, got %d %q %q", rr.Code, rr.Body.String(), rr.Header().Get(HeaderXCache))
+	}
+
+	clk.Advance(2 * time.Minute)
+	if rr := do(h, http.MethodGet, "/a", nil); rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 after stale-if-error window, got %d", rr.Code)
+	}
+}
+
+func TestCache_CollapseMisses(t *testing.T) {
+	c, _ := newTestCache(t, Config{})
+	var calls int32
+	release := make(chan struct{})
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Length", "4")
+		_, _ = io.WriteString(w, "body")
+	}))
+
+	const n = 20
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = do(h, http.MethodGet, "/a", nil).Body.String()
+		}(i)
+	}
+	// ждём, пока все горутины встанут в очередь за лидером
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("upstream calls = %d; want 1", calls)
+	}
+	for i, b := range bodies {
+		if b != "body" {
+			t.Errorf("request %d body = %q", i, b)
+		}
+	}
+}
+
+func TestCache_StreamingNotSerialized(t *testing.T) {
+	c, _ := newTestCache(t, Config{})
+	var calls int32
+	stop := make(chan struct{})
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "data: hello\n\n")
+		http.NewResponseController(w).Flush()
+		<-stop
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	defer close(stop)
+
+	// без Accept: text/event-stream поток распознаётся только по ответу бекенда
+	got := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := http.Get(srv.URL + "/events")
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			buf := make([]byte, len("data: hello\n\n"))
+			_, err = io.ReadFull(resp.Body, buf)
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			got <- string(buf)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case b := <-got:
+			if b != "data: hello\n\n" {
+				t.Errorf("stream %d got %q", i, b)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("stream %d blocked behind another stream", i)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("upstream calls = %d; want 2", n)
+	}
+}
+
+func TestCache_Vary(t *testing.T) {
+	c, _ := newTestCache(t, Config{})
+	var calls int32
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+
+	for _, lang := range []string{"en", "ru", "en", "ru"} {
+		rr := do(h, http.MethodGet, "/a", map[string]string{"Accept-Language": lang})
+		if rr.Body.String() != lang {
+			t.Errorf("Accept-Language %s: body = %q", lang, rr.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("upstream calls = %d; want 2", calls)
+	}
+}
+
+func TestCache_PurgeAndInvalidate(t *testing.T) {
+	c, _ := newTestCache(t, Config{})
+	var calls int32
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "body")
+	}))
+
+	do(h, http.MethodGet, "/api/a", nil)
+	do(h, http.MethodGet, "/api/b", nil)
+	do(h, http.MethodGet, "/static/c", nil)
+
+	if n := c.Purge("/api/"); n != 2 {
+		t.Errorf("purged = %d; want 2", n)
+	}
+	do(h, http.MethodGet, "/api/a", nil)
+	do(h, http.MethodGet, "/static/c", nil)
+	if calls != 4 {
+		t.Errorf("upstream calls = %d; want 4", calls)
+	}
+
+	do(h, http.MethodPost, "/static/c", nil)
+	do(h, http.MethodGet, "/static/c", nil)
+	if calls != 6 {
+		t.Errorf("upstream calls after POST = %d; want 6", calls)
+	}
+}
+
+func TestMemoryStore_LRU(t *testing.T) {
+	entry := func(size int) *Entry {
+		return &Entry{Header: http.Header{}, Body: make([]byte, size)}
+	}
+	s := NewMemoryStore(1000)
+	s.Set("a", entry(300))
+	s.Set("b", entry(300))
+	s.Get("a")
+	s.Set("c", entry(300))
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Error("recently used entry should stay")
+	}
+	if s.Size() > 1000 {
+		t.Errorf("size %d exceeds cap", s.Size())
+	}
+	s.Set("huge", entry(2000))
+	if _, ok := s.Get("huge"); ok {
+		t.Error("entry larger than cap should not be stored")
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	s, err := NewDiskStore(t.TempDir(), 1<<20, logger)
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	e := &Entry{Status: 200, Header: http.Header{"Etag": {`"x"`}}, Body: []byte("body"), Lifetime: time.Minute}
+	s.Set("host /a", e)
+
+	got, ok := s.Get("host /a")
+	if !ok || string(got.Body) != "body" || got.Header.Get("ETag") != `"x"` || got.Lifetime != time.Minute {
+		t.Fatalf("unexpected entry from disk: %+v", got)
+	}
+	if keys := s.Keys(); len(keys) != 1 || keys[0] != "host /a" {
+		t.Errorf("Keys = %v", keys)
+	}
+	s.Delete("host /a")
+	if _, ok := s.Get("host /a"); ok {
+		t.Error("entry should be deleted")
+	}
+}
+
+func TestDiskStoreEvicts(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	dir := t.TempDir()
+	now := time.Now()
+	entry := func(validator bool) *Entry {
+		e := &Entry{Status: 200, Header: http.Header{}, Body: make([]byte, 1000), StoredAt: now, Lifetime: time.Minute}
+		if validator {
+			e.Header.Set("ETag", `"x"`)
+		}
+		return e
+	}
+	// помещаются две записи по ~1.3KB
+	s, err := NewDiskStore(dir, 3000, logger)
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	s.Set("a", entry(true))
+	s.Set("b", entry(true))
+	s.Get("a")
+	s.Set("c", entry(true))
+	if _, ok := s.Get("b"); ok {
+		t.Error("least recently used entry b kept over the limit")
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Error("recently read entry a evicted")
+	}
+	if s.Size() > 3000 {
+		t.Errorf("size = %d; want at most 3000", s.Size())
+	}
+
+	// истёкшая запись уходит первой, даже если её только что читали
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	s.Set("stale", entry(false))
+	s.Get("stale")
+	s.Set("d", entry(true))
+	if _, ok := s.Get("stale"); ok {
+		t.Error("expired entry kept over the limit")
+	}
+	if _, ok := s.Get("d"); !ok {
+		t.Error("new entry d evicted")
+	}
+
+	// после перезапуска индекс восстанавливается из каталога, а лимит соблюдается
+	reopened, err := NewDiskStore(dir, 1500, logger)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if keys := reopened.Keys(); len(keys) != 1 || keys[0] != "d" {
+		t.Errorf("Keys after reopen with smaller limit = %v; want [d]", keys)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files on disk; want 1", len(files))
+	}
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskSuffix = ".entry"
+	diskTemp   = "tmp-"
+)
+
+// DiskStore хранит каждую запись в отдельном файле каталога.
+// Имя файла — sha256 от ключа, внутри gob: сначала ключ, затем запись.
+// Индекс файлов держится в памяти: каталог читается один раз при открытии,
+// а при превышении maxBytes удаляются истёкшие записи, затем давно не читанные
+type DiskStore struct {
+	dir      string
+	maxBytes int64
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+
+	now func() time.Time
+}
+
+type diskItem struct {
+	key  string
+	size int64
+	// expires — после этого момента запись уже не отдать, нулевое — пока её можно ревалидировать
+	expires time.Time
+}
+
+func NewDiskStore(dir string, maxBytes int64, logger *slog.Logger) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &DiskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		logger:   logger,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.evict()
+	s.mu.Unlock()
+	return s, nil
+}
+
+// load строит индекс по файлам каталога, давно изменённые — в конце LRU
+func (s *DiskStore) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	type loaded struct {
+		item    *diskItem
+		modTime time.Time
+	}
+	var all []loaded
+	for _, file := range files {
+		path := filepath.Join(s.dir, file.Name())
+		if strings.HasPrefix(file.Name(), diskTemp) {
+			// недописанная запись после падения
+			_ = os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(file.Name(), diskSuffix) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key, e, err := readEntry(path)
+		if err != nil || s.path(key) != path {
+			s.logger.Warn("cache disk drops unreadable entry", "file", file.Name(), "err", err)
+			_ = os.Remove(path)
+			continue
+		}
+		all = append(all, loaded{&diskItem{key: key, size: info.Size(), expires: e.expiresAt()}, info.ModTime()})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.After(all[j].modTime) })
+	for _, l := range all {
+		s.items[l.item.key] = s.ll.PushBack(l.item)
+		s.size += l.item.size
+	}
+	return nil
+}
+
+func readEntry(path string) (string, *Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	var key string
+	var e Entry
+	if err := dec.Decode(&key); err != nil {
+		return "", nil, err
+	}
+	if err := dec.Decode(&e); err != nil {
+		return key, nil, err
+	}
+	return key, &e, nil
+}
+
+func (s *DiskStore) Get(key string) (*Entry, bool) {
+	storedKey, e, err := readEntry(s.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Error("cache disk read failed", "key", key, "err", err)
+		}
+		return nil, false
+	}
+	if storedKey != key {
+		return nil, false
+	}
+	s.mu.Lock()
+	if el, ok := s.items[key]; ok {
+		s.ll.MoveToFront(el)
+	}
+	s.mu.Unlock()
+	return e, true
+}
+
+func (s *DiskStore) Set(key string, e *Entry) {
+	// пишем во временный файл и переименовываем, чтобы читатели не видели половину записи
+	tmp, err := os.CreateTemp(s.dir, diskTemp+"*")
+	if err != nil {
+		s.logger.Error("cache disk write failed", "key", key, "err", err)
+		return
+	}
+	enc := gob.NewEncoder(tmp)
+	err = enc.Encode(key)
+	if err == nil {
+		err = enc.Encode(e)
+	}
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		s.logger.Error("cache disk write failed", "key", key, "err", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size > s.maxBytes {
+		_ = os.Remove(tmp.Name())
+		s.remove(key)
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		s.logger.Error("cache disk write failed", "key", key, "err", err)
+		return
+	}
+	item := &diskItem{key: key, size: size, expires: e.expiresAt()}
+	if el, ok := s.items[key]; ok {
+		s.size += size - el.Value.(*diskItem).size
+		el.Value = item
+		s.ll.MoveToFront(el)
+	} else {
+		s.items[key] = s.ll.PushFront(item)
+		s.size += size
+	}
+	s.evict()
+}
+
+func (s *DiskStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *DiskStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Size возвращает текущий объём файлов записей в байтах
+func (s *DiskStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// evict освобождает место сверх maxBytes: сначала истёкшие записи, затем по LRU
+func (s *DiskStore) evict() {
+	if s.size <= s.maxBytes {
+		return
+	}
+	now := s.now()
+	for el := s.ll.Back(); el != nil; {
+		item, prev := el.Value.(*diskItem), el.Prev()
+		if !item.expires.IsZero() && now.After(item.expires) {
+			s.remove(item.key)
+		}
+		el = prev
+	}
+	for s.size > s.maxBytes {
+		oldest := s.ll.Back()
+		if oldest == nil {
+			break
+		}
+		s.remove(oldest.Value.(*diskItem).key)
+	}
+}
+
+// remove удаляет файл и запись индекса, вызывается под s.mu
+func (s *DiskStore) remove(key string) {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.logger.Error("cache disk delete failed", "key", key, "err", err)
+	}
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.ll.Remove(el)
+	delete(s.items, key)
+	s.size -= el.Value.(*diskItem).size
+}
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+diskSuffix)
+}
@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristicMax — верхняя граница эвристической свежести по Last-Modified
+const heuristicMax = 24 * time.Hour
+
+// Entry — сохранённый ответ. После попадания в Store не изменяется
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// StoredAt — момент получения ответа, InitialAge — значение Age от бекенда
+	StoredAt   time.Time
+	InitialAge time.Duration
+	// Lifetime — время свежести ответа
+	Lifetime time.Duration
+	// StaleWhileRevalidate и StaleIfError — окна, в которые можно отдавать устаревший ответ
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// NoCache — ответ нужно ревалидировать перед каждой отдачей
+	NoCache bool
+
+	// VaryFields — имена заголовков из Vary, VaryValues — их значения в исходном запросе
+	VaryFields []string
+	VaryValues []string
+	// VaryIndex — служебная запись по первичному ключу, хранящая только VaryFields
+	VaryIndex bool
+}
+
+// Age возвращает текущий возраст ответа
+func (e *Entry) Age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.StoredAt)
+}
+
+// Fresh — ответ можно отдать без похода на бекенд
+func (e *Entry) Fresh(now time.Time) bool {
+	return !e.NoCache && e.Age(now) < e.Lifetime
+}
+
+// WithinStaleWhileRevalidate — устаревший ответ можно отдать, обновив его в фоне
+func (e *Entry) WithinStaleWhileRevalidate(now time.Time) bool {
+	return !e.NoCache && e.Age(now) < e.Lifetime+e.StaleWhileRevalidate
+}
+
+// WithinStaleIfError — устаревший ответ можно отдать вместо ошибки бекенда
+func (e *Entry) WithinStaleIfError(now time.Time) bool {
+	return e.Age(now) < e.Lifetime+e.StaleIfError
+}
+
+// expiresAt — момент, после которого запись уже нельзя отдать даже устаревшей.
+// Нулевой — запись с валидаторами (её можно ревалидировать) или индекс Vary
+func (e *Entry) expiresAt() time.Time {
+	if e.VaryIndex || e.HasValidators() {
+		return time.Time{}
+	}
+	return e.StoredAt.Add(e.Lifetime + max(e.StaleWhileRevalidate, e.StaleIfError) - e.InitialAge)
+}
+
+// HasValidators — ответ можно ревалидировать условным запросом
+func (e *Entry) HasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// Size — примерный объём записи в памяти
+func (e *Entry) Size() int64 {
+	n := int64(len(e.Body))
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n + 128
+}
+
+// cacheControl разбирает Cache-Control в map директива → значение (ключи в нижнем регистре)
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds возвращает значение директивы-длительности, ok=false если её нет или она некорректна
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheableByDefault — статусы, которые можно кешировать эвристически (RFC 9110 15.1)
+var cacheableByDefault = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// policy — параметры по умолчанию для окон устаревания
+type policy struct {
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+// newEntry проверяет, можно ли сохранить ответ в разделяемом кеше, и строит запись
+func (p policy) newEntry(req *http.Request, status int, header http.Header, body []byte, now time.Time) (*Entry, bool) {
+	if req.Method != http.MethodGet {
+		return nil, false
+	}
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(header)
+	if reqCC.has("no-store") || respCC.has("no-store") || respCC.has("private") {
+		return nil, false
+	}
+	// ответы с cookie привязаны к конкретному клиенту (в т.ч. sticky-сессии)
+	if header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+	if req.Header.Get("Authorization") != "" &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return nil, false
+	}
+	fields := varyFields(header)
+	for _, f := range fields {
+		if f == "*" {
+			return nil, false
+		}
+	}
+
+	lifetime, explicit := freshnessLifetime(header, respCC, now)
+	if !explicit && !cacheableByDefault[status] {
+		return nil, false
+	}
+	e := &Entry{
+		Status:     status,
+		Header:     header.Clone(),
+		Body:       body,
+		StoredAt:   now,
+		Lifetime:   lifetime,
+		NoCache:    respCC.has("no-cache"),
+		VaryFields: fields,
+		VaryValues: varyValues(req, fields),
+	}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		e.InitialAge = time.Duration(age) * time.Second
+	}
+	if e.Lifetime <= 0 && !e.HasValidators() {
+		return nil, false
+	}
+
+	if !respCC.has("must-revalidate") && !respCC.has("proxy-revalidate") {
+		e.StaleWhileRevalidate = p.staleWhileRevalidate
+		if d, ok := respCC.seconds("stale-while-revalidate"); ok {
+			e.StaleWhileRevalidate = d
+		}
+		e.StaleIfError = p.staleIfError
+		if d, ok := respCC.seconds("stale-if-error"); ok {
+			e.StaleIfError = d
+		}
+	}
+	e.Header.Del("Age")
+	return e, true
+}
+
+// freshnessLifetime считает время свежести: s-maxage, max-age, Expires, затем эвристика.
+// explicit=true, если свежесть задана бекендом явно
+func freshnessLifetime(header http.Header, cc cacheControl, now time.Time) (time.Duration, bool) {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, true
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+	date := now
+	if d, err := http.ParseTime(header.Get("Date")); err == nil {
+		date = d
+	}
+	if v := header.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil || !exp.After(date) {
+			// некорректный Expires означает «уже устарел»
+			return 0, true
+		}
+		return exp.Sub(date), true
+	}
+	if lm, err := http.ParseTime(header.Get("Last-Modified")); err == nil && lm.Before(date) {
+		return min(date.Sub(lm)/10, heuristicMax), false
+	}
+	return 0, false
+}
+
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, http.CanonicalHeaderKey(f))
+			}
+		}
+	}
+	return fields
+}
+
+func varyValues(r *http.Request, fields []string) []string {
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = strings.Join(r.Header.Values(f), ",")
+	}
+	return values
+}
+
+// matchesVary проверяет, что запрос совпадает с исходным по заголовкам из Vary
+func (e *Entry) matchesVary(r *http.Request) bool {
+	current := varyValues(r, e.VaryFields)
+	for i := range current {
+		if current[i] != e.VaryValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// revalidated возвращает копию записи, обновлённую по ответу 304
+func (p policy) revalidated(e *Entry, req *http.Request, notModified http.Header, now time.Time) (*Entry, bool) {
+	header := e.Header.Clone()
+	for k, vs := range notModified {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		header[k] = vs
+	}
+	return p.newEntry(req, e.Status, header, e.Body, now)
+}
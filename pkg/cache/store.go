@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Store — хранилище записей кеша
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(key string)
+	Keys() []string
+}
+
+// MemoryStore — LRU в памяти с ограничением по суммарному размеру записей
+type MemoryStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, true
+}
+
+func (s *MemoryStore) Set(key string, e *Entry) {
+	size := e.Size() + int64(len(key))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size > s.maxBytes {
+		s.remove(key)
+		return
+	}
+	if el, ok := s.items[key]; ok {
+		item := el.Value.(*memoryItem)
+		s.size += size - item.size
+		item.entry, item.size = e, size
+		s.ll.MoveToFront(el)
+	} else {
+		s.items[key] = s.ll.PushFront(&memoryItem{key: key, entry: e, size: size})
+		s.size += size
+	}
+	for s.size > s.maxBytes {
+		oldest := s.ll.Back()
+		if oldest == nil {
+			break
+		}
+		s.remove(oldest.Value.(*memoryItem).key)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *MemoryStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Size возвращает текущий объём записей в байтах
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *MemoryStore) remove(key string) {
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.ll.Remove(el)
+	delete(s.items, key)
+	s.size -= el.Value.(*memoryItem).size
+}
+
+// tieredStore — память перед диском: чтение с диска поднимает запись в память
+type tieredStore struct {
+	mem  *MemoryStore
+	disk *DiskStore
+}
+
+func (t *tieredStore) Get(key string) (*Entry, bool) {
+	if e, ok := t.mem.Get(key); ok {
+		return e, true
+	}
+	e, ok := t.disk.Get(key)
+	if ok {
+		t.mem.Set(key, e)
+	}
+	return e, ok
+}
+
+func (t *tieredStore) Set(key string, e *Entry) {
+	t.mem.Set(key, e)
+	t.disk.Set(key, e)
+}
+
+func (t *tieredStore) Delete(key string) {
+	t.mem.Delete(key)
+	t.disk.Delete(key)
+}
+
+func (t *tieredStore) Keys() []string {
+	// диск — надмножество памяти
+	return t.disk.Keys()
+}
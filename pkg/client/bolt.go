@@ -0,0 +1,200 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// boltLockTimeout — сколько ждать блокировку файла, если его держит другой процесс
+const boltLockTimeout = 5 * time.Second
+
+var ErrBoltClosed = errors.New("bolt database is closed")
+
+// boltRecord — настройки клиента в файле. Записи без алгоритма — бакет токенов
+type boltRecord struct {
+	Algorithm Algorithm `json:"algorithm,omitempty"`
+	Capacity  int       `json:"capacity"`
+	RPS       float64   `json:"rate_per_sec"`
+	Tenant    string    `json:"tenant,omitempty"`
+}
+
+// BoltStore — файл BoltDB, общий для репозиториев (клиенты, организации). Открывается
+// один раз на процесс: bbolt блокирует файл целиком, поэтому при обновлении бинаря
+// (SIGUSR2) старый процесс закрывает его (Close) до запуска нового
+type BoltStore struct {
+	path string
+	mu   sync.RWMutex
+	db   *bbolt.DB
+}
+
+// OpenBoltStore открывает (или создаёт) файл path
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	s := &BoltStore{path: path}
+	if err := s.Reopen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reopen снова открывает закрытый файл, например если обновление бинаря не удалось
+func (s *BoltStore) Reopen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return nil
+	}
+	db, err := bbolt.Open(s.path, 0o600, &bbolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		return fmt.Errorf("open %s: %w", s.path, err)
+	}
+	s.db = db
+	return nil
+}
+
+// Close закрывает файл. Пока он закрыт, репозитории не принимают изменения настроек
+func (s *BoltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// update выполняет fn в транзакции записи над бакетом bucket. Транзакции записи
+// bbolt выполняет по одной, но без повторного открытия файла они короткие
+func (s *BoltStore) update(bucket []byte, fn func(b *bbolt.Bucket) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.db == nil {
+		return ErrBoltClosed
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// BoltRepository хранит настройки клиентов из /clients в бакете BoltStore и загружает
+// их при старте. Токены и клиенты, созданные на первом запросе, живут только в памяти.
+// Пока файл закрыт (Close), изменения настроек отклоняются с ErrBoltClosed
+type BoltRepository struct {
+	*ClientMemoryRepository
+	store  *BoltStore
+	bucket []byte
+	logger *slog.Logger
+
+	// writeMu — запись в файл и изменение в памяти идут одной операцией
+	writeMu sync.Mutex
+}
+
+// NewBoltRepo загружает клиентов из бакета bucket файла store; в одном файле хранятся
+// несколько репозиториев (клиенты, организации)
+func NewBoltRepo(store *BoltStore, bucket string, defaultCapacity int, defaultRPS float64, logger *slog.Logger, opts ...RepoOption) (*BoltRepository, error) {
+	r := &BoltRepository{
+		ClientMemoryRepository: NewMemoryRepo(defaultCapacity, defaultRPS, logger, opts...),
+		store:                  store,
+		bucket:                 []byte(bucket),
+		logger:                 logger,
+	}
+	loaded := 0
+	err := store.update(r.bucket, func(b *bbolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			var rec boltRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("client %q: %w", k, err)
+			}
+			r.ClientMemoryRepository.AddClient(string(k), Limit{Algorithm: rec.Algorithm, Capacity: rec.Capacity, RPS: rec.RPS}, rec.Tenant)
+			loaded++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load %s from %s: %w", bucket, store.path, err)
+	}
+	logger.Info("clients loaded", "path", store.path, "bucket", bucket, "count", loaded)
+	return r, nil
+}
+
+// put записывает настройки клиента в файл. Репозиторий меняется только после
+// успешной записи, иначе, например пока файл закрыт на время обновления бинаря,
+// изменение потерялось бы при рестарте
+func (r *BoltRepository) put(id string, limit Limit, tenant string) error {
+	data, _ := json.Marshal(boltRecord{Algorithm: limit.Algorithm, Capacity: limit.Capacity, RPS: limit.RPS, Tenant: tenant})
+	err := r.store.update(r.bucket, func(b *bbolt.Bucket) error {
+		return b.Put([]byte(id), data)
+	})
+	if err != nil {
+		r.logger.Error("failed to persist client", "id", id, "path", r.store.path, "error", err)
+		return fmt.Errorf("persist client %q: %w", id, err)
+	}
+	return nil
+}
+
+// AddClient записывает настройки и организацию клиента в файл и создаёт его
+func (r *BoltRepository) AddClient(id string, limit Limit, tenant string) (*Client, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if err := r.put(id, limit, tenant); err != nil {
+		return nil, err
+	}
+	return r.ClientMemoryRepository.AddClient(id, limit, tenant)
+}
+
+func (r *BoltRepository) UpdateClient(id string, limit Limit) (*Client, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	cl := r.ClientMemoryRepository.GetClient(id)
+	if cl == nil {
+		return nil, ErrNoClient
+	}
+	if err := r.put(id, limit, cl.Tenant); err != nil {
+		return nil, err
+	}
+	return r.ClientMemoryRepository.UpdateClient(id, limit)
+}
+
+func (r *BoltRepository) SetTenant(id, tenant string) (*Client, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	cl := r.ClientMemoryRepository.GetClient(id)
+	if cl == nil {
+		return nil, ErrNoClient
+	}
+	if err := r.put(id, cl.Limit, tenant); err != nil {
+		return nil, err
+	}
+	return r.ClientMemoryRepository.SetTenant(id, tenant)
+}
+
+func (r *BoltRepository) DeleteClient(id string) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if r.ClientMemoryRepository.GetClient(id) == nil {
+		return ErrNoClient
+	}
+	err := r.store.update(r.bucket, func(b *bbolt.Bucket) error {
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		r.logger.Error("failed to delete persisted client", "id", id, "path", r.store.path, "error", err)
+		return fmt.Errorf("delete persisted client %q: %w", id, err)
+	}
+	return r.ClientMemoryRepository.DeleteClient(id)
+}
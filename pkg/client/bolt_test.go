@@ -0,0 +1,119 @@
+package client_test
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+// openBoltStore открывает файл и закрывает его в конце теста
+func openBoltStore(t *testing.T, path string) *client.BoltStore {
+	t.Helper()
+	store, err := client.OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltRepoSurvivesRestart(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	path := filepath.Join(t.TempDir(), "data", "clients.db")
+	store := openBoltStore(t, path)
+
+	repo, err := client.NewBoltRepo(store, "clients", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("NewBoltRepo: %v", err)
+	}
+	repo.AddClient("alice", client.Limit{Capacity: 10, RPS: 0.5}, "")
+	repo.AddClient("bob", client.Limit{Capacity: 5, RPS: 1}, "")
+	if _, err := repo.UpdateClient("alice", client.Limit{Capacity: 20, RPS: 2}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if err := repo.DeleteClient("bob"); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	// клиент, созданный на первом запросе, в файл не попадает
+	repo.Consume("10.0.0.1", 1)
+
+	// новый процесс с тем же файлом
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	restarted, err := client.NewBoltRepo(openBoltStore(t, path), "clients", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	cl := restarted.GetClient("alice")
+	if cl == nil || cl.Limit.Capacity != 20 || cl.Limit.RPS != 2 {
+		t.Fatalf("alice after restart = %+v; want capacity 20, rps 2", cl)
+	}
+	if restarted.GetClient("bob") != nil {
+		t.Error("deleted client restored after restart")
+	}
+	if restarted.GetClient("10.0.0.1") != nil {
+		t.Error("auto-created client persisted")
+	}
+	if !restarted.Consume("alice", 20).Allowed || restarted.Consume("alice", 1).Allowed {
+		t.Error("restored client should start with a full bucket of 20")
+	}
+}
+
+func TestBoltStoreCloseAndReopen(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	path := filepath.Join(t.TempDir(), "clients.db")
+	store := openBoltStore(t, path)
+	repo, err := client.NewBoltRepo(store, "clients", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("NewBoltRepo: %v", err)
+	}
+
+	repo.AddClient("carol", client.Limit{Capacity: 7, RPS: 1}, "")
+
+	// пока файл закрыт (обновление бинаря), его может открыть другой процесс, а
+	// изменения отклоняются: в памяти они потерялись бы при рестарте
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	other := openBoltStore(t, path)
+	if _, err := repo.AddClient("alice", client.Limit{Capacity: 10, RPS: 1}, ""); !errors.Is(err, client.ErrBoltClosed) {
+		t.Errorf("AddClient on closed file: err = %v; want ErrBoltClosed", err)
+	}
+	if repo.GetClient("alice") != nil {
+		t.Error("client created in memory although the file is closed")
+	}
+	if _, err := repo.UpdateClient("carol", client.Limit{Capacity: 1, RPS: 1}); !errors.Is(err, client.ErrBoltClosed) {
+		t.Errorf("UpdateClient on closed file: err = %v; want ErrBoltClosed", err)
+	}
+	if _, err := repo.SetTenant("carol", "acme"); !errors.Is(err, client.ErrBoltClosed) {
+		t.Errorf("SetTenant on closed file: err = %v; want ErrBoltClosed", err)
+	}
+	if err := repo.DeleteClient("carol"); !errors.Is(err, client.ErrBoltClosed) {
+		t.Errorf("DeleteClient on closed file: err = %v; want ErrBoltClosed", err)
+	}
+	if cl := repo.GetClient("carol"); cl == nil || cl.Limit.Capacity != 7 || cl.Tenant != "" {
+		t.Errorf("client changed although the file is closed: %+v", cl)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatalf("Close other: %v", err)
+	}
+
+	// обновление не удалось — файл снова наш
+	if err := store.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	repo.AddClient("bob", client.Limit{Capacity: 5, RPS: 1}, "")
+	store.Close()
+	restarted, err := client.NewBoltRepo(openBoltStore(t, path), "clients", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if restarted.GetClient("bob") == nil || restarted.GetClient("carol") == nil || restarted.GetClient("alice") != nil {
+		t.Error("want only the clients added while the file was open persisted")
+	}
+}
@@ -1,54 +1,159 @@
 package client
 
-// Структкра описывает бакеты токенов. Используется для реализация Rate-Limiting
+import (
+	"math"
+	"time"
+)
+
+// Структкра описывает бакеты токенов. Используется для реализация Rate-Limiting.
+// Токены пополняются лениво: при обращении к бакету добавляется RPS * прошедшее
+// время, поэтому фоновое пополнение не нужно, а дробные скорости (0.5 rps) точны
 type TokenBucket struct {
-	Capacity      int
-	CurrentTokens int
-	RPS           int
+	Capacity int
+	RPS      float64
+	// tokens — запас на момент updated
+	tokens  float64
+	updated time.Time
+}
+
+// NewTokenBucket создаёт полный бакет
+func NewTokenBucket(capacity int, rps float64, now time.Time) TokenBucket {
+	return TokenBucket{Capacity: capacity, RPS: rps, tokens: float64(capacity), updated: now}
 }
 
-// Refill добавляет токены, не превышая Capacity
-func (tb *TokenBucket) Refill() {
-	if tb.Capacity > tb.CurrentTokens+tb.RPS {
-		tb.CurrentTokens = tb.CurrentTokens + tb.RPS
-		return
+// Tokens возвращает запас токенов на момент now, не меняя бакет
+func (tb TokenBucket) Tokens(now time.Time) float64 {
+	elapsed := now.Sub(tb.updated).Seconds()
+	if elapsed <= 0 || tb.RPS <= 0 {
+		return min(tb.tokens, float64(tb.Capacity))
 	}
-	tb.CurrentTokens = tb.Capacity
+	return min(tb.tokens+elapsed*tb.RPS, float64(tb.Capacity))
 }
 
-// Allow пытается «потратить» n токенов.
+// CurrentTokens возвращает число целых токенов, доступных на момент now
+func (tb TokenBucket) CurrentTokens(now time.Time) int {
+	return int(math.Floor(tb.Tokens(now)))
+}
+
+// refill начисляет токены за время с прошлого обращения, не превышая Capacity
+func (tb *TokenBucket) refill(now time.Time) {
+	tb.tokens = tb.Tokens(now)
+	if now.After(tb.updated) {
+		tb.updated = now
+	}
+}
+
+// Allow пытается «потратить» n токенов на момент now.
 // Возвращает true, если удалось, false — иначе
-func (tb *TokenBucket) Allow(n int) bool {
-	if tb.CurrentTokens < n {
+func (tb *TokenBucket) Allow(n int, now time.Time) bool {
+	tb.refill(now)
+	if tb.tokens < float64(n) {
 		return false
 	}
-	tb.CurrentTokens -= n
+	tb.tokens -= float64(n)
 	return true
 }
 
-// Структкра описывает сущность клиентов
+// Reset меняет параметры и заполняет бакет до новой вместимости
+func (tb *TokenBucket) Reset(capacity int, rps float64, now time.Time) {
+	*tb = NewTokenBucket(capacity, rps, now)
+}
+
+// Структкра описывает сущность клиентов. Клиенты из репозитория — снимки:
+// Remaining посчитан в момент чтения, состояние алгоритма остаётся в репозитории
 type Client struct {
-	ID          string `json:"client_id"`
-	TokenBucket TokenBucket
+	ID    string `json:"client_id"`
+	Limit Limit
+	// Tenant — организация, которой принадлежит клиент; её лимит общий для всех её клиентов
+	Tenant string
+	// Remaining — сколько запросов стоимостью 1 пройдёт на момент снимка
+	Remaining int
+
+	limiter limiter
 }
 
-func NewClient(id string, capacity, rps int) *Client {
-	return &Client{
-		ID: id,
-		TokenBucket: TokenBucket{
-			Capacity:      capacity,
-			CurrentTokens: capacity,
-			RPS:           rps},
+func NewClient(id string, limit Limit) *Client {
+	now := time.Now()
+	cl := &Client{ID: id, Limit: limit, limiter: limit.newLimiter(now)}
+	cl.Remaining = cl.limiter.remaining(now)
+	return cl
+}
+
+// Decision — результат Consume, из него строятся заголовки RateLimit-* и Retry-After
+type Decision struct {
+	Allowed bool
+	// Limit — вместимость клиента; 0 — лимит неизвестен (Redis недоступен, fallback open/closed)
+	Limit int
+	// Remaining — сколько запросов стоимостью 1 пройдёт сразу после этого
+	Remaining int
+	// Reset — через сколько лимит восстановится полностью
+	Reset time.Duration
+	// RetryAfter — через сколько пройдёт ещё один запрос той же стоимости; 0 — проходит
+	// сейчас или не пройдёт никогда (стоимость больше вместимости, нет пополнения)
+	RetryAfter time.Duration
+	// Delay — сколько запрос должен подождать в очереди перед отправкой (leaky_bucket)
+	Delay time.Duration
+	// Tenant — организация клиента, если она назначена
+	Tenant string
+
+	// spent — что потрачено пропущенным запросом, для Refund
+	spent spent
+}
+
+// spent — бакет и стоимость пропущенного запроса. limit — действовавшие настройки:
+// в Redis по ним восстанавливается алгоритм состояния
+type spent struct {
+	id    string
+	n     int
+	limit Limit
+	// local — решение принял локальный репозиторий, пока Redis был недоступен
+	local bool
+}
+
+// And объединяет решения уровней, которые запрос должен пройти все: отказ любого
+// уровня — отказ. Заголовки берутся у отказавшего уровня или у того, где осталось
+// меньше всего; ожидание в очереди — наибольшее
+func (d Decision) And(o Decision) Decision {
+	if !d.Allowed {
+		return d
+	}
+	if !o.Allowed {
+		return o
 	}
+	delay := max(d.Delay, o.Delay)
+	if o.Limit > 0 && (d.Limit == 0 || o.Remaining < d.Remaining) {
+		d, o = o, d
+	}
+	d.Delay = delay
+	return d
 }
 
 type ClientRepo interface {
 	GetClient(id string) *Client
-	AddClient(id string, capacity, rps int) *Client
-	UpdateClient(id string, capacity, rps int) (*Client, error)
+	// AddClient, UpdateClient, DeleteClient и SetTenant возвращают ошибку, отличную от
+	// ErrNoClient, если хранилище не приняло изменение; тогда клиент остаётся прежним
+	// AddClient создаёт клиента с организацией tenant ("" — без неё) одной записью
+	AddClient(id string, limit Limit, tenant string) (*Client, error)
+	UpdateClient(id string, limit Limit) (*Client, error)
 	DeleteClient(id string) error
+	// SetTenant назначает клиенту организацию, пустая — снимает. Лимит клиента не
+	// меняется, клиент, созданный на первом запросе, становится настроенным
+	SetTenant(id, tenant string) (*Client, error)
 
-	Consume(id string, n int) bool
-	DefaultRPS() int
+	// Consume тратит n единиц лимита клиента. Если алгоритм ставит запрос в очередь
+	// (AlgorithmLeakyBucket), ждать Decision.Delay должен вызывающий
+	Consume(id string, n int) Decision
+	// ConsumeWith — как Consume, но клиент без своих настроек создаётся с limit, а не
+	// с параметрами по умолчанию (отдельные бакеты для политик маршрутов)
+	ConsumeWith(id string, n int, limit Limit) Decision
+	// Refund возвращает потраченное пропущенным решением d, когда запрос отклонил
+	// другой уровень лимитов. Для отклонённых решений ничего не делает
+	Refund(d Decision)
+	DefaultRPS() float64
 	DefaultCapacity() int
+	SetDefaults(capacity int, rps float64)
+
+	// SetEviction и EvictIdle ограничивают число клиентов, созданных на первом запросе
+	SetEviction(idleTTL time.Duration, maxClients int)
+	EvictIdle() int
 }
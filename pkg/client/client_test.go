@@ -2,6 +2,7 @@ package client_test
 
 import (
 	"testing"
+	"time"
 
 	"log/slog"
 	"os"
@@ -17,35 +18,35 @@ func setupRepo() *client.ClientMemoryRepository {
 func TestAddAndGetClient(t *testing.T) {
 	repo := setupRepo()
 	id := "test-client"
-	repo.AddClient(id, 20, 10)
+	repo.AddClient(id, client.Limit{Capacity: 20, RPS: 10}, "")
 
 	cl := repo.GetClient(id)
 	if cl == nil {
 		t.Fatal("expected client to be added")
 	}
-	if cl.TokenBucket.Capacity != 20 || cl.TokenBucket.RPS != 10 {
-		t.Errorf("unexpected token bucket values: %+v", cl.TokenBucket)
+	if cl.Limit.Capacity != 20 || cl.Limit.RPS != 10 {
+		t.Errorf("unexpected token bucket values: %+v", cl.Limit)
 	}
 }
 
 func TestUpdateClient(t *testing.T) {
 	repo := setupRepo()
 	id := "client-update"
-	repo.AddClient(id, 10, 5)
+	repo.AddClient(id, client.Limit{Capacity: 10, RPS: 5}, "")
 
-	updated, err := repo.UpdateClient(id, 30, 15)
+	updated, err := repo.UpdateClient(id, client.Limit{Capacity: 30, RPS: 15})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if updated.TokenBucket.Capacity != 30 || updated.TokenBucket.RPS != 15 {
-		t.Errorf("update failed: %+v", updated.TokenBucket)
+	if updated.Limit.Capacity != 30 || updated.Limit.RPS != 15 {
+		t.Errorf("update failed: %+v", updated.Limit)
 	}
 }
 
 func TestDeleteClient(t *testing.T) {
 	repo := setupRepo()
 	id := "client-delete"
-	repo.AddClient(id, 10, 5)
+	repo.AddClient(id, client.Limit{Capacity: 10, RPS: 5}, "")
 
 	err := repo.DeleteClient(id)
 	if err != nil {
@@ -59,27 +60,61 @@ func TestDeleteClient(t *testing.T) {
 func TestConsumeTokens(t *testing.T) {
 	repo := setupRepo()
 	id := "client-consume"
-	repo.AddClient(id, 5, 2)
+	repo.AddClient(id, client.Limit{Capacity: 5, RPS: 2}, "")
 
-	ok := repo.Consume(id, 3)
+	ok := repo.Consume(id, 3).Allowed
 	if !ok {
 		t.Error("expected to consume tokens")
 	}
 	cl := repo.GetClient(id)
-	if cl.TokenBucket.CurrentTokens != 2 {
-		t.Errorf("unexpected token count: %d", cl.TokenBucket.CurrentTokens)
+	if got := cl.Remaining; got != 2 {
+		t.Errorf("unexpected token count: %d", got)
 	}
 }
 
-func TestReplenishTokens(t *testing.T) {
+func TestTokenBucketRefillsByElapsedTime(t *testing.T) {
+	start := time.Now()
+	tb := client.NewTokenBucket(10, 3, start)
+	if !tb.Allow(10, start) {
+		t.Fatal("expected full bucket")
+	}
+	if tb.Allow(1, start.Add(300*time.Millisecond)) {
+		t.Error("0.9 tokens after 300ms; want reject")
+	}
+	// 3 rps за 2 секунды — 6 токенов, без тикера
+	if got := tb.CurrentTokens(start.Add(2 * time.Second)); got != 6 {
+		t.Errorf("expected 6 tokens after 2s, got %d", got)
+	}
+	if got := tb.CurrentTokens(start.Add(time.Hour)); got != 10 {
+		t.Errorf("expected refill capped at capacity 10, got %d", got)
+	}
+}
+
+func TestTokenBucketFractionalRate(t *testing.T) {
+	start := time.Now()
+	tb := client.NewTokenBucket(1, 0.5, start)
+	if !tb.Allow(1, start) {
+		t.Fatal("expected first request allowed")
+	}
+	if tb.Allow(1, start.Add(1500*time.Millisecond)) {
+		t.Error("0.75 tokens after 1.5s at 0.5 rps; want reject")
+	}
+	if !tb.Allow(1, start.Add(2*time.Second)) {
+		t.Error("one token after 2s at 0.5 rps; want allow")
+	}
+}
+
+func TestUpdateClientRefillsBucket(t *testing.T) {
 	repo := setupRepo()
-	id := "client-replenish"
-	repo.AddClient(id, 10, 3)
-	repo.Consume(id, 5)
+	id := "client-refill"
+	repo.AddClient(id, client.Limit{Capacity: 10, RPS: 0.001}, "")
+	repo.Consume(id, 8)
 
-	repo.Replenish()
+	if _, err := repo.UpdateClient(id, client.Limit{Capacity: 4, RPS: 0.5}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	cl := repo.GetClient(id)
-	if cl.TokenBucket.CurrentTokens != 8 {
-		t.Errorf("expected 8 tokens, got %d", cl.TokenBucket.CurrentTokens)
+	if got := cl.Remaining; got != 4 || cl.Limit.RPS != 0.5 {
+		t.Errorf("expected full bucket of 4 at 0.5 rps, got %d at %v", got, cl.Limit.RPS)
 	}
 }
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Algorithm — алгоритм ограничения запросов клиента
+type Algorithm string
+
+const (
+	// AlgorithmTokenBucket — бакет на Capacity токенов, пополняется RPS токенами в секунду.
+	// Допускает всплеск до Capacity запросов
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+	// AlgorithmSlidingLog — не больше Capacity запросов в любом окне Capacity/RPS секунд.
+	// Точный, но хранит время каждого запроса в окне
+	AlgorithmSlidingLog Algorithm = "sliding_log"
+	// AlgorithmSlidingWindow — то же окно, но по двум счётчикам: предыдущее окно
+	// учитывается пропорционально перекрытию. Память постоянная, граница приблизительная
+	AlgorithmSlidingWindow Algorithm = "sliding_window"
+	// AlgorithmGCRA — запросы не чаще 1/RPS с допуском всплеска до Capacity. Поведение
+	// как у бакета токенов, но состояние — одна метка времени
+	AlgorithmGCRA Algorithm = "gcra"
+	// AlgorithmLeakyBucket — очередь на Capacity запросов, которая отправляется ровно
+	// с RPS в секунду: запрос не отклоняется, а ждёт своей очереди. Всплесков нет
+	AlgorithmLeakyBucket Algorithm = "leaky_bucket"
+)
+
+var ErrInvalidAlgorithm = errors.New("invalid rate limit algorithm")
+
+// ParseAlgorithm проверяет алгоритм из запроса или конфига, пустой — AlgorithmTokenBucket
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case "":
+		return AlgorithmTokenBucket, nil
+	case AlgorithmTokenBucket, AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmGCRA, AlgorithmLeakyBucket:
+		return a, nil
+	}
+	return "", fmt.Errorf("%w %q: want token_bucket, sliding_log, sliding_window, gcra or leaky_bucket", ErrInvalidAlgorithm, s)
+}
+
+// Limit — алгоритм и его параметры. Capacity — всплеск, размер окна в запросах или
+// длина очереди, RPS — средняя скорость. При RPS <= 0 любой алгоритм пропускает
+// Capacity запросов за всё время, как бакет без пополнения (так же и при Capacity <= 0)
+type Limit struct {
+	Algorithm Algorithm
+	Capacity  int
+	RPS       float64
+}
+
+// limiter — состояние алгоритма одного клиента. Вызывается под блокировкой репозитория
+type limiter interface {
+	// reserve решает, пропустить ли запрос стоимостью n на момент now
+	reserve(n int, now time.Time) Decision
+	// remaining — сколько запросов стоимостью 1 пройдёт на момент now без отказа
+	remaining(now time.Time) int
+	// refund возвращает n единиц, потраченных пропущенным reserve
+	refund(n int, now time.Time)
+}
+
+// newLimiter создаёт пустое (всё разрешающее) состояние алгоритма
+func (l Limit) newLimiter(now time.Time) limiter {
+	if l.RPS <= 0 || l.Capacity <= 0 {
+		tb := NewTokenBucket(l.Capacity, l.RPS, now)
+		return &tb
+	}
+	window := seconds(float64(l.Capacity) / l.RPS)
+	interval := max(seconds(1/l.RPS), 1)
+	switch l.Algorithm {
+	case AlgorithmSlidingLog:
+		return &slidingLog{limit: l.Capacity, window: window}
+	case AlgorithmSlidingWindow:
+		return &slidingWindow{limit: l.Capacity, window: window, start: now}
+	case AlgorithmGCRA:
+		return &gcra{interval: interval, tolerance: time.Duration(l.Capacity) * interval}
+	case AlgorithmLeakyBucket:
+		return &gcra{interval: interval, tolerance: time.Duration(l.Capacity) * interval, queue: true}
+	}
+	tb := NewTokenBucket(l.Capacity, l.RPS, now)
+	return &tb
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func (tb *TokenBucket) reserve(n int, now time.Time) Decision {
+	d := Decision{Allowed: tb.Allow(n, now), Limit: tb.Capacity, Remaining: int(math.Floor(tb.tokens))}
+	if tb.RPS > 0 {
+		d.Reset = seconds((float64(tb.Capacity) - tb.tokens) / tb.RPS)
+		if tb.tokens < float64(n) && n <= tb.Capacity {
+			d.RetryAfter = seconds((float64(n) - tb.tokens) / tb.RPS)
+		}
+	}
+	return d
+}
+
+func (tb *TokenBucket) remaining(now time.Time) int {
+	return tb.CurrentTokens(now)
+}
+
+func (tb *TokenBucket) refund(n int, _ time.Time) {
+	tb.tokens = min(tb.tokens+float64(n), float64(tb.Capacity))
+}
+
+// slidingLog хранит время каждого пропущенного запроса в окне, по возрастанию
+type slidingLog struct {
+	limit  int
+	window time.Duration
+	log    []time.Time
+}
+
+// trim удаляет запросы, вышедшие из окна
+func (l *slidingLog) trim(now time.Time) {
+	i := 0
+	for i < len(l.log) && !l.log[i].After(now.Add(-l.window)) {
+		i++
+	}
+	if i > 0 {
+		l.log = append(l.log[:0], l.log[i:]...)
+	}
+}
+
+func (l *slidingLog) reserve(n int, now time.Time) Decision {
+	l.trim(now)
+	d := Decision{Limit: l.limit}
+	if len(l.log)+n <= l.limit {
+		d.Allowed = true
+		for range n {
+			l.log = append(l.log, now)
+		}
+	}
+	d.Remaining = l.limit - len(l.log)
+	if len(l.log) > 0 {
+		d.Reset = l.log[len(l.log)-1].Add(l.window).Sub(now)
+	}
+	// следующий такой же запрос пройдёт, когда из окна выйдут k самых старых
+	if k := len(l.log) + n - l.limit; k > 0 && n <= l.limit {
+		d.RetryAfter = l.log[k-1].Add(l.window).Sub(now)
+	}
+	return d
+}
+
+func (l *slidingLog) remaining(now time.Time) int {
+	l.trim(now)
+	return l.limit - len(l.log)
+}
+
+// refund удаляет n самых свежих запросов: среди них и возвращаемые
+func (l *slidingLog) refund(n int, _ time.Time) {
+	l.log = l.log[:max(len(l.log)-n, 0)]
+}
+
+// slidingWindow считает запросы в текущем фиксированном окне и в предыдущем.
+// Оценка за скользящее окно: prev * (непрошедшая доля текущего окна) + cur
+type slidingWindow struct {
+	limit     int
+	window    time.Duration
+	start     time.Time
+	cur, prev float64
+}
+
+// advance переносит окно на now и возвращает оценку числа запросов в скользящем окне
+func (w *slidingWindow) advance(now time.Time) float64 {
+	if elapsed := now.Sub(w.start); elapsed >= w.window {
+		k := elapsed / w.window
+		if k == 1 {
+			w.prev = w.cur
+		} else {
+			w.prev = 0
+		}
+		w.cur = 0
+		w.start = w.start.Add(k * w.window)
+	}
+	frac := float64(now.Sub(w.start)) / float64(w.window)
+	return w.prev*(1-max(frac, 0)) + w.cur
+}
+
+// until возвращает, через сколько оценка est опустится до level. В текущем окне
+// убывает вклад prev, в следующем cur становится prev и убывает так же
+func (w *slidingWindow) until(est, level float64, now time.Time) time.Duration {
+	if est <= level || level < 0 {
+		return 0
+	}
+	var at time.Time
+	switch {
+	case w.cur <= level:
+		at = w.start.Add(time.Duration((1 - (level-w.cur)/w.prev) * float64(w.window)))
+	default:
+		at = w.start.Add(w.window + time.Duration((1-level/w.cur)*float64(w.window)))
+	}
+	return max(at.Sub(now), 0)
+}
+
+func (w *slidingWindow) reserve(n int, now time.Time) Decision {
+	est := w.advance(now)
+	d := Decision{Limit: w.limit}
+	if est+float64(n) <= float64(w.limit) {
+		d.Allowed = true
+		w.cur += float64(n)
+		est += float64(n)
+	}
+	d.Remaining = int(math.Floor(float64(w.limit) - est))
+	d.Reset = w.until(est, 0, now)
+	d.RetryAfter = w.until(est, float64(w.limit-n), now)
+	return d
+}
+
+func (w *slidingWindow) remaining(now time.Time) int {
+	return int(math.Floor(float64(w.limit) - w.advance(now)))
+}
+
+// refund вычитает из текущего окна, а если оно уже сменилось — из предыдущего
+func (w *slidingWindow) refund(n int, _ time.Time) {
+	take := min(w.cur, float64(n))
+	w.cur -= take
+	w.prev = max(w.prev-(float64(n)-take), 0)
+}
+
+// gcra — Generic Cell Rate Algorithm: tat (theoretical arrival time) — когда клиент
+// «расплатится» за уже пропущенные запросы. Запрос проходит, если после него долг
+// не больше tolerance. Это и есть дырявое ведро как счётчик; с queue запросы не
+// проходят сразу, а ждут до max(tat, now) — дырявое ведро как очередь
+type gcra struct {
+	interval  time.Duration
+	tolerance time.Duration
+	tat       time.Time
+	queue     bool
+}
+
+func (g *gcra) reserve(n int, now time.Time) Decision {
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	cost := time.Duration(n) * g.interval
+	d := Decision{Limit: int(g.tolerance / g.interval)}
+	if tat.Add(cost).Sub(now) <= g.tolerance {
+		d.Allowed = true
+		if g.queue {
+			d.Delay = tat.Sub(now)
+		}
+		tat = tat.Add(cost)
+		g.tat = tat
+	}
+	debt := tat.Sub(now)
+	d.Remaining = int((g.tolerance - debt) / g.interval)
+	d.Reset = debt
+	if cost <= g.tolerance {
+		d.RetryAfter = max(debt+cost-g.tolerance, 0)
+	}
+	return d
+}
+
+// refund уменьшает долг, но не раньше now: прошедшее время уже «оплачено»
+func (g *gcra) refund(n int, now time.Time) {
+	if !g.tat.After(now) {
+		return
+	}
+	g.tat = g.tat.Add(-time.Duration(n) * g.interval)
+	if g.tat.Before(now) {
+		g.tat = now
+	}
+}
+
+func (g *gcra) remaining(now time.Time) int {
+	debt := g.tat.Sub(now)
+	if debt < 0 {
+		debt = 0
+	}
+	return int((g.tolerance - debt) / g.interval)
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketDecision(t *testing.T) {
+	start := time.Now()
+	l := Limit{Capacity: 4, RPS: 2}.newLimiter(start)
+	d := l.reserve(3, start)
+	if !d.Allowed || d.Limit != 4 || d.Remaining != 1 || d.Reset != 1500*time.Millisecond || d.RetryAfter != time.Second {
+		t.Errorf("decision = %+v; want allowed, 1 remaining, reset 1.5s, retry after 1s", d)
+	}
+	if d := l.reserve(5, start); d.Allowed || d.RetryAfter != 0 {
+		t.Errorf("cost over capacity = %+v; want rejected without retry after", d)
+	}
+}
+
+func TestSlidingLogCountsRequestsInWindow(t *testing.T) {
+	start := time.Now()
+	// 3 запроса в окне 3/1 = 3 секунды
+	l := Limit{Algorithm: AlgorithmSlidingLog, Capacity: 3, RPS: 1}.newLimiter(start)
+	for i, at := range []time.Duration{0, time.Second, 2 * time.Second} {
+		if !l.reserve(1, start.Add(at)).Allowed {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	d := l.reserve(1, start.Add(2500*time.Millisecond))
+	if d.Allowed || d.RetryAfter != 500*time.Millisecond || d.Reset != 2500*time.Millisecond {
+		t.Errorf("4th request within 3s window = %+v; want rejected, retry after 0.5s, reset 2.5s", d)
+	}
+	// первый запрос вышел из окна, остальные два — нет
+	if !l.reserve(1, start.Add(3001*time.Millisecond)).Allowed {
+		t.Error("request after first left the window rejected")
+	}
+	if got := l.remaining(start.Add(3500 * time.Millisecond)); got != 0 {
+		t.Errorf("remaining = %d; want 0", got)
+	}
+}
+
+func TestSlidingWindowWeighsPreviousWindow(t *testing.T) {
+	start := time.Now()
+	// 10 запросов в окне 10 секунд
+	l := Limit{Algorithm: AlgorithmSlidingWindow, Capacity: 10, RPS: 1}.newLimiter(start)
+	if !l.reserve(10, start).Allowed {
+		t.Fatal("full window rejected")
+	}
+	// через 12 секунд от предыдущего окна учитывается 80%: 8 запросов
+	at := start.Add(12 * time.Second)
+	if got := l.remaining(at); got != 2 {
+		t.Errorf("remaining = %d; want 2", got)
+	}
+	// 3 пройдут, когда вклад предыдущего окна упадёт до 7 — на 13-й секунде
+	d := l.reserve(3, at)
+	if d.Allowed || d.RetryAfter != time.Second || d.Reset != 8*time.Second {
+		t.Errorf("3 requests over estimated 8 of 10 = %+v; want rejected, retry after 1s, reset 8s", d)
+	}
+	// два окна простоя — история обнуляется
+	if got := l.remaining(start.Add(30 * time.Second)); got != 10 {
+		t.Errorf("remaining after idle = %d; want 10", got)
+	}
+}
+
+func TestGCRAAllowsBurstThenPaces(t *testing.T) {
+	start := time.Now()
+	l := Limit{Algorithm: AlgorithmGCRA, Capacity: 3, RPS: 2}.newLimiter(start)
+	for i := range 3 {
+		if d := l.reserve(1, start); !d.Allowed || d.Delay != 0 {
+			t.Fatalf("burst request %d = %+v; want allowed without delay", i, d)
+		}
+	}
+	d := l.reserve(1, start)
+	if d.Allowed || d.RetryAfter != 500*time.Millisecond || d.Reset != 1500*time.Millisecond {
+		t.Errorf("request over burst = %+v; want rejected, retry after 0.5s, reset 1.5s", d)
+	}
+	if !l.reserve(1, start.Add(500*time.Millisecond)).Allowed {
+		t.Error("request after emission interval rejected")
+	}
+	if got := l.remaining(start.Add(time.Hour)); got != 3 {
+		t.Errorf("remaining after idle = %d; want 3", got)
+	}
+}
+
+func TestLeakyBucketQueuesRequests(t *testing.T) {
+	start := time.Now()
+	l := Limit{Algorithm: AlgorithmLeakyBucket, Capacity: 3, RPS: 10}.newLimiter(start)
+	// очередь отправляется по одному запросу в 100ms
+	for i := range 3 {
+		d := l.reserve(1, start)
+		if want := time.Duration(i) * 100 * time.Millisecond; !d.Allowed || d.Delay != want {
+			t.Fatalf("request %d = %+v; want queued for %v", i, d, want)
+		}
+	}
+	if l.reserve(1, start).Allowed {
+		t.Error("request over queue length allowed")
+	}
+	if d := l.reserve(1, start.Add(150*time.Millisecond)); !d.Allowed || d.Delay != 150*time.Millisecond {
+		t.Errorf("request after one slot freed = %+v; want queued for 150ms", d)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	if a, err := ParseAlgorithm(""); err != nil || a != AlgorithmTokenBucket {
+		t.Errorf("ParseAlgorithm(\"\") = %q, %v; want token_bucket", a, err)
+	}
+	if _, err := ParseAlgorithm("fixed_window"); err == nil {
+		t.Error("ParseAlgorithm(\"fixed_window\"): want error")
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmTokenBucket, AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmGCRA, AlgorithmLeakyBucket} {
+		t.Run(string(algo), func(t *testing.T) {
+			start := time.Now()
+			l := Limit{Algorithm: algo, Capacity: 2, RPS: 1}.newLimiter(start)
+			l.reserve(1, start)
+			l.reserve(1, start)
+			l.refund(1, start)
+			if got := l.remaining(start); got != 1 {
+				t.Errorf("remaining after refund = %d; want 1", got)
+			}
+			// возврат не поднимает лимит выше Capacity
+			l.refund(5, start)
+			if got := l.remaining(start); got != 2 {
+				t.Errorf("remaining after oversized refund = %d; want 2", got)
+			}
+		})
+	}
+}
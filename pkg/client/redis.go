@@ -0,0 +1,566 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fallback — поведение Redis-репозитория, пока Redis недоступен
+type Fallback string
+
+const (
+	// FallbackLocal — лимиты считает локальный репозиторий реплики
+	FallbackLocal Fallback = "local"
+	// FallbackOpen — все запросы пропускаются
+	FallbackOpen Fallback = "open"
+	// FallbackClosed — все запросы отклоняются
+	FallbackClosed Fallback = "closed"
+)
+
+var (
+	ErrInvalidFallback = errors.New("invalid fallback mode")
+	// ErrRedisUnavailable — изменение настроек не записано в Redis и не применено
+	ErrRedisUnavailable = errors.New("redis is unavailable")
+)
+
+// ParseFallback проверяет режим из конфига, пустой — FallbackLocal
+func ParseFallback(s string) (Fallback, error) {
+	switch f := Fallback(s); f {
+	case "":
+		return FallbackLocal, nil
+	case FallbackLocal, FallbackOpen, FallbackClosed:
+		return f, nil
+	}
+	return "", fmt.Errorf("%w %q: want local, open or closed", ErrInvalidFallback, s)
+}
+
+// consumeScript атомарно обновляет состояние алгоритма клиента и тратит n единиц.
+// Время берётся у Redis, поэтому реплики балансировщика считают по одним часам.
+// Состояние удаляется по EXPIRE, когда снова станет пустым: пустое состояние не
+// отличается от отсутствующего. Алгоритмы и поля Decision повторяют limit.go.
+// KEYS: настройки клиента, состояние. ARGV: n, вместимость и скорость по умолчанию,
+// 1 — только посмотреть (peek), ничего не записывая, алгоритм по умолчанию.
+// Возвращает {1|0, остаток, вместимость, скорость, алгоритм, reset, retry after, delay,
+// организация}, времена — секунды строкой; для peek неизвестного клиента — {-1}
+var consumeScript = redis.NewScript(`
+local cfg = redis.call('HMGET', KEYS[1], 'capacity', 'rps', 'algorithm', 'tenant')
+local capacity, rps, algo
+if cfg[1] then
+  capacity, rps, algo = tonumber(cfg[1]), tonumber(cfg[2]), cfg[3] or ''
+else
+  capacity, rps, algo = tonumber(ARGV[2]), tonumber(ARGV[3]), ARGV[5]
+end
+local n = tonumber(ARGV[1])
+local peek = ARGV[4] == '1'
+if peek and not cfg[1] and redis.call('EXISTS', KEYS[2]) == 0 then
+  return {-1}
+end
+if algo == '' then
+  algo = 'token_bucket'
+end
+local kind = algo
+if rps <= 0 or capacity <= 0 then
+  kind = 'token_bucket'
+end
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local allowed, remaining, reset, retry, delay = 0, 0, 0, 0, 0
+
+if kind == 'sliding_log' then
+  local window = capacity / rps
+  local from = '(' .. string.format('%.6f', now - window)
+  local count = redis.call('ZCOUNT', KEYS[2], from, '+inf')
+  if count + n <= capacity then
+    allowed = 1
+    if not peek and n > 0 then
+      redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', string.format('%.6f', now - window))
+      for i = 1, n do
+        redis.call('ZADD', KEYS[2], string.format('%.6f', now), string.format('%.6f:%d', now, count + i))
+      end
+      redis.call('EXPIRE', KEYS[2], math.ceil(window) + 1)
+    end
+    count = count + n
+  end
+  remaining = capacity - count
+  -- k-й по старшинству запрос в окне; в peek и при отказе окно не менялось
+  local function nth(k)
+    local r = redis.call('ZRANGEBYSCORE', KEYS[2], from, '+inf', 'WITHSCORES', 'LIMIT', k - 1, 1)
+    return tonumber(r[2]) or now
+  end
+  if count > 0 then
+    reset = nth(count) + window - now
+  end
+  local k = count + n - capacity
+  if k > 0 and n <= capacity then
+    retry = nth(k) + window - now
+  end
+elseif kind == 'sliding_window' then
+  local window = capacity / rps
+  local st = redis.call('HMGET', KEYS[2], 'start', 'cur', 'prev')
+  local start = tonumber(st[1]) or now
+  local cur = tonumber(st[2]) or 0
+  local prev = tonumber(st[3]) or 0
+  if now - start >= window then
+    local k = math.floor((now - start) / window)
+    if k == 1 then prev = cur else prev = 0 end
+    cur = 0
+    start = start + k * window
+  end
+  local est = prev * (1 - math.max((now - start) / window, 0)) + cur
+  if est + n <= capacity then
+    allowed = 1
+    cur = cur + n
+    est = est + n
+    if not peek then
+      redis.call('HSET', KEYS[2], 'start', tostring(start), 'cur', tostring(cur), 'prev', tostring(prev))
+      redis.call('EXPIRE', KEYS[2], math.ceil(2 * window) + 1)
+    end
+  end
+  remaining = math.floor(capacity - est)
+  local function untilLevel(level)
+    if est <= level or level < 0 then
+      return 0
+    end
+    local at
+    if cur <= level then
+      at = start + (1 - (level - cur) / prev) * window
+    else
+      at = start + window + (1 - level / cur) * window
+    end
+    return math.max(at - now, 0)
+  end
+  reset = untilLevel(0)
+  retry = untilLevel(capacity - n)
+elseif kind == 'gcra' or kind == 'leaky_bucket' then
+  local interval = 1 / rps
+  local tolerance = capacity * interval
+  local tat = tonumber(redis.call('HGET', KEYS[2], 'tat')) or now
+  if tat < now then
+    tat = now
+  end
+  local cost = n * interval
+  if tat + cost - now <= tolerance + 1e-9 then
+    allowed = 1
+    if kind == 'leaky_bucket' then
+      delay = tat - now
+    end
+    tat = tat + cost
+    if not peek and n > 0 then
+      redis.call('HSET', KEYS[2], 'tat', tostring(tat))
+      redis.call('EXPIRE', KEYS[2], math.ceil(tat - now) + 1)
+    end
+  end
+  local debt = tat - now
+  remaining = math.floor((tolerance - debt) / interval + 1e-9)
+  reset = debt
+  if cost <= tolerance then
+    retry = math.max(debt + cost - tolerance, 0)
+  end
+else
+  local st = redis.call('HMGET', KEYS[2], 'tokens', 'ts')
+  local tokens = tonumber(st[1])
+  local ts = tonumber(st[2])
+  if tokens == nil or ts == nil then
+    tokens, ts = capacity, now
+  end
+  if now > ts then
+    tokens = tokens + (now - ts) * rps
+    ts = now
+  end
+  if tokens > capacity then
+    tokens = capacity
+  end
+  if tokens >= n then
+    tokens = tokens - n
+    allowed = 1
+  end
+  remaining = math.floor(tokens)
+  if rps > 0 then
+    reset = (capacity - tokens) / rps
+    if tokens < n and n <= capacity then
+      retry = (n - tokens) / rps
+    end
+  end
+  if not peek then
+    redis.call('HSET', KEYS[2], 'tokens', tostring(tokens), 'ts', tostring(ts))
+    if rps > 0 then
+      redis.call('EXPIRE', KEYS[2], math.ceil((capacity - tokens) / rps) + 1)
+    end
+  end
+end
+return {allowed, math.max(remaining, 0), tostring(capacity), tostring(rps), algo,
+  tostring(reset), tostring(retry), tostring(delay), cfg[4] or ''}
+`)
+
+// refundScript возвращает n единиц в состояние, потраченных consumeScript. Настройки
+// передаются те, с которыми тратилось, — по ним понятен вид состояния.
+// KEYS: состояние. ARGV: n, вместимость, скорость, алгоритм
+var refundScript = redis.NewScript(`
+local n, capacity, rps, kind = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), ARGV[4]
+if rps <= 0 or capacity <= 0 or kind == '' then
+  kind = 'token_bucket'
+end
+if kind == 'sliding_log' then
+  -- самые свежие n запросов: среди них и возвращаемые
+  local last = redis.call('ZRANGE', KEYS[1], -n, -1)
+  if #last > 0 then
+    redis.call('ZREM', KEYS[1], unpack(last))
+  end
+elseif kind == 'sliding_window' then
+  local st = redis.call('HMGET', KEYS[1], 'cur', 'prev')
+  if st[1] then
+    local cur, prev = tonumber(st[1]), tonumber(st[2]) or 0
+    local take = math.min(cur, n)
+    redis.call('HSET', KEYS[1], 'cur', tostring(cur - take), 'prev', tostring(math.max(prev - (n - take), 0)))
+  end
+elseif kind == 'gcra' or kind == 'leaky_bucket' then
+  local tat = tonumber(redis.call('HGET', KEYS[1], 'tat'))
+  local t = redis.call('TIME')
+  local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+  if tat and tat > now then
+    redis.call('HSET', KEYS[1], 'tat', tostring(math.max(tat - n / rps, now)))
+  end
+else
+  local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+  if tokens then
+    redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(tokens + n, capacity)))
+  end
+end
+return 1
+`)
+
+// runConsume выполняет consumeScript и возвращает решение и снимок клиента, cl == nil —
+// peek неизвестного клиента. def — параметры клиента без своих настроек
+func (c *RedisRepository) runConsume(id string, n int, def Limit, peek bool) (d Decision, cl *Client, err error) {
+	flag := 0
+	if peek {
+		flag = 1
+	}
+	vals, err := consumeScript.Run(context.Background(), c.rdb,
+		[]string{c.configKey(id), c.bucketKey(id)}, n, def.Capacity, def.RPS, flag, string(def.Algorithm)).Slice()
+	if err != nil {
+		return d, nil, err
+	}
+	if len(vals) == 1 {
+		return d, nil, nil
+	}
+	if len(vals) != 9 {
+		return d, nil, fmt.Errorf("unexpected script result %v", vals)
+	}
+	str := func(i int) string { return fmt.Sprint(vals[i]) }
+	// секунды от Redis — разность меток около 1.7e9, точнее микросекунд они не бывают
+	dur := func(i int) time.Duration {
+		s, _ := strconv.ParseFloat(str(i), 64)
+		return seconds(s).Round(time.Microsecond)
+	}
+	allowed, _ := vals[0].(int64)
+	remaining, _ := vals[1].(int64)
+	cl = &Client{ID: id, Tenant: str(8), Remaining: int(remaining)}
+	cl.Limit.Capacity, _ = strconv.Atoi(str(2))
+	cl.Limit.RPS, _ = strconv.ParseFloat(str(3), 64)
+	cl.Limit.Algorithm = Algorithm(str(4))
+	d = Decision{
+		Allowed:    allowed == 1,
+		Limit:      cl.Limit.Capacity,
+		Remaining:  int(remaining),
+		Reset:      dur(5),
+		RetryAfter: dur(6),
+		Delay:      dur(7),
+		Tenant:     cl.Tenant,
+	}
+	if d.Allowed && !peek {
+		d.spent = spent{id: id, n: n, limit: cl.Limit}
+	}
+	return d, cl, nil
+}
+
+// RedisOptions — настройки Redis-репозитория
+type RedisOptions struct {
+	// Prefix — префикс ключей, чтобы несколько балансировщиков делили один Redis
+	Prefix   string
+	Fallback Fallback
+	// Retry — сколько после ошибки не обращаться к Redis и сразу работать в режиме Fallback
+	Retry time.Duration
+}
+
+// RedisRepository — репозиторий клиентов в Redis. Реализовывает интерфейс ClientRepo.
+// Настройки клиента лежат в хеше <prefix>client:{id}, состояние алгоритма — в ключе
+// <prefix>bucket:{id} (хеш, у sliding_log — sorted set); фигурные скобки держат оба
+// ключа в одном слоте Redis Cluster.
+// Реплики с общим Redis делят лимиты. Настройки из Add/Update/Delete дублируются
+// в локальный репозиторий, который считает лимиты, пока Redis недоступен. Меняются
+// настройки только через Redis: без него изменение отклоняется с ErrRedisUnavailable,
+// иначе реплика разошлась бы с остальными
+type RedisRepository struct {
+	rdb   redis.UniversalClient
+	opts  RedisOptions
+	local *ClientMemoryRepository
+
+	defaultsMu      sync.RWMutex
+	defaultCapacity int
+	defaultRPS      float64
+	logger          *slog.Logger
+
+	// downUntil — до какого момента (UnixNano) Redis считается недоступным
+	downUntil atomic.Int64
+}
+
+func NewRedisRepo(rdb redis.UniversalClient, defaultCapacity int, defaultRPS float64, opts RedisOptions, logger *slog.Logger) *RedisRepository {
+	if opts.Fallback == "" {
+		opts.Fallback = FallbackLocal
+	}
+	return &RedisRepository{
+		rdb:             rdb,
+		opts:            opts,
+		local:           NewMemoryRepo(defaultCapacity, defaultRPS, logger),
+		defaultCapacity: defaultCapacity,
+		defaultRPS:      defaultRPS,
+		logger:          logger,
+	}
+}
+
+func (c *RedisRepository) configKey(id string) string {
+	return c.opts.Prefix + "client:{" + id + "}"
+}
+
+func (c *RedisRepository) bucketKey(id string) string {
+	return c.opts.Prefix + "bucket:{" + id + "}"
+}
+
+// available сообщает, стоит ли обращаться к Redis
+func (c *RedisRepository) available() bool {
+	return time.Now().UnixNano() >= c.downUntil.Load()
+}
+
+// fail отмечает Redis недоступным на opts.Retry; в лог попадает только переход
+func (c *RedisRepository) fail(op string, err error) {
+	if c.downUntil.Swap(time.Now().Add(c.opts.Retry).UnixNano()) < time.Now().UnixNano() {
+		c.logger.Error("redis unavailable, rate limiting falls back", "op", op, "fallback", c.opts.Fallback, "error", err)
+	}
+}
+
+// Consume тратит n единиц атомарно в Redis. Пока Redis недоступен, решение
+// принимает Fallback; open и closed не знают лимита клиента
+func (c *RedisRepository) Consume(id string, n int) Decision {
+	capacity, rps := c.defaults()
+	return c.ConsumeWith(id, n, Limit{Capacity: capacity, RPS: rps})
+}
+
+func (c *RedisRepository) ConsumeWith(id string, n int, limit Limit) Decision {
+	if c.available() {
+		d, _, err := c.runConsume(id, n, limit, false)
+		if err == nil {
+			return d
+		}
+		c.fail("consume", err)
+	}
+	switch c.opts.Fallback {
+	case FallbackOpen:
+		return Decision{Allowed: true}
+	case FallbackClosed:
+		return Decision{RetryAfter: c.opts.Retry}
+	default:
+		d := c.local.ConsumeWith(id, n, limit)
+		d.spent.local = true
+		return d
+	}
+}
+
+// Refund возвращает потраченное туда, где оно тратилось: в Redis или в локальный
+// репозиторий. Ошибку Redis только отмечает — потерянный возврат лишь строже лимит
+func (c *RedisRepository) Refund(d Decision) {
+	sp := d.spent
+	if !d.Allowed || sp.id == "" || sp.n <= 0 {
+		return
+	}
+	if sp.local {
+		c.local.Refund(d)
+		return
+	}
+	if !c.available() {
+		return
+	}
+	err := refundScript.Run(context.Background(), c.rdb, []string{c.bucketKey(sp.id)},
+		sp.n, sp.limit.Capacity, sp.limit.RPS, string(sp.limit.Algorithm)).Err()
+	if err != nil {
+		c.fail("refund", err)
+	}
+}
+
+// GetClient читает настройки и остаток клиента, ничего не тратя. Клиент без своих
+// настроек, но с начатым состоянием возвращается с параметрами по умолчанию
+func (c *RedisRepository) GetClient(id string) *Client {
+	if !c.available() {
+		return c.local.GetClient(id)
+	}
+	capacity, rps := c.defaults()
+	_, cl, err := c.runConsume(id, 0, Limit{Capacity: capacity, RPS: rps}, true)
+	if err != nil {
+		c.fail("get", err)
+		return c.local.GetClient(id)
+	}
+	c.logger.Debug("client.GetClient", "client id", id)
+	return cl
+}
+
+// AddClient сохраняет настройки и организацию клиента с полным бакетом, прежние
+// настройки стираются
+func (c *RedisRepository) AddClient(id string, limit Limit, tenant string) (*Client, error) {
+	if !c.available() {
+		return nil, ErrRedisUnavailable
+	}
+	if err := c.save(id, limit, true, tenant); err != nil {
+		c.fail("add", err)
+		return nil, fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	cl, _ := c.local.AddClient(id, limit, tenant)
+	c.logger.Debug("Created new client", "id", id, "algorithm", limit.Algorithm, "capacity", limit.Capacity, "rps", limit.RPS, "tenant", tenant)
+	return cl, nil
+}
+
+func (c *RedisRepository) UpdateClient(id string, limit Limit) (*Client, error) {
+	if !c.available() {
+		return nil, ErrRedisUnavailable
+	}
+	ctx := context.Background()
+	n, err := c.rdb.Exists(ctx, c.configKey(id), c.bucketKey(id)).Result()
+	if err != nil {
+		c.fail("update", err)
+		return nil, fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	if n == 0 {
+		return nil, ErrNoClient
+	}
+	if err := c.save(id, limit, false, ""); err != nil {
+		c.fail("update", err)
+		return nil, fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	tenant, _ := c.rdb.HGet(ctx, c.configKey(id), "tenant").Result()
+	c.local.AddClient(id, limit, tenant)
+	c.logger.Debug("UpdateClient", "id", id, "algorithm", limit.Algorithm, "capacity", limit.Capacity, "rps", limit.RPS)
+	cl := NewClient(id, limit)
+	cl.Tenant = tenant
+	return snapshot(cl, time.Now()), nil
+}
+
+// SetTenant записывает организацию в настройки клиента. Клиент без своих настроек
+// получает параметры по умолчанию, его состояние сохраняется
+func (c *RedisRepository) SetTenant(id, tenant string) (*Client, error) {
+	if !c.available() {
+		return nil, ErrRedisUnavailable
+	}
+	ctx := context.Background()
+	n, err := c.rdb.Exists(ctx, c.configKey(id), c.bucketKey(id)).Result()
+	if err != nil {
+		c.fail("set tenant", err)
+		return nil, fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	if n == 0 {
+		return nil, ErrNoClient
+	}
+	capacity, rps := c.defaults()
+	_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, c.configKey(id), "capacity", capacity)
+		pipe.HSetNX(ctx, c.configKey(id), "rps", strconv.FormatFloat(rps, 'g', -1, 64))
+		if tenant == "" {
+			pipe.HDel(ctx, c.configKey(id), "tenant")
+		} else {
+			pipe.HSet(ctx, c.configKey(id), "tenant", tenant)
+		}
+		return nil
+	})
+	if err != nil {
+		c.fail("set tenant", err)
+		return nil, fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	cl := c.GetClient(id)
+	if cl == nil {
+		return nil, ErrNoClient
+	}
+	if _, err := c.local.SetTenant(id, tenant); err != nil {
+		c.local.AddClient(id, cl.Limit, tenant)
+	}
+	c.logger.Debug("SetTenant", "id", id, "tenant", tenant)
+	return cl, nil
+}
+
+// save записывает настройки и сбрасывает состояние: оно начинается пустым, а тип
+// ключа у разных алгоритмов разный. replace стирает и остальные поля настроек и
+// записывает организацию tenant, без replace организация не меняется
+func (c *RedisRepository) save(id string, limit Limit, replace bool, tenant string) error {
+	ctx := context.Background()
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if replace {
+			pipe.Del(ctx, c.configKey(id))
+			if tenant != "" {
+				pipe.HSet(ctx, c.configKey(id), "tenant", tenant)
+			}
+		}
+		pipe.HSet(ctx, c.configKey(id), "capacity", limit.Capacity, "rps", strconv.FormatFloat(limit.RPS, 'g', -1, 64),
+			"algorithm", string(limit.Algorithm))
+		pipe.Del(ctx, c.bucketKey(id))
+		return nil
+	})
+	return err
+}
+
+func (c *RedisRepository) DeleteClient(id string) error {
+	if !c.available() {
+		return ErrRedisUnavailable
+	}
+	n, err := c.rdb.Del(context.Background(), c.configKey(id), c.bucketKey(id)).Result()
+	if err != nil {
+		c.fail("delete", err)
+		return fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	_ = c.local.DeleteClient(id)
+	c.logger.Debug("DeleteClient", "id", id)
+	if n == 0 {
+		return ErrNoClient
+	}
+	return nil
+}
+
+func (c *RedisRepository) defaults() (int, float64) {
+	c.defaultsMu.RLock()
+	defer c.defaultsMu.RUnlock()
+	return c.defaultCapacity, c.defaultRPS
+}
+
+func (c *RedisRepository) DefaultRPS() float64 {
+	_, rps := c.defaults()
+	return rps
+}
+
+func (c *RedisRepository) DefaultCapacity() int {
+	capacity, _ := c.defaults()
+	return capacity
+}
+
+// SetDefaults меняет параметры для клиентов без своих настроек
+func (c *RedisRepository) SetDefaults(capacity int, rps float64) {
+	c.defaultsMu.Lock()
+	c.defaultCapacity = capacity
+	c.defaultRPS = rps
+	c.defaultsMu.Unlock()
+	c.local.SetDefaults(capacity, rps)
+}
+
+// SetEviction и EvictIdle относятся к локальному репозиторию: в Redis бакеты
+// удаляются сами, когда наполняются
+func (c *RedisRepository) SetEviction(idleTTL time.Duration, maxClients int) {
+	c.local.SetEviction(idleTTL, maxClients)
+}
+
+func (c *RedisRepository) EvictIdle() int {
+	return c.local.EvictIdle()
+}
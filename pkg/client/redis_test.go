@@ -0,0 +1,261 @@
+package client_test
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+func newRedisRepo(t *testing.T, mr *miniredis.Miniredis, fallback client.Fallback) *client.RedisRepository {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), DialTimeout: 100 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+	opts := client.RedisOptions{Prefix: "lb:", Fallback: fallback, Retry: time.Minute}
+	return client.NewRedisRepo(rdb, 3, 1, opts, slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+func TestRedisRepoSharesLimitsBetweenReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1_700_000_000, 0))
+	a, b := newRedisRepo(t, mr, client.FallbackLocal), newRedisRepo(t, mr, client.FallbackLocal)
+
+	// вместимость по умолчанию 3 на обе реплики
+	for i, repo := range []*client.RedisRepository{a, b, a} {
+		if !repo.Consume("10.0.0.1", 1).Allowed {
+			t.Fatalf("request %d rejected; want allowed", i)
+		}
+	}
+	if b.Consume("10.0.0.1", 1).Allowed {
+		t.Error("4th request across replicas allowed; want shared limit of 3")
+	}
+
+	// время Redis: через 2 секунды при 1 rps доступно 2 токена
+	mr.SetTime(time.Unix(1_700_000_002, 0))
+	if !a.Consume("10.0.0.1", 2).Allowed || b.Consume("10.0.0.1", 1).Allowed {
+		t.Error("refill by Redis clock: want exactly 2 tokens after 2s")
+	}
+	cl := a.GetClient("10.0.0.1")
+	if cl == nil || cl.Limit.Capacity != 3 {
+		t.Fatalf("auto client = %+v; want defaults", cl)
+	}
+}
+
+func TestRedisRepoClientConfig(t *testing.T) {
+	mr := miniredis.RunT(t)
+	repo := newRedisRepo(t, mr, client.FallbackLocal)
+
+	repo.AddClient("alice", client.Limit{Capacity: 10, RPS: 0.5}, "acme")
+	if got := mr.HGet("lb:client:{alice}", "tenant"); got != "acme" {
+		t.Errorf("tenant in hash = %q; want acme", got)
+	}
+	if got := mr.HGet("lb:client:{alice}", "capacity"); got != "10" {
+		t.Errorf("capacity in hash = %q; want 10", got)
+	}
+	if got := mr.HGet("lb:client:{alice}", "rps"); got != "0.5" {
+		t.Errorf("rps in hash = %q; want 0.5", got)
+	}
+	if !repo.Consume("alice", 10).Allowed || repo.Consume("alice", 1).Allowed {
+		t.Error("configured capacity 10 not applied")
+	}
+
+	// другая реплика видит настройки клиента
+	other := newRedisRepo(t, mr, client.FallbackLocal)
+	cl := other.GetClient("alice")
+	if cl == nil || cl.Limit.Capacity != 10 || cl.Limit.RPS != 0.5 || cl.Remaining != 0 {
+		t.Fatalf("GetClient on other replica = %+v", cl)
+	}
+
+	if _, err := other.UpdateClient("alice", client.Limit{Capacity: 4, RPS: 2}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if !repo.Consume("alice", 4).Allowed {
+		t.Error("update should refill bucket to new capacity")
+	}
+	if _, err := repo.UpdateClient("nobody", client.Limit{Capacity: 1, RPS: 1}); err != client.ErrNoClient {
+		t.Errorf("UpdateClient of unknown client: err = %v; want ErrNoClient", err)
+	}
+
+	if err := repo.DeleteClient("alice"); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	if other.GetClient("alice") != nil {
+		t.Error("client still visible after delete")
+	}
+	if err := repo.DeleteClient("alice"); err != client.ErrNoClient {
+		t.Errorf("second DeleteClient: err = %v; want ErrNoClient", err)
+	}
+}
+
+func TestRedisRepoFallback(t *testing.T) {
+	for _, tc := range []struct {
+		fallback client.Fallback
+		want     []bool
+	}{
+		{client.FallbackOpen, []bool{true, true, true, true}},
+		{client.FallbackClosed, []bool{false, false, false, false}},
+		// локальный лимитер реплики с той же вместимостью 3
+		{client.FallbackLocal, []bool{true, true, true, false}},
+	} {
+		t.Run(string(tc.fallback), func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			repo := newRedisRepo(t, mr, tc.fallback)
+			mr.Close()
+			for i, want := range tc.want {
+				if got := repo.Consume("10.0.0.1", 1).Allowed; got != want {
+					t.Errorf("request %d allowed = %v; want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisRepoRejectsChangesWhileDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	repo := newRedisRepo(t, mr, client.FallbackLocal)
+	if _, err := repo.AddClient("alice", client.Limit{Capacity: 10, RPS: 1}, ""); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	mr.Close()
+
+	// первая ошибка — запись не дошла, следующие — Redis помечен недоступным
+	for i := 0; i < 2; i++ {
+		if _, err := repo.AddClient("bob", client.Limit{Capacity: 5, RPS: 1}, ""); !errors.Is(err, client.ErrRedisUnavailable) {
+			t.Errorf("AddClient: err = %v; want ErrRedisUnavailable", err)
+		}
+	}
+	if _, err := repo.UpdateClient("alice", client.Limit{Capacity: 1, RPS: 1}); !errors.Is(err, client.ErrRedisUnavailable) {
+		t.Errorf("UpdateClient: err = %v; want ErrRedisUnavailable", err)
+	}
+	if _, err := repo.SetTenant("alice", "acme"); !errors.Is(err, client.ErrRedisUnavailable) {
+		t.Errorf("SetTenant: err = %v; want ErrRedisUnavailable", err)
+	}
+	if err := repo.DeleteClient("alice"); !errors.Is(err, client.ErrRedisUnavailable) {
+		t.Errorf("DeleteClient: err = %v; want ErrRedisUnavailable", err)
+	}
+
+	// локальный репозиторий, который считает лимиты без Redis, тоже не изменился
+	if cl := repo.GetClient("alice"); cl == nil || cl.Limit.Capacity != 10 || cl.Tenant != "" {
+		t.Errorf("alice changed while Redis is down: %+v", cl)
+	}
+	if repo.GetClient("bob") != nil {
+		t.Error("bob created while Redis is down")
+	}
+}
+
+func TestParseFallback(t *testing.T) {
+	if f, err := client.ParseFallback(""); err != nil || f != client.FallbackLocal {
+		t.Errorf("ParseFallback(\"\") = %q, %v; want local", f, err)
+	}
+	if _, err := client.ParseFallback("maybe"); err == nil {
+		t.Error("ParseFallback(\"maybe\"): want error")
+	}
+}
+
+func TestRedisRepoAlgorithms(t *testing.T) {
+	for _, algo := range []client.Algorithm{client.AlgorithmSlidingLog, client.AlgorithmSlidingWindow, client.AlgorithmGCRA} {
+		t.Run(string(algo), func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			mr.SetTime(time.Unix(1_700_000_000, 0))
+			a, b := newRedisRepo(t, mr, client.FallbackLocal), newRedisRepo(t, mr, client.FallbackLocal)
+			a.AddClient("alice", client.Limit{Algorithm: algo, Capacity: 2, RPS: 1}, "")
+
+			if !a.Consume("alice", 1).Allowed || !b.Consume("alice", 1).Allowed || a.Consume("alice", 1).Allowed {
+				t.Fatal("want exactly 2 requests shared across replicas")
+			}
+			cl := b.GetClient("alice")
+			if cl == nil || cl.Limit.Algorithm != algo || cl.Remaining != 0 {
+				t.Fatalf("GetClient = %+v; want %s with nothing remaining", cl, algo)
+			}
+			// окно 2/1 = 2 секунды, у GCRA — два интервала по секунде
+			mr.SetTime(time.Unix(1_700_000_005, 0))
+			if cl := a.GetClient("alice"); cl.Remaining != 2 {
+				t.Errorf("remaining after idle = %d; want 2", cl.Remaining)
+			}
+			if !a.Consume("alice", 2).Allowed {
+				t.Error("full limit rejected after idle")
+			}
+		})
+	}
+}
+
+func TestRedisRepoLeakyBucketQueues(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1_700_000_000, 0))
+	repo := newRedisRepo(t, mr, client.FallbackLocal)
+	repo.AddClient("alice", client.Limit{Algorithm: client.AlgorithmLeakyBucket, Capacity: 2, RPS: 20}, "")
+
+	// время Redis стоит, поэтому второй запрос ждёт полный интервал 50ms
+	first, second := repo.Consume("alice", 1), repo.Consume("alice", 1)
+	if !first.Allowed || first.Delay != 0 || !second.Allowed || second.Delay != 50*time.Millisecond {
+		t.Fatalf("queued requests = %+v, %+v; want delays 0 and 50ms", first, second)
+	}
+	d := repo.Consume("alice", 1)
+	if d.Allowed || d.RetryAfter != 50*time.Millisecond || d.Limit != 2 {
+		t.Errorf("request over queue length = %+v; want rejected, retry after 50ms", d)
+	}
+}
+
+func TestRedisRepoDecision(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1_700_000_000, 0))
+	repo := newRedisRepo(t, mr, client.FallbackLocal)
+	repo.AddClient("alice", client.Limit{Capacity: 4, RPS: 2}, "")
+
+	d := repo.Consume("alice", 3)
+	if !d.Allowed || d.Limit != 4 || d.Remaining != 1 || d.Reset != 1500*time.Millisecond || d.RetryAfter != time.Second {
+		t.Errorf("decision = %+v; want allowed, 1 remaining, reset 1.5s, retry after 1s", d)
+	}
+	closed := newRedisRepo(t, mr, client.FallbackClosed)
+	mr.Close()
+	if d := closed.Consume("alice", 1); d.Allowed || d.Limit != 0 || d.RetryAfter != time.Minute {
+		t.Errorf("closed fallback = %+v; want rejected with retry after the Redis backoff", d)
+	}
+}
+
+func TestRedisRepoConsumeWith(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1_700_000_000, 0))
+	repo := newRedisRepo(t, mr, client.FallbackLocal)
+	route := client.Limit{Algorithm: client.AlgorithmSlidingLog, Capacity: 5, RPS: 5}
+
+	if d := repo.ConsumeWith("u1#search", 5, route); !d.Allowed || d.Limit != 5 || d.Remaining != 0 {
+		t.Errorf("route request = %+v; want allowed with limit 5", d)
+	}
+	if repo.ConsumeWith("u1#search", 1, route).Allowed {
+		t.Error("route bucket exhausted; want rejected")
+	}
+	// основной бакет клиента не тронут
+	if d := repo.Consume("u1", 1); !d.Allowed || d.Limit != 3 {
+		t.Errorf("client request = %+v; want allowed with default limit 3", d)
+	}
+}
+
+func TestRedisRepoRefund(t *testing.T) {
+	for _, algo := range []client.Algorithm{client.AlgorithmTokenBucket, client.AlgorithmSlidingLog, client.AlgorithmSlidingWindow, client.AlgorithmGCRA} {
+		t.Run(string(algo), func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			mr.SetTime(time.Unix(1_700_000_000, 0))
+			repo := newRedisRepo(t, mr, client.FallbackLocal)
+			repo.AddClient("alice", client.Limit{Algorithm: algo, Capacity: 2, RPS: 1}, "")
+
+			repo.Consume("alice", 1)
+			repo.Refund(repo.Consume("alice", 1))
+			if cl := repo.GetClient("alice"); cl.Remaining != 1 {
+				t.Errorf("remaining after refund = %d; want 1", cl.Remaining)
+			}
+			// отклонённое решение ничего не возвращает
+			repo.Consume("alice", 1)
+			repo.Refund(repo.Consume("alice", 1))
+			if cl := repo.GetClient("alice"); cl.Remaining != 0 {
+				t.Errorf("remaining after refunding a rejection = %d; want 0", cl.Remaining)
+			}
+		})
+	}
+}
@@ -1,110 +1,332 @@
 package client
 
 import (
+	"container/list"
 	"errors"
+	"expvar"
+	"hash/maphash"
 	"log/slog"
+	"math/bits"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	ErrNoClient = errors.New("Client no found")
 )
 
-// Репозиторий клиентов. Реализовывает интерфейс ClientRepo
+// repoStats — счётчики клиентов, доступны через /debug/vars
+var repoStats = expvar.NewMap("clients")
+
+// entry — клиент в шарде. Клиенты, созданные на первом запросе (auto), живут в LRU
+// шарда и вытесняются по простою или лимиту; созданные через AddClient — нет
+type entry struct {
+	client   Client
+	auto     bool
+	lastSeen time.Time
+	elem     *list.Element
+}
+
+// shard — часть клиентов со своей блокировкой. Паддинг, чтобы блокировки соседних
+// шардов не попадали в одну кеш-линию
+type shard struct {
+	mu      sync.Mutex
+	clients map[string]*entry
+	// lru — auto-клиенты шарда, в начале недавно активные; значения — *entry
+	lru list.List
+	_   [64]byte
+}
+
+// Репозиторий клиентов. Реализовывает интерфейс ClientRepo.
+// Клиенты разложены по шардам по хешу ID, поэтому запросы разных клиентов
+// не ждут одну общую блокировку
 type ClientMemoryRepository struct {
-	clients         map[string]*Client
-	mu              sync.RWMutex
+	shards []shard
+	mask   uint64
+	seed   maphash.Seed
+
+	defaultsMu      sync.RWMutex
 	defaultCapacity int
-	defaultRPS      int
+	defaultRPS      float64
 	logger          *slog.Logger
+
+	// idleTTL — простой, после которого auto-клиент удаляется, 0 — не удалять
+	idleTTL atomic.Int64
+	// shardMax — лимит auto-клиентов на шард, 0 — без лимита
+	shardMax atomic.Int64
+}
+
+// RepoOption настраивает репозиторий при создании
+type RepoOption func(*ClientMemoryRepository)
+
+// WithShards задаёт число шардов, округляется вверх до степени двойки.
+// По умолчанию — 4 × GOMAXPROCS
+func WithShards(n int) RepoOption {
+	return func(c *ClientMemoryRepository) {
+		c.shards = make([]shard, shardCount(n))
+	}
+}
+
+func shardCount(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
 }
 
-func NewMemoryRepo(defaultCapacity, defaultRPS int, logger *slog.Logger) *ClientMemoryRepository {
-	return &ClientMemoryRepository{
-		clients:         make(map[string]*Client),
+func NewMemoryRepo(defaultCapacity int, defaultRPS float64, logger *slog.Logger, opts ...RepoOption) *ClientMemoryRepository {
+	c := &ClientMemoryRepository{
+		seed:            maphash.MakeSeed(),
 		defaultCapacity: defaultCapacity,
 		defaultRPS:      defaultRPS,
 		logger:          logger,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.shards == nil {
+		c.shards = make([]shard, shardCount(4*runtime.GOMAXPROCS(0)))
+	}
+	c.mask = uint64(len(c.shards) - 1)
+	for i := range c.shards {
+		c.shards[i].clients = make(map[string]*entry)
+	}
+	return c
 }
 
-func (c *ClientMemoryRepository) AddClient(id string, capacity, rps int) *Client {
-	client := NewClient(id, capacity, rps)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.clients[id] = client
-	c.logger.Debug("Created new client", "id", client.ID, "capacity", client.TokenBucket.Capacity, "rps", client.TokenBucket.RPS)
-	return client
+func (c *ClientMemoryRepository) shard(id string) *shard {
+	return &c.shards[maphash.String(c.seed, id)&c.mask]
 }
 
+// AddClient создаёт явно настроенного клиента, он не вытесняется. Если клиент уже
+// был создан автоматически, он заменяется
+func (c *ClientMemoryRepository) AddClient(id string, limit Limit, tenant string) (*Client, error) {
+	client := NewClient(id, limit)
+	client.Tenant = tenant
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.clients[id]; ok {
+		s.remove(id, e)
+	}
+	s.clients[id] = &entry{client: *client}
+	c.logger.Debug("Created new client", "id", client.ID, "algorithm", limit.Algorithm, "capacity", limit.Capacity, "rps", limit.RPS, "tenant", tenant)
+	return snapshot(client, time.Now()), nil
+}
+
+// GetClient возвращает снимок клиента: состояние алгоритма меняется под блокировкой шарда
 func (c *ClientMemoryRepository) GetClient(id string) *Client {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	c.logger.Debug("client.GetClient", "client id", id)
-	return c.clients[id]
+	if e, ok := s.clients[id]; ok {
+		return snapshot(&e.client, time.Now())
+	}
+	return nil
+}
+
+// snapshot копирует клиента без состояния алгоритма, вызывается под s.mu
+func snapshot(cl *Client, now time.Time) *Client {
+	return &Client{ID: cl.ID, Limit: cl.Limit, Tenant: cl.Tenant, Remaining: max(cl.limiter.remaining(now), 0)}
 }
 
 func (c *ClientMemoryRepository) DeleteClient(id string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	c.logger.Debug("DeleteClient", "id", id)
-	if _, ok := c.clients[id]; !ok {
+	e, ok := s.clients[id]
+	if !ok {
 		return ErrNoClient
 	}
-	delete(c.clients, id)
+	s.remove(id, e)
 	return nil
 }
 
-func (c *ClientMemoryRepository) UpdateClient(id string, capacity, rps int) (*Client, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	cl, ok := c.clients[id]
+// UpdateClient меняет алгоритм и параметры клиента, состояние начинается заново.
+// Автоматически созданный клиент после этого считается настроенным и больше не вытесняется
+func (c *ClientMemoryRepository) UpdateClient(id string, limit Limit) (*Client, error) {
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.clients[id]
+	if !ok {
+		return nil, ErrNoClient
+	}
+	if e.auto {
+		s.remove(id, e)
+		e = &entry{client: e.client}
+		s.clients[id] = e
+	}
+	now := time.Now()
+	e.client.Limit = limit
+	e.client.limiter = limit.newLimiter(now)
+	c.logger.Debug("UpdateClient", "id", id, "algorithm", limit.Algorithm, "capacity", limit.Capacity, "rps", limit.RPS)
+	return snapshot(&e.client, now), nil
+}
+
+func (c *ClientMemoryRepository) SetTenant(id, tenant string) (*Client, error) {
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.clients[id]
 	if !ok {
 		return nil, ErrNoClient
 	}
-	cl.TokenBucket.Capacity = capacity
-	cl.TokenBucket.CurrentTokens = capacity
-	cl.TokenBucket.RPS = rps
-	c.logger.Debug("UpdateClient", "id", id, "capacity", capacity, "rps", rps)
-	return cl, nil
+	if e.auto {
+		s.remove(id, e)
+		e = &entry{client: e.client}
+		s.clients[id] = e
+	}
+	e.client.Tenant = tenant
+	c.logger.Debug("SetTenant", "id", id, "tenant", tenant)
+	return snapshot(&e.client, time.Now()), nil
+}
+
+// remove удаляет клиента из шарда, вызывается под s.mu
+func (s *shard) remove(id string, e *entry) {
+	delete(s.clients, id)
+	if e.auto {
+		s.lru.Remove(e.elem)
+		repoStats.Add("auto", -1)
+	}
 }
 
-func (c *ClientMemoryRepository) DefaultRPS() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+func (c *ClientMemoryRepository) DefaultRPS() float64 {
+	c.defaultsMu.RLock()
+	defer c.defaultsMu.RUnlock()
 	return c.defaultRPS
 }
 
 func (c *ClientMemoryRepository) DefaultCapacity() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.defaultsMu.RLock()
+	defer c.defaultsMu.RUnlock()
 	return c.defaultCapacity
 }
 
-func (c *ClientMemoryRepository) Replenish() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	for _, cl := range c.clients {
-		cl.TokenBucket.Refill()
+// SetDefaults меняет параметры для новых клиентов, уже созданные клиенты не трогает
+func (c *ClientMemoryRepository) SetDefaults(capacity int, rps float64) {
+	c.defaultsMu.Lock()
+	defer c.defaultsMu.Unlock()
+	c.defaultCapacity = capacity
+	c.defaultRPS = rps
+}
+
+// SetEviction задаёт простой, после которого удаляются auto-клиенты, и их общий
+// лимит (делится поровну между шардами, вытесняются давно неактивные). Нули
+// отключают соответствующее ограничение
+func (c *ClientMemoryRepository) SetEviction(idleTTL time.Duration, maxClients int) {
+	c.idleTTL.Store(int64(idleTTL))
+	perShard := 0
+	if maxClients > 0 {
+		perShard = (maxClients + len(c.shards) - 1) / len(c.shards)
 	}
-	c.logger.Debug("Replenished capacities for all clients")
+	c.shardMax.Store(int64(perShard))
 }
 
-// getOrCreate возвращает существующего или создаёт нового клиента
-func (c *ClientMemoryRepository) getOrCreate(id string) *Client {
-	cl, ok := c.clients[id]
-	if !ok {
-		cl = NewClient(id, c.defaultCapacity, c.defaultRPS)
-		c.clients[id] = cl
+// EvictIdle обходит шарды по одному и удаляет auto-клиентов, простоявших дольше
+// idleTTL. Возвращает число удалённых
+func (c *ClientMemoryRepository) EvictIdle() int {
+	now := time.Now()
+	evicted := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		evicted += c.evictIdle(s, now)
+		s.mu.Unlock()
+	}
+	if evicted > 0 {
+		c.logger.Debug("Evicted idle clients", "count", evicted)
+	}
+	return evicted
+}
+
+// evictIdle удаляет простаивающих auto-клиентов с конца LRU, вызывается под s.mu
+func (c *ClientMemoryRepository) evictIdle(s *shard, now time.Time) int {
+	ttl := time.Duration(c.idleTTL.Load())
+	if ttl <= 0 {
+		return 0
 	}
-	return cl
+	evicted := 0
+	for back := s.lru.Back(); back != nil; back = s.lru.Back() {
+		e := back.Value.(*entry)
+		if now.Sub(e.lastSeen) < ttl {
+			break
+		}
+		s.remove(e.client.ID, e)
+		evicted++
+	}
+	if evicted > 0 {
+		repoStats.Add("evicted_idle", int64(evicted))
+	}
+	return evicted
+}
+
+// getOrCreate возвращает существующего или создаёт нового клиента, вызывается под s.mu.
+// Заодно освобождает шард от простаивающих и лишних auto-клиентов
+func (c *ClientMemoryRepository) getOrCreate(s *shard, id string, limit Limit, now time.Time) *Client {
+	e, ok := s.clients[id]
+	if ok {
+		if e.auto {
+			e.lastSeen = now
+			s.lru.MoveToFront(e.elem)
+		}
+		c.evictIdle(s, now)
+		return &e.client
+	}
+
+	c.evictIdle(s, now)
+	e = &entry{client: *NewClient(id, limit), auto: true, lastSeen: now}
+	e.elem = s.lru.PushFront(e)
+	s.clients[id] = e
+	repoStats.Add("auto", 1)
+	if limit := int(c.shardMax.Load()); limit > 0 {
+		for s.lru.Len() > limit {
+			old := s.lru.Back().Value.(*entry)
+			s.remove(old.client.ID, old)
+			repoStats.Add("evicted_lru", 1)
+		}
+	}
+	return &e.client
 }
 
-// Consume — пытаемся потратить токены, если клиента нет, то создаст нового с дефолтными параметрами
-func (c *ClientMemoryRepository) Consume(id string, n int) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// Consume — пытаемся потратить токены, если клиента нет, то создаст нового с дефолтными параметрами.
+// Токены начисляются здесь же по времени с прошлого запроса клиента, стоимость не зависит
+// от числа клиентов
+func (c *ClientMemoryRepository) Consume(id string, n int) Decision {
+	c.defaultsMu.RLock()
+	limit := Limit{Capacity: c.defaultCapacity, RPS: c.defaultRPS}
+	c.defaultsMu.RUnlock()
+	return c.ConsumeWith(id, n, limit)
+}
+
+func (c *ClientMemoryRepository) ConsumeWith(id string, n int, limit Limit) Decision {
+	now := time.Now()
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	cl := c.getOrCreate(id)
-	return cl.TokenBucket.Allow(n)
+	cl := c.getOrCreate(s, id, limit, now)
+	d := cl.limiter.reserve(n, now)
+	d.Tenant = cl.Tenant
+	if d.Allowed {
+		d.spent = spent{id: id, n: n, limit: cl.Limit}
+	}
+	return d
+}
+
+// Refund возвращает токены в бакет клиента; удалённому клиенту возвращать нечего
+func (c *ClientMemoryRepository) Refund(d Decision) {
+	if !d.Allowed || d.spent.id == "" || d.spent.n <= 0 {
+		return
+	}
+	s := c.shard(d.spent.id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.clients[d.spent.id]; ok {
+		e.client.limiter.refund(d.spent.n, time.Now())
+	}
 }
@@ -0,0 +1,140 @@
+package client_test
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+func TestConsumeConcurrentClients(t *testing.T) {
+	repo := client.NewMemoryRepo(100, 0, slog.New(slog.NewTextHandler(io.Discard, nil)), client.WithShards(8))
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				// каждая горутина тратит токены 10 общих клиентов
+				if repo.Consume(fmt.Sprintf("c%d", (g+i)%10), 1).Allowed {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// без пополнения каждый из 10 клиентов пропускает ровно capacity запросов
+	if got := allowed.Load(); got != 1000 {
+		t.Errorf("allowed %d requests; want 1000", got)
+	}
+}
+
+func clientsStat(name string) int64 {
+	if v, ok := expvar.Get("clients").(*expvar.Map).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestEvictIdleKeepsConfiguredClients(t *testing.T) {
+	repo := setupRepo()
+	repo.SetEviction(20*time.Millisecond, 0)
+	repo.AddClient("configured", client.Limit{Capacity: 10, RPS: 1}, "")
+	repo.Consume("10.0.0.1", 1)
+	repo.Consume("10.0.0.2", 1)
+	// клиент, которому выставили лимит через PUT, тоже становится настроенным
+	if _, err := repo.UpdateClient("10.0.0.2", client.Limit{Capacity: 5, RPS: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := clientsStat("evicted_idle")
+
+	time.Sleep(30 * time.Millisecond)
+	if n := repo.EvictIdle(); n != 1 {
+		t.Errorf("evicted %d clients; want 1", n)
+	}
+	if repo.GetClient("10.0.0.1") != nil {
+		t.Error("idle auto-created client should be evicted")
+	}
+	if repo.GetClient("configured") == nil || repo.GetClient("10.0.0.2") == nil {
+		t.Error("configured clients should be kept")
+	}
+	if got := clientsStat("evicted_idle") - before; got != 1 {
+		t.Errorf("evicted_idle grew by %d; want 1", got)
+	}
+}
+
+func TestMaxClientsEvictsLeastRecentlyUsed(t *testing.T) {
+	repo := client.NewMemoryRepo(10, 1, slog.New(slog.NewTextHandler(io.Discard, nil)), client.WithShards(1))
+	repo.SetEviction(0, 3)
+	repo.AddClient("configured", client.Limit{Capacity: 10, RPS: 1}, "")
+	before := clientsStat("evicted_lru")
+
+	for _, id := range []string{"a", "b", "c", "a", "d"} {
+		repo.Consume(id, 1)
+	}
+	// b — самый давно активный из auto-клиентов, вытеснен при появлении d
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true, "configured": true} {
+		if got := repo.GetClient(id) != nil; got != want {
+			t.Errorf("client %q present = %v; want %v", id, got, want)
+		}
+	}
+	if got := clientsStat("evicted_lru") - before; got != 1 {
+		t.Errorf("evicted_lru grew by %d; want 1", got)
+	}
+}
+
+// BenchmarkConsumeParallel сравнивает одну общую блокировку (shards=1, как было
+// до шардирования) с шардированным репозиторием на множестве клиентов:
+//
+//	go test -bench ConsumeParallel -cpu 1,4,16 ./pkg/client
+func BenchmarkConsumeParallel(b *testing.B) {
+	ids := make([]string, 4096)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, shards := range []int{1, 0} {
+		name := fmt.Sprintf("shards=%d", shards)
+		opts := []client.RepoOption{client.WithShards(shards)}
+		if shards == 0 {
+			name, opts = "shards=default", nil
+		}
+		b.Run(name, func(b *testing.B) {
+			repo := client.NewMemoryRepo(1<<30, 1e9, logger, opts...)
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(7919))
+				for pb.Next() {
+					repo.Consume(ids[i%len(ids)], 1)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestConsumeWithUsesLimitForNewClients(t *testing.T) {
+	repo := setupRepo()
+	repo.AddClient("configured", client.Limit{Capacity: 1, RPS: 1}, "")
+	route := client.Limit{Capacity: 20, RPS: 1}
+
+	// новый клиент получает лимит маршрута, а не значения по умолчанию
+	if d := repo.ConsumeWith("u1#upload", 10, route); !d.Allowed || d.Limit != 20 || d.Remaining != 10 {
+		t.Errorf("first request = %+v; want allowed with 10 of 20 left", d)
+	}
+	if !repo.ConsumeWith("u1#upload", 10, route).Allowed || repo.ConsumeWith("u1#upload", 1, route).Allowed {
+		t.Error("route bucket should allow exactly 20 tokens")
+	}
+	// настроенный клиент сохраняет свой лимит
+	if d := repo.ConsumeWith("configured", 1, route); d.Limit != 1 {
+		t.Errorf("configured client limit = %d; want 1", d.Limit)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import "sync"
+
+// globalID — ID общего бакета в репозитории Hierarchy.Global
+const globalID = "global"
+
+// Hierarchy — лимиты над клиентом: его организации (tenant) и общий на весь
+// балансировщик. Организации и общий бакет лежат в своих репозиториях, поэтому
+// их ID не пересекаются с ID клиентов
+type Hierarchy struct {
+	// Tenants — лимиты организаций, заводятся через /tenants. Организация без своих
+	// настроек (например, удалённая) получает параметры по умолчанию репозитория
+	Tenants ClientRepo
+	Global  ClientRepo
+
+	mu     sync.RWMutex
+	global *Limit
+}
+
+func NewHierarchy(tenants, global ClientRepo) *Hierarchy {
+	return &Hierarchy{Tenants: tenants, Global: global}
+}
+
+// SetGlobal задаёт общий лимит, nil — без него. Изменённый лимит начинается с
+// полного бакета, тот же самый (перечитанный конфиг, другая реплика) не сбрасывается
+func (h *Hierarchy) SetGlobal(limit *Limit) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.global = limit
+	if limit == nil {
+		h.Global.DeleteClient(globalID)
+		return
+	}
+	if cl := h.Global.GetClient(globalID); cl == nil || cl.Limit != *limit {
+		h.Global.AddClient(globalID, *limit, "")
+	}
+}
+
+// ConsumeParents тратит n единиц у организации tenant (если она задана), затем у
+// общего бакета. Если общий отказал, потраченное организацией возвращается; запрос
+// проходит, только если прошёл оба уровня
+func (h *Hierarchy) ConsumeParents(tenant string, n int) Decision {
+	d := Decision{Allowed: true}
+	if tenant != "" {
+		if d = h.Tenants.Consume(tenant, n); !d.Allowed {
+			return d
+		}
+	}
+	h.mu.RLock()
+	global := h.global
+	h.mu.RUnlock()
+	if global == nil {
+		return d
+	}
+	// лимит передаётся и здесь: бакет мог пропасть вместе с данными Redis
+	g := h.Global.ConsumeWith(globalID, n, *global)
+	if !g.Allowed {
+		h.Tenants.Refund(d)
+		return g
+	}
+	return d.And(g)
+}
@@ -0,0 +1,164 @@
+package client_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+func TestSetTenant(t *testing.T) {
+	repo := setupRepo()
+	if _, err := repo.SetTenant("nobody", "acme"); err != client.ErrNoClient {
+		t.Errorf("SetTenant of unknown client: err = %v; want ErrNoClient", err)
+	}
+	repo.AddClient("app-1", client.Limit{Capacity: 5, RPS: 1}, "")
+	cl, err := repo.SetTenant("app-1", "acme")
+	if err != nil || cl.Tenant != "acme" || cl.Limit.Capacity != 5 {
+		t.Fatalf("SetTenant = %+v, %v; want tenant acme with capacity 5", cl, err)
+	}
+	// смена лимита организацию не снимает, Consume сообщает её
+	if _, err := repo.UpdateClient("app-1", client.Limit{Capacity: 7, RPS: 1}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if d := repo.Consume("app-1", 1); d.Tenant != "acme" {
+		t.Errorf("decision tenant = %q; want acme", d.Tenant)
+	}
+	// AddClient заменяет клиента целиком
+	repo.AddClient("app-1", client.Limit{Capacity: 7, RPS: 1}, "")
+	if cl := repo.GetClient("app-1"); cl.Tenant != "" {
+		t.Errorf("tenant after AddClient = %q; want none", cl.Tenant)
+	}
+}
+
+func TestHierarchyConsumeParents(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := client.NewHierarchy(client.NewMemoryRepo(100, 0.001, logger), client.NewMemoryRepo(1, 1, logger))
+	h.Tenants.AddClient("acme", client.Limit{Capacity: 3, RPS: 0.001}, "")
+
+	// без организации и общего лимита проверять нечего
+	if d := h.ConsumeParents("", 1); !d.Allowed || d.Limit != 0 {
+		t.Errorf("no parents = %+v; want allowed without limit", d)
+	}
+	for i := range 3 {
+		if d := h.ConsumeParents("acme", 1); !d.Allowed || d.Limit != 3 || d.Remaining != 2-i {
+			t.Fatalf("request %d = %+v; want allowed by tenant limit 3", i, d)
+		}
+	}
+	if h.ConsumeParents("acme", 1).Allowed {
+		t.Error("4th request of tenant allowed; want tenant limit of 3")
+	}
+
+	h.SetGlobal(&client.Limit{Algorithm: client.AlgorithmTokenBucket, Capacity: 2, RPS: 0.001})
+	if d := h.ConsumeParents("other", 1); !d.Allowed || d.Limit != 2 || d.Remaining != 1 {
+		t.Errorf("first global request = %+v; want global limit 2 with 1 left", d)
+	}
+	// тот же лимит при перечитывании конфига не сбрасывает бакет
+	h.SetGlobal(&client.Limit{Algorithm: client.AlgorithmTokenBucket, Capacity: 2, RPS: 0.001})
+	h.ConsumeParents("", 1)
+	if h.ConsumeParents("", 1).Allowed {
+		t.Error("global limit exceeded; want rejected")
+	}
+	h.SetGlobal(nil)
+	if !h.ConsumeParents("", 1).Allowed {
+		t.Error("request rejected after global limit removed")
+	}
+}
+
+func TestHierarchyRefundsTenantOnGlobalReject(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := client.NewHierarchy(client.NewMemoryRepo(100, 0.001, logger), client.NewMemoryRepo(1, 1, logger))
+	h.Tenants.AddClient("acme", client.Limit{Capacity: 3, RPS: 0.001}, "")
+	h.SetGlobal(&client.Limit{Capacity: 1, RPS: 0.001})
+
+	h.ConsumeParents("other", 1)
+	if d := h.ConsumeParents("acme", 1); d.Allowed || d.Limit != 1 {
+		t.Fatalf("request over global limit = %+v; want rejected by global limit 1", d)
+	}
+	if tn := h.Tenants.GetClient("acme"); tn.Remaining != 3 {
+		t.Errorf("tenant remaining = %d; want 3 after global rejection", tn.Remaining)
+	}
+}
+
+func TestDecisionAnd(t *testing.T) {
+	allowed := client.Decision{Allowed: true, Limit: 10, Remaining: 5, Delay: time.Second}
+	tight := client.Decision{Allowed: true, Limit: 100, Remaining: 1}
+	rejected := client.Decision{Limit: 3, RetryAfter: time.Minute}
+
+	if d := allowed.And(tight); !d.Allowed || d.Limit != 100 || d.Remaining != 1 || d.Delay != time.Second {
+		t.Errorf("allowed.And(tight) = %+v; want tight level with delay kept", d)
+	}
+	if d := allowed.And(rejected); d != rejected {
+		t.Errorf("allowed.And(rejected) = %+v; want rejected", d)
+	}
+	// уровень без известного лимита (fallback open) заголовки не задаёт
+	if d := allowed.And(client.Decision{Allowed: true}); d != allowed {
+		t.Errorf("allowed.And(unknown) = %+v; want allowed", d)
+	}
+}
+
+func TestBoltRepoPersistsTenant(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	path := filepath.Join(t.TempDir(), "clients.db")
+	// один открытый файл на оба репозитория
+	store := openBoltStore(t, path)
+	clients, err := client.NewBoltRepo(store, "clients", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("NewBoltRepo: %v", err)
+	}
+	tenants, err := client.NewBoltRepo(store, "tenants", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("NewBoltRepo: %v", err)
+	}
+	tenants.AddClient("acme", client.Limit{Capacity: 50, RPS: 5}, "")
+	clients.AddClient("app-1", client.Limit{Capacity: 5, RPS: 1}, "")
+	if _, err := clients.SetTenant("app-1", "acme"); err != nil {
+		t.Fatalf("SetTenant: %v", err)
+	}
+
+	store.Close()
+	restarted, err := client.NewBoltRepo(openBoltStore(t, path), "clients", 3, 1, logger)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if cl := restarted.GetClient("app-1"); cl == nil || cl.Tenant != "acme" {
+		t.Errorf("app-1 after restart = %+v; want tenant acme", cl)
+	}
+	// организации лежат в своём бакете того же файла
+	if restarted.GetClient("acme") != nil {
+		t.Error("tenant loaded as a client")
+	}
+}
+
+func TestRedisRepoSetTenant(t *testing.T) {
+	mr := miniredis.RunT(t)
+	repo := newRedisRepo(t, mr, client.FallbackLocal)
+
+	// клиент без своих настроек, но с начатым бакетом
+	repo.Consume("app-1", 1)
+	cl, err := repo.SetTenant("app-1", "acme")
+	if err != nil || cl.Tenant != "acme" || cl.Limit.Capacity != 3 || cl.Remaining != 2 {
+		t.Fatalf("SetTenant = %+v, %v; want tenant acme, default capacity 3, 2 left", cl, err)
+	}
+	if d := repo.Consume("app-1", 1); d.Tenant != "acme" {
+		t.Errorf("decision tenant = %q; want acme", d.Tenant)
+	}
+	if _, err := repo.UpdateClient("app-1", client.Limit{Capacity: 10, RPS: 1}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	if cl := repo.GetClient("app-1"); cl.Tenant != "acme" {
+		t.Errorf("tenant after UpdateClient = %q; want acme", cl.Tenant)
+	}
+	repo.AddClient("app-1", client.Limit{Capacity: 10, RPS: 1}, "")
+	if cl := repo.GetClient("app-1"); cl.Tenant != "" {
+		t.Errorf("tenant after AddClient = %q; want none", cl.Tenant)
+	}
+	if _, err := repo.SetTenant("nobody", "acme"); err != client.ErrNoClient {
+		t.Errorf("SetTenant of unknown client: err = %v; want ErrNoClient", err)
+	}
+}
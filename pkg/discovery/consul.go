@@ -0,0 +1,206 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulOptions — настройки обнаружения через Consul
+type ConsulOptions struct {
+	Token string
+	// Wait — сколько Consul держит блокирующий запрос без изменений
+	Wait time.Duration
+	// Retry — пауза после ошибки запроса
+	Retry time.Duration
+}
+
+func isConsul(raw string) bool {
+	return strings.HasPrefix(raw, "consul://") || strings.HasPrefix(raw, "consul+https://")
+}
+
+// Consul следит за здоровыми экземплярами сервиса через health API блокирующими
+// запросами. Теги сервиса попадают в метаданные: тег key=value — как key, все теги
+// через запятую — как tags; Service.Meta копируется как есть
+type Consul struct {
+	raw     string
+	base    string
+	service string
+	query   url.Values
+	client  *http.Client
+	opts    ConsulOptions
+	log     *slog.Logger
+}
+
+// NewConsul разбирает цель вида consul://consul.internal:8500/api?dc=dc1&tag=v2
+func NewConsul(raw string, opts ConsulOptions, log *slog.Logger) (*Consul, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidTarget, raw, err)
+	}
+	service := strings.Trim(u.Path, "/")
+	if u.Host == "" || service == "" || strings.Contains(service, "/") {
+		return nil, fmt.Errorf("%w %q: want consul://host:port/service", ErrInvalidTarget, raw)
+	}
+	scheme := "http"
+	if u.Scheme == "consul+https" {
+		scheme = "https"
+	}
+	query := url.Values{"passing": {"1"}}
+	for _, key := range []string{"dc", "tag", "near"} {
+		if v := u.Query().Get(key); v != "" {
+			query.Set(key, v)
+		}
+	}
+	return &Consul{
+		raw:     raw,
+		base:    scheme + "://" + u.Host,
+		service: service,
+		query:   query,
+		// блокирующий запрос Consul держит до wait плюс случайная добавка до wait/16
+		client: &http.Client{Timeout: opts.Wait + opts.Wait/16 + 10*time.Second},
+		opts:   opts,
+		log:    log,
+	}, nil
+}
+
+func (c *Consul) String() string {
+	return c.raw
+}
+
+func (c *Consul) Watch(ctx context.Context, events chan<- []Event) error {
+	cur, index, err := c.fetch(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if !emit(ctx, events, Diff(nil, cur)) {
+		return nil
+	}
+	for ctx.Err() == nil {
+		next, idx, err := c.fetch(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			c.log.Error("consul discovery failed, keeping previous backends", "target", c.raw, "error", err)
+			if !sleep(ctx, c.opts.Retry) {
+				return nil
+			}
+			continue
+		}
+		// индекс, ушедший назад (например, после перезапуска Consul), сбрасываем
+		if idx < index {
+			idx = 0
+		}
+		index = idx
+		if batch := Diff(cur, next); len(batch) > 0 {
+			if !emit(ctx, events, batch) {
+				return nil
+			}
+			cur = next
+		}
+	}
+	return nil
+}
+
+// consulEntry — элемент ответа /v1/health/service
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// fetch выполняет блокирующий запрос: Consul отвечает при изменении или по истечении wait
+func (c *Consul) fetch(ctx context.Context, index uint64) ([]Instance, uint64, error) {
+	q := maps.Clone(c.query)
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", fmt.Sprintf("%ds", int(c.opts.Wait.Seconds())))
+	}
+	endpoint := c.base + "/v1/health/service/" + url.PathEscape(c.service) + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.opts.Token != "" {
+		req.Header.Set("X-Consul-Token", c.opts.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrPoll, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("%w: %s: status %d", ErrPoll, endpoint, resp.StatusCode)
+	}
+	newIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s: bad X-Consul-Index", ErrPoll, endpoint)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxListBytes)).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("%w: %s: %w", ErrInvalidInstance, endpoint, err)
+	}
+	instances := make([]Instance, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		in := Instance{
+			URL:      "http://" + net.JoinHostPort(addr, strconv.Itoa(e.Service.Port)),
+			Weight:   min(max(e.Service.Weights.Passing, 1), MaxWeight),
+			Metadata: tagsMetadata(e.Service.Tags, e.Service.Meta),
+		}
+		if seen[in.URL] {
+			continue
+		}
+		seen[in.URL] = true
+		instances = append(instances, in)
+	}
+	instances, err = normalize(instances)
+	if err != nil {
+		return nil, 0, err
+	}
+	return instances, newIndex, nil
+}
+
+// tagsMetadata переводит теги и Service.Meta в метаданные экземпляра
+func tagsMetadata(tags []string, meta map[string]string) map[string]string {
+	if len(tags) == 0 && len(meta) == 0 {
+		return nil
+	}
+	md := maps.Clone(meta)
+	if md == nil {
+		md = make(map[string]string, len(tags)+1)
+	}
+	for _, tag := range tags {
+		if k, v, ok := strings.Cut(tag, "="); ok {
+			md[k] = v
+		}
+	}
+	if len(tags) > 0 {
+		md["tags"] = strings.Join(tags, ",")
+	}
+	return md
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul отдаёт /v1/health/service/api и держит блокирующий запрос до смены индекса
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   uint64
+	entries []map[string]any
+	changed chan struct{}
+	token   string
+}
+
+func (f *fakeConsul) set(entries ...map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.entries = entries
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/health/service/api" || r.URL.Query().Get("passing") == "" {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	f.token = r.Header.Get("X-Consul-Token")
+	wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if wait > 0 && wait == f.index {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		f.mu.Lock()
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(f.entries)
+	f.mu.Unlock()
+}
+
+func consulEntryJSON(addr string, port, weight int, tags ...string) map[string]any {
+	return map[string]any{
+		"Node": map[string]any{"Address": "10.9.9.9"},
+		"Service": map[string]any{
+			"Address": addr,
+			"Port":    port,
+			"Tags":    tags,
+			"Meta":    map[string]string{"version": "1.2"},
+			"Weights": map[string]int{"Passing": weight, "Warning": 1},
+		},
+	}
+}
+
+func TestConsul_BlockingQueries(t *testing.T) {
+	fake := &fakeConsul{changed: make(chan struct{})}
+	fake.set(consulEntryJSON("10.0.0.1", 8080, 3, "zone=a", "canary"), consulEntryJSON("", 8081, 0))
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	c, err := NewConsul("consul://"+srv.Listener.Addr().String()+"/api?dc=dc1", ConsulOptions{Token: "secret", Wait: time.Second, Retry: 10 * time.Millisecond}, testLogger)
+	if err != nil {
+		t.Fatalf("NewConsul: %v", err)
+	}
+	col := watch(t, c)
+	// без Service.Address берётся адрес узла
+	col.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 3}, Instance{URL: "http://10.9.9.9:8081", Weight: 1})
+	col.mu.Lock()
+	md := col.state["http://10.0.0.1:8080"].Metadata
+	col.mu.Unlock()
+	if md["zone"] != "a" || md["tags"] != "zone=a,canary" || md["version"] != "1.2" {
+		t.Errorf("metadata = %v; want zone, tags and service meta", md)
+	}
+
+	fake.set(consulEntryJSON("10.0.0.2", 8080, 1))
+	col.waitFor(t, Instance{URL: "http://10.0.0.2:8080", Weight: 1})
+	fake.mu.Lock()
+	token := fake.token
+	fake.mu.Unlock()
+	if token != "secret" {
+		t.Errorf("X-Consul-Token = %q; want secret", token)
+	}
+}
+
+func TestConsul_InvalidTargets(t *testing.T) {
+	for _, raw := range []string{"consul:///api", "consul://consul:8500/", "consul://consul:8500/a/b"} {
+		if _, err := NewConsul(raw, ConsulOptions{}, testLogger); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("NewConsul(%q): err = %v; want ErrInvalidTarget", raw, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxWeight — верхняя граница веса экземпляра
+const MaxWeight = 100
+
+var ErrInvalidInstance = errors.New("invalid instance")
+
+// Instance — экземпляр сервиса: адрес бекенда, вес и произвольные метаданные
+type Instance struct {
+	URL string `json:"url" yaml:"url"`
+	// Weight — доля трафика относительно соседей по пулу, 1..MaxWeight. 0 — вес по умолчанию 1
+	Weight   int               `json:"weight,omitempty" yaml:"weight"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata"`
+	// Draining — экземпляр завершается: доработает текущие запросы, новых не получит
+	Draining bool `json:"draining,omitempty" yaml:"draining"`
+}
+
+// EventType — вид изменения состава
+type EventType string
+
+const (
+	EventAdd    EventType = "add"
+	EventRemove EventType = "remove"
+	// EventUpdate — у экземпляра с тем же URL изменились вес, метаданные или drain
+	EventUpdate EventType = "update"
+)
+
+// Event — изменение одного экземпляра
+type Event struct {
+	Type     EventType
+	Instance Instance
+}
+
+// Discovery — источник состава пула
+type Discovery interface {
+	// String возвращает источник в том виде, в каком он задан в конфиге
+	String() string
+	// Watch отправляет в events пачки изменений, пока не отменён ctx. Первая пачка —
+	// полный текущий состав (может быть пустой). Если его не удалось получить, Watch
+	// сразу возвращает ошибку; дальнейшие ошибки провайдер логирует и сохраняет
+	// последний известный состав
+	Watch(ctx context.Context, events chan<- []Event) error
+}
+
+// Options — настройки провайдеров обнаружения
+type Options struct {
+	DNS        DNSOptions
+	HTTP       HTTPOptions
+	File       FileOptions
+	Consul     ConsulOptions
+	Etcd       EtcdOptions
+	Kubernetes KubernetesOptions
+}
+
+// IsDynamic сообщает, задан ли бекенд источником обнаружения, а не адресом:
+// dns+srv://, dns+a://, file://, poll+http(s)://, consul://, etcd:// или k8s://
+func IsDynamic(raw string) bool {
+	return IsDNS(raw) || isFile(raw) || isHTTP(raw) || isConsul(raw) || isEtcd(raw) || isKubernetes(raw)
+}
+
+// HasDynamic сообщает, есть ли среди бекендов источники обнаружения
+func HasDynamic(entries []string) bool {
+	return slices.ContainsFunc(entries, IsDynamic)
+}
+
+// New создаёт провайдер по записи бекенда
+func New(raw string, opts Options, log *slog.Logger) (Discovery, error) {
+	switch {
+	case IsDNS(raw):
+		return NewDNS(raw, opts.DNS, log)
+	case isFile(raw):
+		return NewFile(raw, opts.File, log)
+	case isHTTP(raw):
+		return NewHTTP(raw, opts.HTTP, log)
+	case isConsul(raw):
+		return NewConsul(raw, opts.Consul, log)
+	case isEtcd(raw):
+		return NewEtcd(raw, opts.Etcd, log)
+	case isKubernetes(raw):
+		return NewKubernetes(raw, opts.Kubernetes, log)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrInvalidTarget, raw)
+	}
+}
+
+// Static — неизменный состав из адресов конфига
+type Static struct {
+	instances []Instance
+}
+
+func NewStatic(urls []string) *Static {
+	s := &Static{instances: make([]Instance, 0, len(urls))}
+	for _, u := range urls {
+		s.instances = append(s.instances, Instance{URL: u, Weight: 1})
+	}
+	return s
+}
+
+func (s *Static) String() string {
+	return "static"
+}
+
+func (s *Static) Watch(ctx context.Context, events chan<- []Event) error {
+	emit(ctx, events, Diff(nil, s.instances))
+	<-ctx.Done()
+	return nil
+}
+
+// normalize проверяет экземпляры из внешнего источника и приводит веса к умолчаниям
+func normalize(instances []Instance) ([]Instance, error) {
+	out := make([]Instance, 0, len(instances))
+	seen := make(map[string]bool, len(instances))
+	for _, in := range instances {
+		u, err := url.Parse(in.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%w: bad url %q", ErrInvalidInstance, in.URL)
+		}
+		if in.Weight < 0 || in.Weight > MaxWeight {
+			return nil, fmt.Errorf("%w: %s: weight %d out of range 0..%d", ErrInvalidInstance, in.URL, in.Weight, MaxWeight)
+		}
+		if seen[in.URL] {
+			return nil, fmt.Errorf("%w: duplicate %q", ErrInvalidInstance, in.URL)
+		}
+		seen[in.URL] = true
+		if in.Weight == 0 {
+			in.Weight = 1
+		}
+		out = append(out, in)
+	}
+	slices.SortFunc(out, func(a, b Instance) int { return strings.Compare(a.URL, b.URL) })
+	return out, nil
+}
+
+// Diff возвращает события, переводящие состав prev в next
+func Diff(prev, next []Instance) []Event {
+	old := make(map[string]Instance, len(prev))
+	for _, in := range prev {
+		old[in.URL] = in
+	}
+	events := make([]Event, 0)
+	for _, in := range next {
+		was, ok := old[in.URL]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: EventAdd, Instance: in})
+		case was.Weight != in.Weight || was.Draining != in.Draining || !maps.Equal(was.Metadata, in.Metadata):
+			events = append(events, Event{Type: EventUpdate, Instance: in})
+		}
+		delete(old, in.URL)
+	}
+	for _, in := range prev {
+		if _, gone := old[in.URL]; gone {
+			events = append(events, Event{Type: EventRemove, Instance: in})
+		}
+	}
+	return events
+}
+
+// emit отправляет пачку, если ctx ещё не отменён
+func emit(ctx context.Context, events chan<- []Event, batch []Event) bool {
+	select {
+	case events <- batch:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep ждёт d или отмены ctx
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,261 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	prev := []Instance{
+		{URL: "http://a:80", Weight: 1},
+		{URL: "http://b:80", Weight: 1, Metadata: map[string]string{"zone": "a"}},
+		{URL: "http://c:80", Weight: 1},
+	}
+	next := []Instance{
+		{URL: "http://a:80", Weight: 1, Draining: true},
+		{URL: "http://b:80", Weight: 1, Metadata: map[string]string{"zone": "b"}},
+		{URL: "http://d:80", Weight: 3},
+	}
+	got := map[string]EventType{}
+	for _, ev := range Diff(prev, next) {
+		got[ev.Instance.URL] = ev.Type
+	}
+	want := map[string]EventType{"http://a:80": EventUpdate, "http://b:80": EventUpdate, "http://c:80": EventRemove, "http://d:80": EventAdd}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Diff = %v; want %v", got, want)
+	}
+}
+
+// collect читает пачки событий провайдера и применяет их к составу
+type collect struct {
+	mu    sync.Mutex
+	state map[string]Instance
+	errc  chan error
+}
+
+func watch(t *testing.T, d Discovery) *collect {
+	t.Helper()
+	c := &collect{state: make(map[string]Instance), errc: make(chan error, 1)}
+	events := make(chan []Event)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { c.errc <- d.Watch(ctx, events) }()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case batch := <-events:
+				c.mu.Lock()
+				for _, ev := range batch {
+					if ev.Type == EventRemove {
+						delete(c.state, ev.Instance.URL)
+					} else {
+						c.state[ev.Instance.URL] = ev.Instance
+					}
+				}
+				c.mu.Unlock()
+			}
+		}
+	}()
+	return c
+}
+
+// waitFor ждёт, пока состав не совпадёт с want (URL и веса)
+func (c *collect) waitFor(t *testing.T, want ...Instance) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mu.Lock()
+		ok := len(c.state) == len(want)
+		for _, in := range want {
+			if got, found := c.state[in.URL]; !found || got.Weight != in.Weight {
+				ok = false
+			}
+		}
+		state := fmt.Sprint(c.state)
+		c.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %s; want %v", state, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFile_ReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+- url: http://10.0.0.1:8080
+  weight: 3
+  metadata: { zone: a }
+- url: http://10.0.0.2:8080
+`)
+	f, err := NewFile("file://"+path, FileOptions{Debounce: 10 * time.Millisecond}, testLogger)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+	c := watch(t, f)
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 3}, Instance{URL: "http://10.0.0.2:8080", Weight: 1})
+	c.mu.Lock()
+	if md := c.state["http://10.0.0.1:8080"].Metadata; md["zone"] != "a" {
+		t.Errorf("metadata = %v; want zone=a", md)
+	}
+	c.mu.Unlock()
+
+	// невалидный файл игнорируется, состав сохраняется
+	write(`- url: "not a url"`)
+	time.Sleep(50 * time.Millisecond)
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 3}, Instance{URL: "http://10.0.0.2:8080", Weight: 1})
+
+	write(`
+- url: http://10.0.0.1:8080
+  weight: 1
+- url: http://10.0.0.3:8080
+`)
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 1}, Instance{URL: "http://10.0.0.3:8080", Weight: 1})
+}
+
+func TestFile_InvalidTargets(t *testing.T) {
+	for _, raw := range []string{"file://host/x.json", "file:///etc/lb/api.txt"} {
+		if _, err := NewFile(raw, FileOptions{}, testLogger); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("NewFile(%q): err = %v; want ErrInvalidTarget", raw, err)
+		}
+	}
+	f, _ := NewFile("file:///nonexistent/api.json", FileOptions{}, testLogger)
+	if err := f.Watch(context.Background(), make(chan []Event)); err == nil {
+		t.Error("Watch of missing file: want error")
+	}
+}
+
+func TestHTTP_PollsWithETag(t *testing.T) {
+	var body atomic.Value
+	body.Store(`[{"url":"http://10.0.0.1:8080","weight":2}]`)
+	var notModified atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := body.Load().(string)
+		etag := fmt.Sprintf(`"%x"`, len(b))
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(b))
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTP("poll+"+srv.URL, HTTPOptions{Interval: 10 * time.Millisecond, Timeout: time.Second}, testLogger)
+	if err != nil {
+		t.Fatalf("NewHTTP: %v", err)
+	}
+	c := watch(t, h)
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 2})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for notModified.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("poller does not send If-None-Match")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	body.Store(`[{"url":"http://10.0.0.1:8080","weight":2},{"url":"http://10.0.0.2:8080"}]`)
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 2}, Instance{URL: "http://10.0.0.2:8080", Weight: 1})
+}
+
+func TestHTTP_InitialFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	h, _ := NewHTTP("poll+"+srv.URL, HTTPOptions{Interval: time.Second, Timeout: time.Second}, testLogger)
+	if err := h.Watch(context.Background(), make(chan []Event)); !errors.Is(err, ErrPoll) {
+		t.Errorf("Watch: err = %v; want ErrPoll", err)
+	}
+}
+
+// fakePool запоминает последний состав, переданный группой
+type fakePool struct {
+	mu        sync.Mutex
+	instances []Instance
+}
+
+func (p *fakePool) ReconcileBackends(instances []Instance) ([]string, []string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.instances = slices.Clone(instances)
+	return nil, nil, nil
+}
+
+func (p *fakePool) urls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var urls []string
+	for _, in := range p.instances {
+		urls = append(urls, in.URL)
+	}
+	return urls
+}
+
+func TestGroup_MergesSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.json")
+	if err := os.WriteFile(path, []byte(`[{"url":"http://10.0.0.1:8080"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{File: FileOptions{Debounce: 10 * time.Millisecond}}
+	g, err := NewGroup([]string{"file://" + path, "http://static:80"}, opts, testLogger)
+	if err != nil {
+		t.Fatalf("NewGroup: %v", err)
+	}
+	if err := g.Start(time.Second); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer g.Stop()
+
+	pool := &fakePool{}
+	g.Attach(pool)
+	if got, want := pool.urls(), []string{"http://static:80", "http://10.0.0.1:8080"}; !slices.Equal(got, want) {
+		t.Fatalf("pool = %v; want %v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`[]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Equal(pool.urls(), []string{"http://static:80"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool = %v; want only static after file emptied", pool.urls())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGroup_StartFailsOnBadSource(t *testing.T) {
+	g, err := NewGroup([]string{"file:///nonexistent/api.json"}, Options{}, testLogger)
+	if err != nil {
+		t.Fatalf("NewGroup: %v", err)
+	}
+	if err := g.Start(time.Second); err == nil {
+		t.Error("Start with missing file: want error")
+	}
+}
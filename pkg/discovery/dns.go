@@ -0,0 +1,273 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	schemeSRV = "dns+srv"
+	schemeA   = "dns+a"
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid discovery target")
+	ErrResolve       = errors.New("dns resolution failed")
+)
+
+// DNSOptions — настройки DNS-обнаружения
+type DNSOptions struct {
+	// Server — адрес DNS-сервера host:port, пустой — первый nameserver из /etc/resolv.conf
+	Server  string
+	Timeout time.Duration
+	// MinRefresh и MaxRefresh ограничивают интервал перезапроса, который берётся из TTL
+	MinRefresh time.Duration
+	MaxRefresh time.Duration
+}
+
+// IsDNS сообщает, задан ли бекенд DNS-целью (dns+srv:// или dns+a://), а не адресом
+func IsDNS(raw string) bool {
+	return strings.HasPrefix(raw, schemeSRV+"://") || strings.HasPrefix(raw, schemeA+"://")
+}
+
+// DNS превращает DNS-имя в список адресов бекендов:
+//   - dns+srv://_http._tcp.api.internal — SRV-записи с наименьшим приоритетом, адреса их целей,
+//     вес SRV-записи становится весом экземпляра;
+//   - dns+a://api.internal:8080 — A-записи имени с портом из URL.
+//
+// Записи перезапрашиваются по истечении TTL.
+type DNS struct {
+	raw    string
+	srv    bool
+	name   string
+	port   string
+	client *dns.Client
+	server string
+	opts   DNSOptions
+	log    *slog.Logger
+}
+
+// NewDNS разбирает DNS-цель. Имя считается полным, search-домены не применяются
+func NewDNS(raw string, opts DNSOptions, log *slog.Logger) (*DNS, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidTarget, raw, err)
+	}
+	d := &DNS{raw: raw, name: dns.Fqdn(u.Hostname()), port: u.Port(), opts: opts, log: log}
+	switch u.Scheme {
+	case schemeSRV:
+		d.srv = true
+		if d.port != "" {
+			return nil, fmt.Errorf("%w %q: port comes from SRV records", ErrInvalidTarget, raw)
+		}
+	case schemeA:
+		if d.port == "" {
+			return nil, fmt.Errorf("%w %q: port is required", ErrInvalidTarget, raw)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q: unknown scheme %q", ErrInvalidTarget, raw, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w %q: no name", ErrInvalidTarget, raw)
+	}
+	if _, ok := dns.IsDomainName(d.name); !ok {
+		return nil, fmt.Errorf("%w %q: bad domain name", ErrInvalidTarget, raw)
+	}
+
+	d.server = opts.Server
+	if d.server == "" {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil || len(conf.Servers) == 0 {
+			return nil, fmt.Errorf("%w: no dns server configured", ErrResolve)
+		}
+		d.server = net.JoinHostPort(conf.Servers[0], conf.Port)
+	}
+	d.client = &dns.Client{Timeout: opts.Timeout}
+	return d, nil
+}
+
+// String возвращает цель в том виде, в каком она задана в конфиге
+func (d *DNS) String() string {
+	return d.raw
+}
+
+// Watch разрешает цель и перезапрашивает её по TTL, отправляя изменения состава
+func (d *DNS) Watch(ctx context.Context, events chan<- []Event) error {
+	cur, next, err := d.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if !emit(ctx, events, Diff(nil, cur)) {
+		return nil
+	}
+	for sleep(ctx, next) {
+		var instances []Instance
+		instances, next, err = d.Resolve(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.log.Error("dns discovery failed, keeping previous backends", "target", d.raw, "error", err)
+			}
+			continue
+		}
+		if batch := Diff(cur, instances); len(batch) > 0 {
+			if !emit(ctx, events, batch) {
+				return nil
+			}
+			cur = instances
+		}
+	}
+	return nil
+}
+
+// Resolve возвращает экземпляры, отсортированные по URL, и время, через которое их
+// стоит перезапросить (минимальный TTL, ограниченный MinRefresh/MaxRefresh).
+// Отсутствие записей — не ошибка: пул сервиса становится пустым
+func (d *DNS) Resolve(ctx context.Context) ([]Instance, time.Duration, error) {
+	var (
+		addrs []weighted
+		ttl   uint32
+		err   error
+	)
+	if d.srv {
+		addrs, ttl, err = d.resolveSRV(ctx)
+	} else {
+		var ips []string
+		ips, ttl, err = d.lookupA(ctx, d.name)
+		for _, ip := range ips {
+			addrs = append(addrs, weighted{addr: net.JoinHostPort(ip, d.port), weight: 1})
+		}
+	}
+	if err != nil {
+		return nil, d.opts.MinRefresh, err
+	}
+
+	instances := make([]Instance, 0, len(addrs))
+	for _, a := range addrs {
+		instances = append(instances, Instance{URL: "http://" + a.addr, Weight: a.weight})
+	}
+	slices.SortStableFunc(instances, func(a, b Instance) int { return strings.Compare(a.URL, b.URL) })
+	instances = slices.CompactFunc(instances, func(a, b Instance) bool { return a.URL == b.URL })
+	if len(instances) == 0 {
+		// записей нет — проверяем чаще, чтобы быстрее заметить появление сервиса
+		return instances, d.opts.MinRefresh, nil
+	}
+	return instances, d.refresh(ttl), nil
+}
+
+// weighted — адрес host:port с весом из SRV-записи
+type weighted struct {
+	addr   string
+	weight int
+}
+
+func (d *DNS) refresh(ttl uint32) time.Duration {
+	next := time.Duration(ttl) * time.Second
+	if next < d.opts.MinRefresh {
+		next = d.opts.MinRefresh
+	}
+	if d.opts.MaxRefresh > 0 && next > d.opts.MaxRefresh {
+		next = d.opts.MaxRefresh
+	}
+	return next
+}
+
+func (d *DNS) resolveSRV(ctx context.Context) ([]weighted, uint32, error) {
+	resp, err := d.exchange(ctx, d.name, dns.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	var srvs []*dns.SRV
+	ttl := uint32(math.MaxUint32)
+	for _, rr := range resp.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, srv)
+			ttl = min(ttl, srv.Hdr.Ttl)
+		}
+	}
+	if len(srvs) == 0 {
+		return nil, ttl, nil
+	}
+	// остальные приоритеты — резерв, используем только наименьший
+	best := srvs[0].Priority
+	for _, srv := range srvs {
+		best = min(best, srv.Priority)
+	}
+	// адреса целей часто приходят в additional-секции, иначе спрашиваем отдельно
+	glue := make(map[string][]string)
+	for _, rr := range resp.Extra {
+		if a, ok := rr.(*dns.A); ok {
+			glue[strings.ToLower(a.Hdr.Name)] = append(glue[strings.ToLower(a.Hdr.Name)], a.A.String())
+			ttl = min(ttl, a.Hdr.Ttl)
+		}
+	}
+
+	var addrs []weighted
+	for _, srv := range srvs {
+		if srv.Priority != best || srv.Target == "." {
+			continue
+		}
+		ips, ok := glue[strings.ToLower(srv.Target)]
+		if !ok {
+			var aTTL uint32
+			ips, aTTL, err = d.lookupA(ctx, srv.Target)
+			if err != nil {
+				return nil, 0, err
+			}
+			ttl = min(ttl, aTTL)
+		}
+		weight := min(max(int(srv.Weight), 1), MaxWeight)
+		for _, ip := range ips {
+			addrs = append(addrs, weighted{addr: net.JoinHostPort(ip, strconv.Itoa(int(srv.Port))), weight: weight})
+		}
+	}
+	return addrs, ttl, nil
+}
+
+func (d *DNS) lookupA(ctx context.Context, name string) ([]string, uint32, error) {
+	resp, err := d.exchange(ctx, name, dns.TypeA)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ips []string
+	ttl := uint32(math.MaxUint32)
+	for _, rr := range resp.Answer {
+		// CNAME в ответе пропускаем, A-записи цепочки идут следом
+		if a, ok := rr.(*dns.A); ok {
+			ips = append(ips, a.A.String())
+			ttl = min(ttl, a.Hdr.Ttl)
+		}
+	}
+	return ips, ttl, nil
+}
+
+func (d *DNS) exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	resp, _, err := d.client.ExchangeContext(ctx, q, d.server)
+	if err == nil && resp.Truncated {
+		// ответ не влез в UDP — повторяем по TCP
+		tcp := *d.client
+		tcp.Net = "tcp"
+		resp, _, err = tcp.ExchangeContext(ctx, q, d.server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s: %w", ErrResolve, dns.TypeToString[qtype], name, err)
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return resp, nil
+	default:
+		return nil, fmt.Errorf("%w: %s %s: %s", ErrResolve, dns.TypeToString[qtype], name, dns.RcodeToString[resp.Rcode])
+	}
+}
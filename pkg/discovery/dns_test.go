@@ -0,0 +1,224 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// stubDNS — локальный DNS-сервер, отвечающий заданными записями
+type stubDNS struct {
+	addr string
+
+	mu      sync.Mutex
+	records map[string][]dns.RR
+	// extra — записи additional-секции для SRV-ответов
+	extra   map[string][]dns.RR
+	queries map[string]int
+}
+
+func newStubDNS(t *testing.T) *stubDNS {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubDNS{
+		addr:    pc.LocalAddr().String(),
+		records: make(map[string][]dns.RR),
+		extra:   make(map[string][]dns.RR),
+		queries: make(map[string]int),
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: s, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return s
+}
+
+func (s *stubDNS) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	key := dns.TypeToString[q.Qtype] + " " + q.Name
+	s.mu.Lock()
+	s.queries[key]++
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer, s.records[key]...)
+	resp.Extra = append(resp.Extra, s.extra[key]...)
+	s.mu.Unlock()
+	if len(resp.Answer) == 0 {
+		resp.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(resp)
+}
+
+// set заменяет записи; rrs в формате зоны, например "api.internal. 30 IN A 10.0.0.1"
+func (s *stubDNS) set(t *testing.T, key string, rrs ...string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = parseRRs(t, rrs)
+}
+
+func (s *stubDNS) setExtra(t *testing.T, key string, rrs ...string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extra[key] = parseRRs(t, rrs)
+}
+
+func (s *stubDNS) count(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[key]
+}
+
+func parseRRs(t *testing.T, rrs []string) []dns.RR {
+	t.Helper()
+	out := make([]dns.RR, 0, len(rrs))
+	for _, r := range rrs {
+		rr, err := dns.NewRR(r)
+		if err != nil {
+			t.Fatalf("bad record %q: %v", r, err)
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+var testLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+func testOptions(server string) DNSOptions {
+	return DNSOptions{Server: server, Timeout: time.Second, MinRefresh: 10 * time.Millisecond, MaxRefresh: time.Minute}
+}
+
+func TestDNS_ResolveA(t *testing.T) {
+	stub := newStubDNS(t)
+	stub.set(t, "A api.internal.",
+		"api.internal. 30 IN A 10.0.0.2",
+		"api.internal. 10 IN A 10.0.0.1")
+
+	d, err := NewDNS("dns+a://api.internal:8080", testOptions(stub.addr), testLogger)
+	if err != nil {
+		t.Fatalf("NewDNS: %v", err)
+	}
+	got, next, err := d.Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	want := []Instance{{URL: "http://10.0.0.1:8080", Weight: 1}, {URL: "http://10.0.0.2:8080", Weight: 1}}
+	if !slices.EqualFunc(got, want, sameInstance) {
+		t.Errorf("instances = %v; want %v", got, want)
+	}
+	if next != 10*time.Second {
+		t.Errorf("refresh = %v; want minimal TTL 10s", next)
+	}
+}
+
+func TestDNS_ResolveSRV(t *testing.T) {
+	stub := newStubDNS(t)
+	stub.set(t, "SRV _http._tcp.api.internal.",
+		"_http._tcp.api.internal. 60 IN SRV 10 5 8081 a.api.internal.",
+		"_http._tcp.api.internal. 60 IN SRV 10 0 8082 b.api.internal.",
+		"_http._tcp.api.internal. 60 IN SRV 20 5 8083 backup.api.internal.")
+	// адрес a приходит в additional-секции, b запрашивается отдельно
+	stub.setExtra(t, "SRV _http._tcp.api.internal.", "a.api.internal. 60 IN A 10.0.0.1")
+	stub.set(t, "A b.api.internal.", "b.api.internal. 5 IN A 10.0.0.2")
+	stub.set(t, "A backup.api.internal.", "backup.api.internal. 60 IN A 10.0.0.3")
+
+	d, err := NewDNS("dns+srv://_http._tcp.api.internal", testOptions(stub.addr), testLogger)
+	if err != nil {
+		t.Fatalf("NewDNS: %v", err)
+	}
+	got, next, err := d.Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	// вес SRV переходит в вес экземпляра, нулевой считается единицей
+	want := []Instance{{URL: "http://10.0.0.1:8081", Weight: 5}, {URL: "http://10.0.0.2:8082", Weight: 1}}
+	if !slices.EqualFunc(got, want, sameInstance) {
+		t.Errorf("instances = %v; want %v (lowest priority only)", got, want)
+	}
+	if next != 5*time.Second {
+		t.Errorf("refresh = %v; want 5s from target A record", next)
+	}
+	if n := stub.count("A a.api.internal."); n != 0 {
+		t.Errorf("a.api.internal queried %d times despite glue record", n)
+	}
+}
+
+func TestDNS_ResolveMissingAndErrors(t *testing.T) {
+	stub := newStubDNS(t)
+	d, err := NewDNS("dns+a://gone.internal:80", testOptions(stub.addr), testLogger)
+	if err != nil {
+		t.Fatalf("NewDNS: %v", err)
+	}
+	got, next, err := d.Resolve(context.Background())
+	if err != nil || len(got) != 0 {
+		t.Errorf("Resolve of missing name = %v, %v; want empty set without error", got, err)
+	}
+	if next != 10*time.Millisecond {
+		t.Errorf("refresh = %v; want MinRefresh for empty answer", next)
+	}
+
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	silent := pc.LocalAddr().String()
+	defer pc.Close()
+	opts := testOptions(silent)
+	opts.Timeout = 50 * time.Millisecond
+	d, _ = NewDNS("dns+a://api.internal:80", opts, testLogger)
+	if _, _, err := d.Resolve(context.Background()); !errors.Is(err, ErrResolve) {
+		t.Errorf("Resolve against silent server: err = %v; want ErrResolve", err)
+	}
+
+	for _, raw := range []string{"dns+a://api.internal", "dns+srv://_http._tcp.api:80", "dns+mx://api:80", "dns+a://:80"} {
+		if _, err := NewDNS(raw, testOptions(stub.addr), testLogger); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("NewDNS(%q): err = %v; want ErrInvalidTarget", raw, err)
+		}
+	}
+}
+
+func sameInstance(a, b Instance) bool {
+	return a.URL == b.URL && a.Weight == b.Weight
+}
+
+func TestDNS_WatchFollowsRecords(t *testing.T) {
+	stub := newStubDNS(t)
+	// TTL 0: перезапрос через MinRefresh
+	stub.set(t, "A api.internal.", "api.internal. 0 IN A 10.0.0.1")
+
+	d, err := NewDNS("dns+a://api.internal:8080", testOptions(stub.addr), testLogger)
+	if err != nil {
+		t.Fatalf("NewDNS: %v", err)
+	}
+	events := make(chan []Event)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Watch(ctx, events)
+
+	if batch := <-events; len(batch) != 1 || batch[0].Type != EventAdd || batch[0].Instance.URL != "http://10.0.0.1:8080" {
+		t.Fatalf("initial batch = %+v; want add of 10.0.0.1", batch)
+	}
+	stub.set(t, "A api.internal.", "api.internal. 0 IN A 10.0.0.2")
+	select {
+	case batch := <-events:
+		types := map[EventType]string{}
+		for _, ev := range batch {
+			types[ev.Type] = ev.Instance.URL
+		}
+		if len(batch) != 2 || types[EventAdd] != "http://10.0.0.2:8080" || types[EventRemove] != "http://10.0.0.1:8080" {
+			t.Errorf("change batch = %+v; want add 10.0.0.2 and remove 10.0.0.1", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("record change was not reported")
+	}
+}
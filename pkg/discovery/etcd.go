@@ -0,0 +1,290 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrWatch = errors.New("etcd watch failed")
+
+// EtcdOptions — настройки обнаружения через etcd
+type EtcdOptions struct {
+	// Timeout — таймаут запроса range, watch-поток живёт без таймаута
+	Timeout time.Duration
+	// Retry — пауза перед переподключением после обрыва watch
+	Retry time.Duration
+}
+
+func isEtcd(raw string) bool {
+	return strings.HasPrefix(raw, "etcd://") || strings.HasPrefix(raw, "etcd+https://")
+}
+
+// Etcd следит за ключами с заданным префиксом через JSON-шлюз etcd v3 (/v3/kv/range
+// и /v3/watch). Значение ключа — JSON-объект {url, weight, metadata} или просто URL.
+// После обрыва watch состав перечитывается целиком, поэтому пропущенные
+// (например, из-за компакции) события не теряются
+type Etcd struct {
+	raw      string
+	base     string
+	prefix   []byte
+	rangeEnd []byte
+	client   *http.Client
+	stream   *http.Client
+	opts     EtcdOptions
+	log      *slog.Logger
+}
+
+// NewEtcd разбирает цель вида etcd://etcd.internal:2379/services/api/
+func NewEtcd(raw string, opts EtcdOptions, log *slog.Logger) (*Etcd, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidTarget, raw, err)
+	}
+	if u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("%w %q: want etcd://host:port/key/prefix/", ErrInvalidTarget, raw)
+	}
+	scheme := "http"
+	if u.Scheme == "etcd+https" {
+		scheme = "https"
+	}
+	prefix := []byte(u.Path)
+	return &Etcd{
+		raw:      raw,
+		base:     scheme + "://" + u.Host,
+		prefix:   prefix,
+		rangeEnd: prefixEnd(prefix),
+		client:   &http.Client{Timeout: opts.Timeout},
+		stream:   &http.Client{},
+		opts:     opts,
+		log:      log,
+	}, nil
+}
+
+// prefixEnd — первый ключ после всех ключей с префиксом, как в clientv3.GetPrefixRangeEnd
+func prefixEnd(prefix []byte) []byte {
+	end := slices.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (e *Etcd) String() string {
+	return e.raw
+}
+
+func (e *Etcd) Watch(ctx context.Context, events chan<- []Event) error {
+	state, rev, err := e.rangeAll(ctx)
+	if err != nil {
+		return err
+	}
+	cur := e.instances(state)
+	if !emit(ctx, events, Diff(nil, cur)) {
+		return nil
+	}
+	for {
+		err := e.watch(ctx, rev+1, state, func(next []Instance) bool {
+			batch := Diff(cur, next)
+			if len(batch) == 0 {
+				return true
+			}
+			cur = next
+			return emit(ctx, events, batch)
+		}, &rev)
+		if ctx.Err() != nil {
+			return nil
+		}
+		e.log.Error("etcd watch interrupted, resyncing", "target", e.raw, "error", err)
+		if !sleep(ctx, e.opts.Retry) {
+			return nil
+		}
+
+		next, nextRev, err := e.rangeAll(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				e.log.Error("etcd discovery failed, keeping previous backends", "target", e.raw, "error", err)
+			}
+			continue
+		}
+		state, rev = next, nextRev
+		nextList := e.instances(state)
+		if batch := Diff(cur, nextList); len(batch) > 0 {
+			if !emit(ctx, events, batch) {
+				return nil
+			}
+			cur = nextList
+		}
+	}
+}
+
+// etcdKV — ключ и значение в JSON-шлюзе, байты приходят в base64
+type etcdKV struct {
+	Key         []byte      `json:"key"`
+	Value       []byte      `json:"value"`
+	ModRevision json.Number `json:"mod_revision"`
+}
+
+type etcdHeader struct {
+	Revision json.Number `json:"revision"`
+}
+
+func (e *Etcd) post(ctx context.Context, client *http.Client, path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.base+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: status %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// rangeAll читает все ключи префикса и ревизию, с которой надо начинать watch
+func (e *Etcd) rangeAll(ctx context.Context) (map[string]Instance, int64, error) {
+	resp, err := e.post(ctx, e.client, "/v3/kv/range", map[string][]byte{"key": e.prefix, "range_end": e.rangeEnd})
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrPoll, err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Header etcdHeader `json:"header"`
+		KVs    []etcdKV   `json:"kvs"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxListBytes)).Decode(&body); err != nil {
+		return nil, 0, fmt.Errorf("%w: range: %w", ErrPoll, err)
+	}
+	rev, err := body.Header.Revision.Int64()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: range: bad revision", ErrPoll)
+	}
+	state := make(map[string]Instance, len(body.KVs))
+	for _, kv := range body.KVs {
+		e.put(state, kv)
+	}
+	return state, rev, nil
+}
+
+// watch держит watch-поток с ревизии start и вызывает onChange с составом после
+// каждой пачки событий. Возвращается при обрыве, отмене или компакции
+func (e *Etcd) watch(ctx context.Context, start int64, state map[string]Instance, onChange func([]Instance) bool, rev *int64) error {
+	req := map[string]any{"create_request": map[string]any{
+		"key":            e.prefix,
+		"range_end":      e.rangeEnd,
+		"start_revision": start,
+	}}
+	resp, err := e.post(ctx, e.stream, "/v3/watch", req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWatch, err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result *struct {
+				Header          etcdHeader  `json:"header"`
+				Canceled        bool        `json:"canceled"`
+				CompactRevision json.Number `json:"compact_revision"`
+				Events          []struct {
+					Type string `json:"type"`
+					KV   etcdKV `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return fmt.Errorf("%w: %w", ErrWatch, err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%w: %s", ErrWatch, msg.Error.Message)
+		}
+		res := msg.Result
+		if res == nil {
+			continue
+		}
+		if res.Canceled || (res.CompactRevision != "" && res.CompactRevision != "0") {
+			return fmt.Errorf("%w: canceled at compact revision %s", ErrWatch, res.CompactRevision)
+		}
+		if len(res.Events) == 0 {
+			continue
+		}
+		for _, ev := range res.Events {
+			// PUT — значение по умолчанию в protobuf, в JSON поле type опускается
+			if ev.Type == "DELETE" {
+				delete(state, string(ev.KV.Key))
+			} else {
+				e.put(state, ev.KV)
+			}
+			if r, err := ev.KV.ModRevision.Int64(); err == nil && r > *rev {
+				*rev = r
+			}
+		}
+		if !onChange(e.instances(state)) {
+			return nil
+		}
+	}
+}
+
+// put разбирает значение ключа. Невалидные значения пропускаются с ошибкой в логе
+func (e *Etcd) put(state map[string]Instance, kv etcdKV) {
+	key := string(kv.Key)
+	var in Instance
+	if err := json.Unmarshal(kv.Value, &in); err != nil {
+		in = Instance{URL: strings.TrimSpace(string(kv.Value))}
+	}
+	checked, err := normalize([]Instance{in})
+	if err != nil {
+		e.log.Error("invalid instance in etcd, skipped", "target", e.raw, "key", key, "error", err)
+		delete(state, key)
+		return
+	}
+	state[key] = checked[0]
+}
+
+// instances собирает состав из ключей; при повторе URL побеждает меньший ключ
+func (e *Etcd) instances(state map[string]Instance) []Instance {
+	keys := slices.Sorted(func(yield func(string) bool) {
+		for k := range state {
+			if !yield(k) {
+				return
+			}
+		}
+	})
+	seen := make(map[string]bool, len(keys))
+	out := make([]Instance, 0, len(keys))
+	for _, k := range keys {
+		in := state[k]
+		if seen[in.URL] {
+			continue
+		}
+		seen[in.URL] = true
+		out = append(out, in)
+	}
+	slices.SortFunc(out, func(a, b Instance) int { return strings.Compare(a.URL, b.URL) })
+	return out
+}
@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEtcd — JSON-шлюз etcd v3 с range и потоковым watch
+type fakeEtcd struct {
+	mu       sync.Mutex
+	rev      int64
+	kvs      map[string]string
+	watchers []chan map[string]any
+	ranges   int
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{rev: 1, kvs: make(map[string]string)}
+}
+
+func (f *fakeEtcd) broadcast(msg map[string]any) {
+	for _, w := range f.watchers {
+		w <- msg
+	}
+}
+
+func (f *fakeEtcd) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	f.kvs[key] = value
+	rev := strconv.FormatInt(f.rev, 10)
+	f.broadcast(map[string]any{"result": map[string]any{
+		"header": map[string]any{"revision": rev},
+		"events": []any{map[string]any{"kv": map[string]any{"key": []byte(key), "value": []byte(value), "mod_revision": rev}}},
+	}})
+}
+
+func (f *fakeEtcd) delete(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	delete(f.kvs, key)
+	rev := strconv.FormatInt(f.rev, 10)
+	f.broadcast(map[string]any{"result": map[string]any{
+		"header": map[string]any{"revision": rev},
+		"events": []any{map[string]any{"type": "DELETE", "kv": map[string]any{"key": []byte(key), "mod_revision": rev}}},
+	}})
+}
+
+// compact отменяет текущие watch, как etcd после компакции истории
+func (f *fakeEtcd) compact() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.broadcast(map[string]any{"result": map[string]any{"canceled": true, "compact_revision": strconv.FormatInt(f.rev, 10)}})
+}
+
+func (f *fakeEtcd) rangeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ranges
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.ranges++
+		var kvs []any
+		for k, v := range f.kvs {
+			if bytes.Compare([]byte(k), req.Key) >= 0 && bytes.Compare([]byte(k), req.RangeEnd) < 0 {
+				kvs = append(kvs, map[string]any{"key": []byte(k), "value": []byte(v)})
+			}
+		}
+		rev := strconv.FormatInt(f.rev, 10)
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"header": map[string]any{"revision": rev}, "kvs": kvs})
+	case "/v3/watch":
+		ch := make(chan map[string]any, 16)
+		f.mu.Lock()
+		f.watchers = append(f.watchers, ch)
+		f.mu.Unlock()
+		defer func() {
+			f.mu.Lock()
+			for i, w := range f.watchers {
+				if w == ch {
+					f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+				}
+			}
+			f.mu.Unlock()
+		}()
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]any{"result": map[string]any{"created": true}})
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case msg := <-ch:
+				enc.Encode(msg)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcd_WatchesPrefix(t *testing.T) {
+	fake := newFakeEtcd()
+	fake.kvs["/services/api/a"] = `{"url":"http://10.0.0.1:8080","weight":2,"metadata":{"zone":"a"}}`
+	fake.kvs["/services/api/b"] = "http://10.0.0.2:8080"
+	fake.kvs["/services/api/bad"] = "not a url"
+	fake.kvs["/services/apiother/x"] = "http://10.0.0.9:8080"
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	e, err := NewEtcd("etcd://"+srv.Listener.Addr().String()+"/services/api/", EtcdOptions{Timeout: time.Second, Retry: 10 * time.Millisecond}, testLogger)
+	if err != nil {
+		t.Fatalf("NewEtcd: %v", err)
+	}
+	c := watch(t, e)
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 2}, Instance{URL: "http://10.0.0.2:8080", Weight: 1})
+	c.mu.Lock()
+	if md := c.state["http://10.0.0.1:8080"].Metadata; md["zone"] != "a" {
+		t.Errorf("metadata = %v; want zone=a", md)
+	}
+	c.mu.Unlock()
+
+	// ждём, пока watch подключится, чтобы события не прошли мимо
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		n := len(fake.watchers)
+		fake.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch was not opened")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fake.put("/services/api/c", "http://10.0.0.3:8080")
+	fake.delete("/services/api/a")
+	c.waitFor(t, Instance{URL: "http://10.0.0.2:8080", Weight: 1}, Instance{URL: "http://10.0.0.3:8080", Weight: 1})
+
+	// после компакции состав перечитывается целиком, пропущенные изменения не теряются
+	ranges := fake.rangeCount()
+	fake.mu.Lock()
+	fake.kvs["/services/api/d"] = "http://10.0.0.4:8080"
+	fake.mu.Unlock()
+	fake.compact()
+	c.waitFor(t, Instance{URL: "http://10.0.0.2:8080", Weight: 1}, Instance{URL: "http://10.0.0.3:8080", Weight: 1}, Instance{URL: "http://10.0.0.4:8080", Weight: 1})
+	if fake.rangeCount() <= ranges {
+		t.Error("no resync after watch was canceled")
+	}
+}
+
+func TestEtcd_InvalidTargetsAndFailure(t *testing.T) {
+	for _, raw := range []string{"etcd:///services/", "etcd://etcd:2379", "etcd://etcd:2379/"} {
+		if _, err := NewEtcd(raw, EtcdOptions{}, testLogger); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("NewEtcd(%q): err = %v; want ErrInvalidTarget", raw, err)
+		}
+	}
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	e, _ := NewEtcd("etcd://"+srv.Listener.Addr().String()+"/services/api/", EtcdOptions{Timeout: time.Second}, testLogger)
+	if err := e.Watch(context.Background(), make(chan []Event)); !errors.Is(err, ErrPoll) {
+		t.Errorf("Watch: err = %v; want ErrPoll", err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"/a/": "/a0", "a\xff": "b", "\xff\xff": "\x00"} {
+		if got := string(prefixEnd([]byte(prefix))); got != want {
+			t.Errorf("prefixEnd(%q) = %q; want %q", prefix, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// FileOptions — настройки файлового обнаружения
+type FileOptions struct {
+	// Debounce — пауза после последнего изменения файла перед перечитыванием
+	Debounce time.Duration
+}
+
+func isFile(raw string) bool {
+	return strings.HasPrefix(raw, "file:")
+}
+
+// File читает список экземпляров из JSON- или YAML-файла (по расширению) и
+// перечитывает его при изменении. Формат — массив объектов {url, weight, metadata}
+type File struct {
+	raw  string
+	path string
+	yaml bool
+	opts FileOptions
+	log  *slog.Logger
+}
+
+// NewFile разбирает цель вида file:///etc/lb/api.yaml или file:backends.json (относительный путь)
+func NewFile(raw string, opts FileOptions, log *slog.Logger) (*File, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidTarget, raw, err)
+	}
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+	if u.Host != "" || path == "" {
+		return nil, fmt.Errorf("%w %q: want file:///absolute/path or file:relative/path", ErrInvalidTarget, raw)
+	}
+	f := &File{raw: raw, path: filepath.Clean(path), opts: opts, log: log}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yml", ".yaml":
+		f.yaml = true
+	default:
+		return nil, fmt.Errorf("%w %q: file must be .json, .yml or .yaml", ErrInvalidTarget, raw)
+	}
+	return f, nil
+}
+
+func (f *File) String() string {
+	return f.raw
+}
+
+// Watch следит за каталогом файла: редакторы и ConfigMap в Kubernetes подменяют файл через rename
+func (f *File) Watch(ctx context.Context, events chan<- []Event) error {
+	cur, err := f.read()
+	if err != nil {
+		return err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(f.path)); err != nil {
+		return err
+	}
+	if !emit(ctx, events, Diff(nil, cur)) {
+		return nil
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(ev.Name) == f.path || filepath.Base(ev.Name) == "..data" {
+				reload = time.After(f.opts.Debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			f.log.Error("discovery file watcher error", "target", f.raw, "error", err)
+		case <-reload:
+			reload = nil
+			next, err := f.read()
+			if err != nil {
+				f.log.Error("discovery file rejected, keeping previous backends", "target", f.raw, "error", err)
+				continue
+			}
+			if batch := Diff(cur, next); len(batch) > 0 {
+				if !emit(ctx, events, batch) {
+					return nil
+				}
+				cur = next
+			}
+		}
+	}
+}
+
+func (f *File) read() ([]Instance, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var instances []Instance
+	if f.yaml {
+		err = yaml.Unmarshal(data, &instances)
+	} else {
+		err = json.Unmarshal(data, &instances)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidInstance, f.path, err)
+	}
+	return normalize(instances)
+}
@@ -0,0 +1,179 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotReady = errors.New("discovery did not report initial backends")
+
+// Reconciler — пул, состав которого ведёт обнаружение сервисов
+type Reconciler interface {
+	ReconcileBackends(instances []Instance) (added, drained []string, err error)
+}
+
+// Group собирает состав одного пула из нескольких источников (статические адреса,
+// DNS, файлы, HTTP) и передаёт его пулу после каждого изменения
+type Group struct {
+	entries   []string
+	providers []Discovery
+	log       *slog.Logger
+
+	mu    sync.Mutex
+	state []map[string]Instance
+	pool  Reconciler
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGroup создаёт источники по записям бекендов пула. Обычные адреса
+// объединяются в один статический источник
+func NewGroup(entries []string, opts Options, log *slog.Logger) (*Group, error) {
+	g := &Group{entries: slices.Clone(entries), log: log}
+	var static []string
+	for _, e := range entries {
+		if !IsDynamic(e) {
+			static = append(static, e)
+			continue
+		}
+		p, err := New(e, opts, log)
+		if err != nil {
+			return nil, err
+		}
+		g.providers = append(g.providers, p)
+	}
+	if len(static) > 0 {
+		g.providers = append([]Discovery{NewStatic(static)}, g.providers...)
+	}
+	g.state = make([]map[string]Instance, len(g.providers))
+	for i := range g.state {
+		g.state[i] = make(map[string]Instance)
+	}
+	return g, nil
+}
+
+// Entries возвращает записи бекендов, из которых собрана группа
+func (g *Group) Entries() []string {
+	return g.entries
+}
+
+// Start запускает источники и ждёт от каждого первый состав. Если хотя бы один
+// не ответил за timeout или вернул ошибку, группа останавливается
+func (g *Group) Start(timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	ready := make(chan error, len(g.providers))
+	for i, p := range g.providers {
+		events := make(chan []Event)
+		g.wg.Add(2)
+		go func() {
+			defer g.wg.Done()
+			if err := p.Watch(ctx, events); err != nil {
+				ready <- fmt.Errorf("%s: %w", p, err)
+			}
+		}()
+		go func() {
+			defer g.wg.Done()
+			first := true
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case batch := <-events:
+					g.apply(i, batch)
+					if first {
+						first = false
+						ready <- nil
+					}
+				}
+			}
+		}()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for range g.providers {
+		select {
+		case err := <-ready:
+			if err != nil {
+				g.Stop()
+				return err
+			}
+		case <-timer.C:
+			g.Stop()
+			return ErrNotReady
+		}
+	}
+	return nil
+}
+
+// Attach начинает передавать изменения состава пулу, сразу передав текущий
+func (g *Group) Attach(pool Reconciler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pool = pool
+	g.reconcile()
+}
+
+// Stop останавливает источники. Состав пула остаётся последним известным
+func (g *Group) Stop() {
+	if g.cancel != nil {
+		g.cancel()
+		g.wg.Wait()
+	}
+}
+
+// Instances возвращает текущий состав: источники в порядке записей, внутри — по URL
+func (g *Group) Instances() []Instance {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.instances()
+}
+
+func (g *Group) instances() []Instance {
+	var out []Instance
+	for _, st := range g.state {
+		part := make([]Instance, 0, len(st))
+		for _, in := range st {
+			part = append(part, in)
+		}
+		slices.SortFunc(part, func(a, b Instance) int { return strings.Compare(a.URL, b.URL) })
+		out = append(out, part...)
+	}
+	return out
+}
+
+func (g *Group) apply(i int, batch []Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, ev := range batch {
+		switch ev.Type {
+		case EventAdd, EventUpdate:
+			g.state[i][ev.Instance.URL] = ev.Instance
+		case EventRemove:
+			delete(g.state[i], ev.Instance.URL)
+		}
+	}
+	if len(batch) > 0 {
+		g.log.Info("discovered backends changed", "source", g.providers[i].String(), "events", len(batch))
+	}
+	g.reconcile()
+}
+
+// reconcile передаёт состав пулу под g.mu, чтобы пачки разных источников не
+// применились в обратном порядке
+func (g *Group) reconcile() {
+	if g.pool == nil {
+		return
+	}
+	if _, _, err := g.pool.ReconcileBackends(g.instances()); err != nil {
+		g.log.Error("failed to apply discovered backends", "error", err)
+	}
+}
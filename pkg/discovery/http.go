@@ -0,0 +1,127 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	pollPrefix = "poll+"
+	// maxListBytes — предел размера ответа со списком экземпляров
+	maxListBytes = 10 << 20
+)
+
+var ErrPoll = errors.New("discovery poll failed")
+
+// HTTPOptions — настройки опроса HTTP-источника
+type HTTPOptions struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func isHTTP(raw string) bool {
+	return strings.HasPrefix(raw, pollPrefix+"http://") || strings.HasPrefix(raw, pollPrefix+"https://")
+}
+
+// HTTP периодически запрашивает JSON-массив экземпляров {url, weight, metadata}.
+// Поддерживает ETag: неизменившийся список сервер может вернуть как 304
+type HTTP struct {
+	raw    string
+	url    string
+	client *http.Client
+	opts   HTTPOptions
+	log    *slog.Logger
+	etag   string
+}
+
+// NewHTTP разбирает цель вида poll+https://deploy.internal/instances/api
+func NewHTTP(raw string, opts HTTPOptions, log *slog.Logger) (*HTTP, error) {
+	target := strings.TrimPrefix(raw, pollPrefix)
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidTarget, raw, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w %q: no host", ErrInvalidTarget, raw)
+	}
+	return &HTTP{
+		raw:    raw,
+		url:    target,
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+		log:    log,
+	}, nil
+}
+
+func (h *HTTP) String() string {
+	return h.raw
+}
+
+func (h *HTTP) Watch(ctx context.Context, events chan<- []Event) error {
+	cur, _, err := h.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if !emit(ctx, events, Diff(nil, cur)) {
+		return nil
+	}
+	for sleep(ctx, h.opts.Interval) {
+		next, modified, err := h.fetch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.log.Error("discovery poll failed, keeping previous backends", "target", h.raw, "error", err)
+			}
+			continue
+		}
+		if !modified {
+			continue
+		}
+		if batch := Diff(cur, next); len(batch) > 0 {
+			if !emit(ctx, events, batch) {
+				return nil
+			}
+			cur = next
+		}
+	}
+	return nil
+}
+
+// fetch запрашивает список. modified=false — сервер ответил 304
+func (h *HTTP) fetch(ctx context.Context) (instances []Instance, modified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if h.etag != "" {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", ErrPoll, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && h.etag != "":
+		return nil, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("%w: %s: status %d", ErrPoll, h.url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxListBytes)).Decode(&instances); err != nil {
+		return nil, false, fmt.Errorf("%w: %s: %w", ErrInvalidInstance, h.url, err)
+	}
+	if instances, err = normalize(instances); err != nil {
+		return nil, false, err
+	}
+	h.etag = resp.Header.Get("ETag")
+	return instances, true, nil
+}
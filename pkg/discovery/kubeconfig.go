@@ -0,0 +1,238 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrKubeConfig = errors.New("kubernetes client config")
+
+// serviceAccountDir — каталог, куда kubelet монтирует токен и CA сервисного аккаунта
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient — минимальный клиент API-сервера: адрес, авторизация и TLS
+type kubeClient struct {
+	server string
+	token  string
+	// tokenFile перечитывается на каждый запрос: токены сервисного аккаунта ротируются
+	tokenFile string
+	client    *http.Client
+	stream    *http.Client
+}
+
+// newKubeClient берёт настройки из kubeconfig, если путь задан, иначе — из окружения
+// пода (KUBERNETES_SERVICE_HOST и смонтированный сервисный аккаунт)
+func newKubeClient(kubeconfig string, timeout time.Duration) (*kubeClient, error) {
+	var (
+		c   *kubeClient
+		tc  *tls.Config
+		err error
+	)
+	if kubeconfig != "" {
+		// KUBECONFIG может быть списком файлов, используется первый
+		path, _, _ := strings.Cut(kubeconfig, string(os.PathListSeparator))
+		c, tc, err = loadKubeconfig(path)
+	} else {
+		c, tc, err = inClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tc
+	c.client = &http.Client{Transport: transport, Timeout: timeout}
+	c.stream = &http.Client{Transport: transport}
+	return c, nil
+}
+
+func inClusterConfig() (*kubeClient, *tls.Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, nil, fmt.Errorf("%w: not running in a cluster and no kubeconfig given", ErrKubeConfig)
+	}
+	tc := &tls.Config{}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrKubeConfig, err)
+	}
+	if tc.RootCAs, err = certPool(ca); err != nil {
+		return nil, nil, err
+	}
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+	}, tc, nil
+}
+
+// kubeconfig — часть формата ~/.kube/config, нужная для подключения. exec- и
+// auth-provider-плагины не поддерживаются
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+func loadKubeconfig(path string) (*kubeClient, *tls.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrKubeConfig, err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %w", ErrKubeConfig, path, err)
+	}
+	// относительные пути в kubeconfig считаются от его каталога
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, nil, fmt.Errorf("%w: %s: current context %q not found", ErrKubeConfig, path, kc.CurrentContext)
+	}
+
+	c := &kubeClient{}
+	tc := &tls.Config{}
+	found := false
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = strings.TrimSuffix(cl.Cluster.Server, "/")
+		tc.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(resolve(cl.Cluster.CertificateAuthority), cl.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ca != nil {
+			if tc.RootCAs, err = certPool(ca); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if !found || c.server == "" {
+		return nil, nil, fmt.Errorf("%w: %s: cluster %q has no server", ErrKubeConfig, path, clusterName)
+	}
+	if _, err := url.Parse(c.server); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %w", ErrKubeConfig, path, err)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		c.token, c.tokenFile = u.User.Token, resolve(u.User.TokenFile)
+		cert, err := fileOrData(resolve(u.User.ClientCertificate), u.User.ClientCertificateData)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := fileOrData(resolve(u.User.ClientKey), u.User.ClientKeyData)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cert != nil || key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: client certificate: %w", ErrKubeConfig, err)
+			}
+			tc.Certificates = []tls.Certificate{pair}
+		}
+	}
+	return c, tc, nil
+}
+
+// fileOrData читает PEM из файла или из base64-поля *-data
+func fileOrData(path, data string) ([]byte, error) {
+	switch {
+	case data != "":
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKubeConfig, err)
+		}
+		return b, nil
+	case path != "":
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKubeConfig, err)
+		}
+		return b, nil
+	}
+	return nil, nil
+}
+
+func certPool(pem []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: no certificates in CA bundle", ErrKubeConfig)
+	}
+	return pool, nil
+}
+
+// get выполняет GET к API-серверу; client — с таймаутом для list или без него для watch
+func (c *kubeClient) get(ctx context.Context, client *http.Client, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token := c.token
+	if c.tokenFile != "" {
+		b, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKubeConfig, err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,331 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errGone — resourceVersion устарел (HTTP 410), нужен полный перечит
+var errGone = errors.New("resource version too old")
+
+// KubernetesOptions — настройки обнаружения через EndpointSlice
+type KubernetesOptions struct {
+	// Kubeconfig — путь к kubeconfig; пустой — конфигурация пода (in-cluster)
+	Kubeconfig string
+	// Timeout — таймаут запроса списка, watch-поток живёт без таймаута
+	Timeout time.Duration
+	// Retry — пауза перед переподключением после ошибки
+	Retry time.Duration
+}
+
+func isKubernetes(raw string) bool {
+	return strings.HasPrefix(raw, "k8s://")
+}
+
+// Kubernetes следит за EndpointSlice сервиса через API-сервер (list + watch).
+// Готовые (ready) эндпоинты становятся бекендами, завершающиеся (terminating)
+// передаются с Draining и уходят в drain; остальные (ещё не готовые) пропускаются
+type Kubernetes struct {
+	raw       string
+	namespace string
+	service   string
+	port      string
+	scheme    string
+	kube      *kubeClient
+	opts      KubernetesOptions
+	log       *slog.Logger
+}
+
+// NewKubernetes разбирает цель вида k8s://namespace/service?port=http&scheme=https.
+// port — имя или номер порта в EndpointSlice, без него берётся первый порт
+func NewKubernetes(raw string, opts KubernetesOptions, log *slog.Logger) (*Kubernetes, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidTarget, raw, err)
+	}
+	service := strings.Trim(u.Path, "/")
+	if u.Host == "" || service == "" || strings.Contains(service, "/") {
+		return nil, fmt.Errorf("%w %q: want k8s://namespace/service", ErrInvalidTarget, raw)
+	}
+	scheme := u.Query().Get("scheme")
+	switch scheme {
+	case "":
+		scheme = "http"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("%w %q: scheme must be http or https", ErrInvalidTarget, raw)
+	}
+	kube, err := newKubeClient(opts.Kubeconfig, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Kubernetes{
+		raw:       raw,
+		namespace: u.Host,
+		service:   service,
+		port:      u.Query().Get("port"),
+		scheme:    scheme,
+		kube:      kube,
+		opts:      opts,
+		log:       log,
+	}, nil
+}
+
+func (k *Kubernetes) String() string {
+	return k.raw
+}
+
+func (k *Kubernetes) Watch(ctx context.Context, events chan<- []Event) error {
+	state, rv, err := k.list(ctx)
+	if err != nil {
+		return err
+	}
+	cur := k.instances(state)
+	if !emit(ctx, events, Diff(nil, cur)) {
+		return nil
+	}
+	for {
+		err := k.watch(ctx, state, &rv, func() bool {
+			next := k.instances(state)
+			batch := Diff(cur, next)
+			if len(batch) == 0 {
+				return true
+			}
+			cur = next
+			return emit(ctx, events, batch)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		// сервер штатно закрывает watch по timeoutSeconds — продолжаем с той же версии
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errGone) {
+			k.log.Error("kubernetes watch interrupted, resyncing", "target", k.raw, "error", err)
+			if !sleep(ctx, k.opts.Retry) {
+				return nil
+			}
+		}
+
+		next, nextRV, err := k.list(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				k.log.Error("kubernetes discovery failed, keeping previous backends", "target", k.raw, "error", err)
+				sleep(ctx, k.opts.Retry)
+			}
+			continue
+		}
+		state, rv = next, nextRV
+		nextList := k.instances(state)
+		if batch := Diff(cur, nextList); len(batch) > 0 {
+			if !emit(ctx, events, batch) {
+				return nil
+			}
+			cur = nextList
+		}
+	}
+}
+
+// endpointSlice — поля discovery.k8s.io/v1 EndpointSlice, нужные для состава
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			// nil у ready означает «готов», у terminating — «не завершается»
+			Ready       *bool `json:"ready"`
+			Terminating *bool `json:"terminating"`
+		} `json:"conditions"`
+		NodeName  string `json:"nodeName"`
+		Zone      string `json:"zone"`
+		TargetRef *struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"targetRef"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int    `json:"port"`
+	} `json:"ports"`
+}
+
+func (k *Kubernetes) path() string {
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(k.namespace) + "/endpointslices"
+}
+
+func (k *Kubernetes) selector() url.Values {
+	return url.Values{"labelSelector": {"kubernetes.io/service-name=" + k.service}}
+}
+
+// list читает все EndpointSlice сервиса и версию, с которой начинать watch
+func (k *Kubernetes) list(ctx context.Context) (map[string]endpointSlice, string, error) {
+	resp, err := k.kube.get(ctx, k.kube.client, k.path(), k.selector())
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrPoll, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%w: list endpointslices: status %d", ErrPoll, resp.StatusCode)
+	}
+	var body struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []endpointSlice `json:"items"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxListBytes)).Decode(&body); err != nil {
+		return nil, "", fmt.Errorf("%w: list endpointslices: %w", ErrPoll, err)
+	}
+	state := make(map[string]endpointSlice, len(body.Items))
+	for _, s := range body.Items {
+		state[s.Metadata.Name] = s
+	}
+	return state, body.Metadata.ResourceVersion, nil
+}
+
+// watch применяет события к state и вызывает onChange после каждого изменения.
+// Возвращает nil, если сервер закрыл поток, и errGone, если версия устарела
+func (k *Kubernetes) watch(ctx context.Context, state map[string]endpointSlice, rv *string, onChange func() bool) error {
+	query := k.selector()
+	query.Set("watch", "1")
+	query.Set("resourceVersion", *rv)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", "300")
+	resp, err := k.kube.get(ctx, k.kube.stream, k.path(), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errGone
+	default:
+		return fmt.Errorf("watch endpointslices: status %d", resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if ev.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Object, &status)
+			if status.Code == http.StatusGone {
+				return errGone
+			}
+			return fmt.Errorf("watch endpointslices: %d %s", status.Code, status.Message)
+		}
+		var s endpointSlice
+		if err := json.Unmarshal(ev.Object, &s); err != nil {
+			return fmt.Errorf("watch endpointslices: %w", err)
+		}
+		*rv = s.Metadata.ResourceVersion
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			state[s.Metadata.Name] = s
+		case "DELETED":
+			delete(state, s.Metadata.Name)
+		default:
+			// BOOKMARK только сдвигает версию
+			continue
+		}
+		if !onChange() {
+			return nil
+		}
+	}
+}
+
+// instances собирает состав из всех слайсов. Эндпоинт может временно оказаться в
+// двух слайсах — готовое состояние важнее завершающегося
+func (k *Kubernetes) instances(state map[string]endpointSlice) []Instance {
+	byURL := make(map[string]Instance)
+	for _, s := range state {
+		port, ok := k.pickPort(s)
+		if !ok {
+			if len(s.Endpoints) > 0 {
+				k.log.Warn("endpointslice has no matching port, skipped", "target", k.raw, "slice", s.Metadata.Name, "port", k.port)
+			}
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			if len(ep.Addresses) == 0 {
+				continue
+			}
+			ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			terminating := ep.Conditions.Terminating != nil && *ep.Conditions.Terminating
+			if !ready && !terminating {
+				continue
+			}
+			// все адреса эндпоинта взаимозаменяемы, потребители используют первый
+			in := Instance{
+				URL:      k.scheme + "://" + net.JoinHostPort(ep.Addresses[0], strconv.Itoa(port)),
+				Weight:   1,
+				Draining: terminating,
+				Metadata: map[string]string{},
+			}
+			if ep.NodeName != "" {
+				in.Metadata["node"] = ep.NodeName
+			}
+			if ep.Zone != "" {
+				in.Metadata["zone"] = ep.Zone
+			}
+			if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+				in.Metadata["pod"] = ep.TargetRef.Name
+			}
+			if was, dup := byURL[in.URL]; dup && !was.Draining {
+				continue
+			}
+			byURL[in.URL] = in
+		}
+	}
+	out := make([]Instance, 0, len(byURL))
+	for _, in := range byURL {
+		out = append(out, in)
+	}
+	slices.SortFunc(out, func(a, b Instance) int { return strings.Compare(a.URL, b.URL) })
+	return out
+}
+
+// pickPort выбирает порт слайса по имени или номеру из цели
+func (k *Kubernetes) pickPort(s endpointSlice) (int, bool) {
+	for _, p := range s.Ports {
+		if p.Port == nil {
+			continue
+		}
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		if k.port == "" || k.port == name || k.port == strconv.Itoa(*p.Port) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
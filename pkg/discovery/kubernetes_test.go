@@ -0,0 +1,234 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKubeAPI — API-сервер с list и watch EndpointSlice одного сервиса
+type fakeKubeAPI struct {
+	mu       sync.Mutex
+	rv       int
+	slices   map[string]map[string]any
+	watchers []chan map[string]any
+	lists    int
+	auth     string
+}
+
+func (f *fakeKubeAPI) setSlice(name string, endpoints ...map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rv++
+	typ := "MODIFIED"
+	if f.slices[name] == nil {
+		typ = "ADDED"
+	}
+	s := newSlice(name, f.rv, endpoints)
+	f.slices[name] = s
+	for _, w := range f.watchers {
+		w <- map[string]any{"type": typ, "object": s}
+	}
+}
+
+func newSlice(name string, rv int, endpoints []map[string]any) map[string]any {
+	return map[string]any{
+		"metadata":    map[string]any{"name": name, "resourceVersion": strconv.Itoa(rv)},
+		"addressType": "IPv4",
+		"endpoints":   endpoints,
+		"ports":       []any{map[string]any{"name": "metrics", "port": 9090}, map[string]any{"name": "http", "port": 8080}},
+	}
+}
+
+// expire закрывает watch ошибкой 410, как API-сервер после компакции etcd
+func (f *fakeKubeAPI) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, w := range f.watchers {
+		w <- map[string]any{"type": "ERROR", "object": map[string]any{"kind": "Status", "code": 410, "message": "too old resource version"}}
+	}
+}
+
+func (f *fakeKubeAPI) watching() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.watchers)
+}
+
+func (f *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices" ||
+		r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=api" {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	f.auth = r.Header.Get("Authorization")
+	if r.URL.Query().Get("watch") == "" {
+		f.lists++
+		items := make([]any, 0, len(f.slices))
+		for _, s := range f.slices {
+			items = append(items, s)
+		}
+		rv := strconv.Itoa(f.rv)
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"metadata": map[string]any{"resourceVersion": rv}, "items": items})
+		return
+	}
+	ch := make(chan map[string]any, 16)
+	f.watchers = append(f.watchers, ch)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		for i, c := range f.watchers {
+			if c == ch {
+				f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+			}
+		}
+		f.mu.Unlock()
+	}()
+	w.(http.Flusher).Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-ch:
+			enc.Encode(ev)
+			w.(http.Flusher).Flush()
+			if ev["type"] == "ERROR" {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func endpoint(ip string, ready, terminating bool) map[string]any {
+	return map[string]any{
+		"addresses":  []string{ip},
+		"conditions": map[string]any{"ready": ready, "serving": ready || terminating, "terminating": terminating},
+		"nodeName":   "node-1",
+		"zone":       "eu-1a",
+		"targetRef":  map[string]any{"kind": "Pod", "name": "api-" + ip},
+	}
+}
+
+// writeKubeconfig пишет kubeconfig для TLS-сервера srv с токеном
+func writeKubeconfig(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: other
+  context: {cluster: other, user: other}
+- name: test
+  context: {cluster: test, user: test}
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: test
+  user:
+    token: secret-token
+`, srv.URL, base64.StdEncoding.EncodeToString(ca))
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubernetes_WatchesEndpointSlices(t *testing.T) {
+	fake := &fakeKubeAPI{slices: make(map[string]map[string]any)}
+	fake.setSlice("api-abc", endpoint("10.0.0.1", true, false), endpoint("10.0.0.2", false, true), endpoint("10.0.0.3", false, false))
+	srv := httptest.NewTLSServer(fake)
+	t.Cleanup(srv.Close)
+
+	opts := KubernetesOptions{Kubeconfig: writeKubeconfig(t, srv), Timeout: time.Second, Retry: 10 * time.Millisecond}
+	k, err := NewKubernetes("k8s://prod/api?port=http", opts, testLogger)
+	if err != nil {
+		t.Fatalf("NewKubernetes: %v", err)
+	}
+	c := watch(t, k)
+	// ready — бекенд, terminating — в drain, не готовый — пропущен; порт выбран по имени
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 1}, Instance{URL: "http://10.0.0.2:8080", Weight: 1})
+	c.mu.Lock()
+	ready, terminating := c.state["http://10.0.0.1:8080"], c.state["http://10.0.0.2:8080"]
+	c.mu.Unlock()
+	if ready.Draining || !terminating.Draining {
+		t.Errorf("draining: ready = %v, terminating = %v; want false, true", ready.Draining, terminating.Draining)
+	}
+	if ready.Metadata["pod"] != "api-10.0.0.1" || ready.Metadata["zone"] != "eu-1a" || ready.Metadata["node"] != "node-1" {
+		t.Errorf("metadata = %v; want pod, zone and node", ready.Metadata)
+	}
+	fake.mu.Lock()
+	auth := fake.auth
+	fake.mu.Unlock()
+	if auth != "Bearer secret-token" {
+		t.Errorf("Authorization = %q; want bearer token from kubeconfig", auth)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for fake.watching() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("watch was not opened")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fake.setSlice("api-abc", endpoint("10.0.0.1", true, false), endpoint("10.0.0.3", true, false))
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 1}, Instance{URL: "http://10.0.0.3:8080", Weight: 1})
+
+	// изменение, пропущенное watch, подхватывается перечитыванием списка после 410
+	fake.mu.Lock()
+	fake.rv++
+	fake.slices["api-def"] = newSlice("api-def", fake.rv, []map[string]any{endpoint("10.0.0.4", true, false)})
+	fake.mu.Unlock()
+	fake.expire()
+	c.waitFor(t, Instance{URL: "http://10.0.0.1:8080", Weight: 1}, Instance{URL: "http://10.0.0.3:8080", Weight: 1}, Instance{URL: "http://10.0.0.4:8080", Weight: 1})
+}
+
+func TestKubernetes_Config(t *testing.T) {
+	for _, raw := range []string{"k8s://prod", "k8s:///api", "k8s://prod/api/x", "k8s://prod/api?scheme=ftp"} {
+		if _, err := NewKubernetes(raw, KubernetesOptions{}, testLogger); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("NewKubernetes(%q): err = %v; want ErrInvalidTarget", raw, err)
+		}
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := NewKubernetes("k8s://prod/api", KubernetesOptions{}, testLogger); !errors.Is(err, ErrKubeConfig) {
+		t.Errorf("outside cluster without kubeconfig: err = %v; want ErrKubeConfig", err)
+	}
+
+	// в поде адрес берётся из окружения, CA и токен — из сервисного аккаунта
+	dir := t.TempDir()
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0o600)
+	os.WriteFile(filepath.Join(dir, "token"), []byte("pod-token\n"), 0o600)
+	old := serviceAccountDir
+	serviceAccountDir = dir
+	t.Cleanup(func() { serviceAccountDir = old })
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	k, err := NewKubernetes("k8s://prod/api", KubernetesOptions{}, testLogger)
+	if err != nil {
+		t.Fatalf("NewKubernetes in cluster: %v", err)
+	}
+	if k.kube.server != "https://10.96.0.1:443" || k.kube.tokenFile != filepath.Join(dir, "token") {
+		t.Errorf("in-cluster client = %s, %s", k.kube.server, k.kube.tokenFile)
+	}
+}
@@ -0,0 +1,351 @@
+package forwarded
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type Mode string
+
+const (
+	// Append — дописываем текущий хоп к цепочке, пришедшей от доверенного прокси
+	Append Mode = "append"
+	// Overwrite — схлопываем цепочку до реального клиента
+	Overwrite Mode = "overwrite"
+)
+
+// Source — заголовок, из которого берётся цепочка клиентов от доверенного прокси
+type Source string
+
+const (
+	// SourceXFF — X-Forwarded-For
+	SourceXFF Source = "xff"
+	// SourceForwarded — Forwarded (RFC 7239)
+	SourceForwarded Source = "forwarded"
+)
+
+const (
+	HeaderXForwardedFor   = "X-Forwarded-For"
+	HeaderXForwardedProto = "X-Forwarded-Proto"
+	HeaderXForwardedHost  = "X-Forwarded-Host"
+	HeaderForwarded       = "Forwarded"
+)
+
+var (
+	ErrInvalidProxy  = errors.New("invalid trusted proxy")
+	ErrInvalidMode   = errors.New("invalid forwarded headers mode")
+	ErrInvalidSource = errors.New("invalid trusted header")
+)
+
+// TrustedProxies — список подсетей, заголовкам от которых мы доверяем.
+// Цепочка клиентов читается только из одного заголовка source (по умолчанию
+// X-Forwarded-For): второй прокси мог пропустить от клиента как есть
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	source   Source
+}
+
+// ParseTrustedProxies разбирает список CIDR. Одиночный IP трактуется как /32 (/128)
+func ParseTrustedProxies(cidrs []string) (*TrustedProxies, error) {
+	t := &TrustedProxies{prefixes: make([]netip.Prefix, 0, len(cidrs))}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if strings.Contains(c, "/") {
+			p, err := netip.ParsePrefix(c)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %w", ErrInvalidProxy, c, err)
+			}
+			t.prefixes = append(t.prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidProxy, c, err)
+		}
+		addr = addr.Unmap()
+		t.prefixes = append(t.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return t, nil
+}
+
+// Contains сообщает, входит ли адрес в одну из доверенных подсетей
+func (t *TrustedProxies) Contains(addr netip.Addr) bool {
+	if t == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range t.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP возвращает IP реального клиента. Если непосредственный пир доверенный,
+// идём по цепочке из доверенного заголовка справа налево и берём первый
+// недоверенный хоп
+func (t *TrustedProxies) ClientIP(r *http.Request) string {
+	peer := RemoteIP(r)
+	if !t.Contains(peer) {
+		if !peer.IsValid() {
+			return r.RemoteAddr
+		}
+		return peer.String()
+	}
+
+	chain := t.chain(r.Header)
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := parseNode(chain[i])
+		if err != nil {
+			// дальше неизвестного или обфусцированного хопа идти нельзя
+			break
+		}
+		client = addr
+		if !t.Contains(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// chain возвращает цепочку хопов из доверенного заголовка слева направо
+func (t *TrustedProxies) chain(h http.Header) []string {
+	if t != nil && t.source == SourceForwarded {
+		chain, _ := forwardedFor(h)
+		return chain
+	}
+	return xForwardedFor(h)
+}
+
+// Policy описывает, как выставлять X-Forwarded-* и Forwarded на проксируемых запросах
+type Policy struct {
+	Trusted *TrustedProxies
+	Mode    Mode
+}
+
+func NewPolicy(cidrs []string, mode, header string) (*Policy, error) {
+	trusted, err := ParseTrustedProxies(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	switch trusted.source = Source(header); trusted.source {
+	case "":
+		trusted.source = SourceXFF
+	case SourceXFF, SourceForwarded:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSource, header)
+	}
+	m := Mode(mode)
+	switch m {
+	case "":
+		m = Append
+	case Append, Overwrite:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMode, mode)
+	}
+	return &Policy{Trusted: trusted, Mode: m}, nil
+}
+
+// ClientIP — см. TrustedProxies.ClientIP. Безопасен для nil
+func (p *Policy) ClientIP(r *http.Request) string {
+	if p == nil {
+		return (*TrustedProxies)(nil).ClientIP(r)
+	}
+	return p.Trusted.ClientIP(r)
+}
+
+// Apply выставляет заголовки на исходящем запросе out по входящему in.
+// Заголовки от недоверенного пира всегда отбрасываются. Безопасен для nil
+func (p *Policy) Apply(out, in *http.Request) {
+	var (
+		trusted *TrustedProxies
+		mode    = Append
+	)
+	if p != nil {
+		trusted, mode = p.Trusted, p.Mode
+	}
+
+	peer := RemoteIP(in)
+	fromTrusted := trusted.Contains(peer)
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	host := in.Host
+	if fromTrusted {
+		if v := in.Header.Get(HeaderXForwardedProto); v != "" {
+			proto = v
+		}
+		if v := in.Header.Get(HeaderXForwardedHost); v != "" {
+			host = v
+		}
+	}
+
+	out.Header.Del(HeaderXForwardedFor)
+	out.Header.Del(HeaderForwarded)
+	out.Header.Set(HeaderXForwardedProto, proto)
+	if host != "" {
+		out.Header.Set(HeaderXForwardedHost, host)
+	} else {
+		out.Header.Del(HeaderXForwardedHost)
+	}
+
+	if fromTrusted && mode == Append {
+		// оба заголовка продолжают цепочку из доверенного, второй из входящих отбрасывается
+		chain := trusted.chain(in.Header)
+		xff := append(chain, peer.String())
+		out.Header.Set(HeaderXForwardedFor, strings.Join(xff, ", "))
+
+		var fwd []string
+		if trusted.source == SourceForwarded {
+			fwd = in.Header.Values(HeaderForwarded)
+		} else {
+			for _, node := range chain {
+				fwd = append(fwd, "for="+forNode(node))
+			}
+		}
+		fwd = append(fwd, element(peer.String(), host, proto))
+		out.Header.Set(HeaderForwarded, strings.Join(fwd, ", "))
+		return
+	}
+
+	client := trusted.ClientIP(in)
+	out.Header.Set(HeaderXForwardedFor, client)
+	out.Header.Set(HeaderForwarded, element(client, host, proto))
+}
+
+// RemoteIP возвращает адрес непосредственного пира из RemoteAddr
+func RemoteIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// element собирает один элемент заголовка Forwarded (RFC 7239)
+func element(forIP, host, proto string) string {
+	var b strings.Builder
+	b.WriteString("for=" + forNode(forIP))
+	if host != "" {
+		b.WriteString(";host=" + quoteIfNeeded(host))
+	}
+	b.WriteString(";proto=" + quoteIfNeeded(proto))
+	return b.String()
+}
+
+// forNode оформляет адрес хопа для for=: IPv6 в скобках и кавычках
+func forNode(node string) string {
+	if addr, err := netip.ParseAddr(node); err == nil && addr.Is6() {
+		return `"[` + node + `]"`
+	}
+	return quoteIfNeeded(node)
+}
+
+func quoteIfNeeded(v string) string {
+	if strings.ContainsAny(v, `:;,"[] `) {
+		return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	}
+	return v
+}
+
+// xForwardedFor возвращает все адреса из X-Forwarded-For слева направо
+func xForwardedFor(h http.Header) []string {
+	var chain []string
+	for _, v := range h.Values(HeaderXForwardedFor) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				chain = append(chain, part)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedFor возвращает значения for= из всех элементов Forwarded слева направо.
+// ok=false, если заголовка нет
+func forwardedFor(h http.Header) (chain []string, ok bool) {
+	values := h.Values(HeaderForwarded)
+	if len(values) == 0 {
+		return nil, false
+	}
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			forValue := ""
+			for _, pair := range splitQuoted(elem, ';') {
+				k, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(k, "for") {
+					forValue = unquote(strings.TrimSpace(val))
+				}
+			}
+			// элемент без for= рвёт цепочку так же, как unknown
+			if forValue == "" {
+				forValue = "unknown"
+			}
+			chain = append(chain, forValue)
+		}
+	}
+	return chain, true
+}
+
+// parseNode разбирает адрес хопа: "1.2.3.4", "1.2.3.4:80", "[::1]", "[::1]:80", "::1"
+func parseNode(node string) (netip.Addr, error) {
+	node = strings.TrimSpace(node)
+	if addr, err := netip.ParseAddr(node); err == nil {
+		return addr.Unmap(), nil
+	}
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// splitQuoted делит строку по sep, не заходя внутрь кавычек
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts   []string
+		quoted  bool
+		escaped bool
+		start   int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+	}
+	return v
+}
@@ -0,0 +1,173 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newRequest(remote string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://lb.example/", nil)
+	r.RemoteAddr = remote
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func mustPolicy(t *testing.T, cidrs []string, mode, header string) *Policy {
+	t.Helper()
+	p, err := NewPolicy(cidrs, mode, header)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return p
+}
+
+func TestParseTrustedProxies_Invalid(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected error for bad CIDR")
+	}
+	if _, err := ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("expected error for bad IP")
+	}
+	if _, err := NewPolicy(nil, "prepend", ""); err == nil {
+		t.Error("expected error for bad mode")
+	}
+	if _, err := NewPolicy(nil, "append", "x-real-ip"); err == nil {
+		t.Error("expected error for bad trusted header")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	xff := mustPolicy(t, []string{"10.0.0.0/8", "192.168.1.1"}, "", "")
+	fwd := mustPolicy(t, []string{"10.0.0.0/8", "192.168.1.1"}, "", "forwarded")
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores XFF", xff, "203.0.113.5:1234",
+			map[string]string{HeaderXForwardedFor: "1.1.1.1"}, "203.0.113.5"},
+		{"trusted peer without headers", xff, "10.1.1.1:1234", nil, "10.1.1.1"},
+		{"rightmost untrusted XFF hop", xff, "10.1.1.1:1234",
+			map[string]string{HeaderXForwardedFor: "6.6.6.6, 1.1.1.1, 192.168.1.1"}, "1.1.1.1"},
+		{"all XFF hops trusted", xff, "10.1.1.1:1234",
+			map[string]string{HeaderXForwardedFor: "10.2.2.2, 10.3.3.3"}, "10.2.2.2"},
+		{"Forwarded from trusted header", fwd, "10.1.1.1:1234",
+			map[string]string{
+				HeaderForwarded:     `for=2.2.2.2;proto=https, for="[2001:db8::1]:4711"`,
+				HeaderXForwardedFor: "1.1.1.1",
+			}, "2001:db8::1"},
+		{"unknown hop stops walk", fwd, "10.1.1.1:1234",
+			map[string]string{HeaderForwarded: "for=3.3.3.3, for=unknown, for=10.2.2.2"}, "10.2.2.2"},
+		// прокси пишет только X-Forwarded-For, а Forwarded клиента пропускает как есть
+		{"spoofed Forwarded ignored", xff, "10.1.1.1:1234",
+			map[string]string{
+				HeaderForwarded:     "for=6.6.6.6",
+				HeaderXForwardedFor: "1.1.1.1",
+			}, "1.1.1.1"},
+		{"spoofed XFF ignored", fwd, "10.1.1.1:1234",
+			map[string]string{
+				HeaderForwarded:     "for=1.1.1.1",
+				HeaderXForwardedFor: "6.6.6.6",
+			}, "1.1.1.1"},
+		{"spoofed Forwarded without XFF", xff, "10.1.1.1:1234",
+			map[string]string{HeaderForwarded: "for=6.6.6.6"}, "10.1.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ClientIP(newRequest(tt.remote, tt.headers)); got != tt.want {
+				t.Errorf("ClientIP = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply_AppendTrusted(t *testing.T) {
+	p := mustPolicy(t, []string{"10.0.0.0/8"}, "append", "forwarded")
+	in := newRequest("10.1.1.1:1234", map[string]string{
+		HeaderXForwardedFor:   "1.1.1.1",
+		HeaderXForwardedProto: "https",
+		HeaderXForwardedHost:  "public.example",
+		HeaderForwarded:       "for=1.1.1.1;proto=https",
+	})
+	out := in.Clone(in.Context())
+
+	p.Apply(out, in)
+
+	if got := out.Header.Get(HeaderXForwardedFor); got != "1.1.1.1, 10.1.1.1" {
+		t.Errorf("X-Forwarded-For = %q", got)
+	}
+	if got := out.Header.Get(HeaderXForwardedProto); got != "https" {
+		t.Errorf("X-Forwarded-Proto = %q", got)
+	}
+	if got := out.Header.Get(HeaderXForwardedHost); got != "public.example" {
+		t.Errorf("X-Forwarded-Host = %q", got)
+	}
+	want := "for=1.1.1.1;proto=https, for=10.1.1.1;host=public.example;proto=https"
+	if got := out.Header.Get(HeaderForwarded); got != want {
+		t.Errorf("Forwarded = %q; want %q", got, want)
+	}
+}
+
+func TestApply_AppendCarriesXFFChain(t *testing.T) {
+	p := mustPolicy(t, []string{"10.0.0.0/8"}, "append", "xff")
+	in := newRequest("10.1.1.1:1234", map[string]string{
+		HeaderXForwardedFor: "1.1.1.1, 2001:db8::1",
+		HeaderForwarded:     "for=6.6.6.6",
+	})
+	out := in.Clone(in.Context())
+
+	p.Apply(out, in)
+
+	if got := out.Header.Get(HeaderXForwardedFor); got != "1.1.1.1, 2001:db8::1, 10.1.1.1" {
+		t.Errorf("X-Forwarded-For = %q", got)
+	}
+	want := `for=1.1.1.1, for="[2001:db8::1]", for=10.1.1.1;host=lb.example;proto=http`
+	if got := out.Header.Get(HeaderForwarded); got != want {
+		t.Errorf("Forwarded = %q; want %q", got, want)
+	}
+}
+
+func TestApply_Overwrite(t *testing.T) {
+	p := mustPolicy(t, []string{"10.0.0.0/8"}, "overwrite", "")
+	in := newRequest("10.1.1.1:1234", map[string]string{
+		HeaderXForwardedFor: "1.1.1.1, 10.2.2.2",
+	})
+	out := in.Clone(in.Context())
+
+	p.Apply(out, in)
+
+	if got := out.Header.Get(HeaderXForwardedFor); got != "1.1.1.1" {
+		t.Errorf("X-Forwarded-For = %q", got)
+	}
+	if got := out.Header.Get(HeaderForwarded); got != "for=1.1.1.1;host=lb.example;proto=http" {
+		t.Errorf("Forwarded = %q", got)
+	}
+}
+
+func TestApply_UntrustedDropsSpoofed(t *testing.T) {
+	var p *Policy
+	in := newRequest("[2001:db8::5]:1234", map[string]string{
+		HeaderXForwardedFor:   "1.1.1.1",
+		HeaderXForwardedProto: "https",
+		HeaderForwarded:       "for=1.1.1.1",
+	})
+	out := in.Clone(in.Context())
+
+	p.Apply(out, in)
+
+	if got := out.Header.Get(HeaderXForwardedFor); got != "2001:db8::5" {
+		t.Errorf("X-Forwarded-For = %q", got)
+	}
+	if got := out.Header.Get(HeaderXForwardedProto); got != "http" {
+		t.Errorf("X-Forwarded-Proto = %q", got)
+	}
+	if got := out.Header.Get(HeaderForwarded); got != `for="[2001:db8::5]";host=lb.example;proto=http` {
+		t.Errorf("Forwarded = %q", got)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/P1coFly/LoadBalancer/pkg/backends/state"
+)
+
+// BackendManager — пул, состав которого можно менять на лету
+type BackendManager interface {
+	Backends() []state.Backend
+	AddBackend(rawURL string) (state.Backend, error)
+	RemoveBackend(id string) error
+	SetDraining(id string, draining bool) (state.Backend, error)
+}
+
+// BackendPools ищет пул по имени
+type BackendPools interface {
+	Pool(name string) (BackendManager, bool)
+}
+
+// PoolMap — неизменяемый набор пулов
+type PoolMap map[string]BackendManager
+
+func (m PoolMap) Pool(name string) (BackendManager, bool) {
+	p, ok := m[name]
+	return p, ok
+}
+
+type addBackendRequest struct {
+	URL string `json:"url"`
+}
+
+type drainRequest struct {
+	Draining bool `json:"draining"`
+}
+
+// BackendHandler хранит пулы по именам и логгер
+type BackendHandler struct {
+	Pools  BackendPools
+	Logger *slog.Logger
+}
+
+// pool возвращает пул из ?pool=…, по умолчанию — "default"
+func (h *BackendHandler) pool(w http.ResponseWriter, r *http.Request) (BackendManager, bool) {
+	name := r.URL.Query().Get("pool")
+	if name == "" {
+		name = "default"
+	}
+	p, ok := h.Pools.Pool(name)
+	if !ok {
+		h.Logger.Error("Backends - unknown pool", "pool", name)
+		SendJSONError(w, http.StatusNotFound, "pool not found")
+	}
+	return p, ok
+}
+
+// GET /admin/backends?pool=…
+func (h *BackendHandler) List(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.pool(w, r)
+	if !ok {
+		return
+	}
+	h.sendJSON(w, http.StatusOK, p.Backends(), "List backends")
+}
+
+// POST /admin/backends?pool=…
+func (h *BackendHandler) Add(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.pool(w, r)
+	if !ok {
+		return
+	}
+	var req addBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.Logger.Error("Add backend - can't decode body", "err", err)
+		SendJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.URL == "" {
+		h.Logger.Error("Add backend - no url in body")
+		SendJSONError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	state, err := p.AddBackend(req.URL)
+	if err != nil {
+		h.sendError(w, err, "Add backend")
+		return
+	}
+	h.sendJSON(w, http.StatusCreated, state, "Add backend")
+}
+
+// PUT /admin/backends?pool=…&url=… — включает или выключает drain-режим
+func (h *BackendHandler) Drain(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.pool(w, r)
+	if !ok {
+		return
+	}
+	id := r.URL.Query().Get("url")
+	if id == "" {
+		h.Logger.Error("Drain backend - no url in query")
+		SendJSONError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	var req drainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.Logger.Error("Drain backend - can't decode body", "err", err)
+		SendJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	state, err := p.SetDraining(id, req.Draining)
+	if err != nil {
+		h.sendError(w, err, "Drain backend")
+		return
+	}
+	h.sendJSON(w, http.StatusOK, state, "Drain backend")
+}
+
+// DELETE /admin/backends?pool=…&url=…
+func (h *BackendHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.pool(w, r)
+	if !ok {
+		return
+	}
+	id := r.URL.Query().Get("url")
+	if id == "" {
+		h.Logger.Error("Remove backend - no url in query")
+		SendJSONError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	if err := p.RemoveBackend(id); err != nil {
+		h.sendError(w, err, "Remove backend")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BackendHandler) sendError(w http.ResponseWriter, err error, op string) {
+	h.Logger.Error(op, "err", err)
+	switch {
+	case errors.Is(err, state.ErrNotFound):
+		SendJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, state.ErrExists):
+		SendJSONError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, state.ErrInvalid):
+		SendJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		SendJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (h *BackendHandler) sendJSON(w http.ResponseWriter, code int, v any, op string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.Logger.Error(op+" - fail to send response", "err", err)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// CachePurger — кеш, из которого можно удалять записи
+type CachePurger interface {
+	Purge(prefix string) int
+}
+
+type purgeResponse struct {
+	Prefix string `json:"prefix"`
+	Purged int    `json:"purged"`
+}
+
+// CacheHandler хранит кеш и логгер
+type CacheHandler struct {
+	Cache  CachePurger
+	Logger *slog.Logger
+}
+
+// DELETE /admin/cache?prefix=… — без prefix очищает весь кеш
+func (h *CacheHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	resp := purgeResponse{
+		Prefix: prefix,
+		Purged: h.Cache.Purge(prefix),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Logger.Error("Purge cache - fail to send purgeResponse", "err", err)
+		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
+	}
+}
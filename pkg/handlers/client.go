@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 
@@ -10,25 +11,89 @@ import (
 
 const (
 	ErrNoClient = "client not found"
+	ErrNoTenant = "tenant not found"
 )
 
 type clientRequest struct {
 	ClientID string `json:"client_id"`
-	Capacity int    `json:"capacity"`
-	RPS      int    `json:"rate_per_sec"`
+	// Algorithm — token_bucket (по умолчанию), sliding_log, sliding_window, gcra или leaky_bucket.
+	// В PUT отсутствующие поля лимита берутся у клиента, без них всех лимит не меняется
+	Algorithm *string  `json:"algorithm"`
+	Capacity  *int     `json:"capacity"`
+	RPS       *float64 `json:"rate_per_sec"`
+	// Tenant — организация клиента. В PUT отсутствие поля оставляет прежнюю, "" — снимает
+	Tenant *string `json:"tenant"`
 }
 
 type clientResponse struct {
-	ClientID      string `json:"client_id"`
-	Capacity      int    `json:"capacity"`
-	CurrentTokens int    `json:"current_tokens"`
-	RPS           int    `json:"rate_per_sec"`
+	ClientID  string `json:"client_id"`
+	Algorithm string `json:"algorithm"`
+	Capacity  int    `json:"capacity"`
+	// CurrentTokens — сколько запросов пройдёт сейчас, для любого алгоритма
+	CurrentTokens int     `json:"current_tokens"`
+	RPS           float64 `json:"rate_per_sec"`
+	Tenant        string  `json:"tenant,omitempty"`
+}
+
+func newClientResponse(cl *client.Client) clientResponse {
+	algorithm := cl.Limit.Algorithm
+	if algorithm == "" {
+		algorithm = client.AlgorithmTokenBucket
+	}
+	return clientResponse{
+		ClientID:      cl.ID,
+		Algorithm:     string(algorithm),
+		Capacity:      cl.Limit.Capacity,
+		CurrentTokens: cl.Remaining,
+		RPS:           cl.Limit.RPS,
+		Tenant:        cl.Tenant,
+	}
+}
+
+// hasLimit сообщает, задано ли в запросе хоть одно поле лимита
+func (req clientRequest) hasLimit() bool {
+	return req.Algorithm != nil || req.Capacity != nil || req.RPS != nil
+}
+
+// limit проверяет алгоритм из запроса и накладывает заданные поля на base
+func (req clientRequest) limit(base client.Limit) (client.Limit, error) {
+	if req.Algorithm != nil {
+		algorithm, err := client.ParseAlgorithm(*req.Algorithm)
+		if err != nil {
+			return client.Limit{}, err
+		}
+		base.Algorithm = algorithm
+	}
+	if req.Capacity != nil {
+		base.Capacity = *req.Capacity
+	}
+	if req.RPS != nil {
+		base.RPS = *req.RPS
+	}
+	return base, nil
 }
 
 // ClientHandler хранит репо и логгер
 type ClientHandler struct {
-	Repo   client.ClientRepo
-	Logger *slog.Logger
+	Repo client.ClientRepo
+	// Tenants — организации, к которым можно привязать клиента; nil — без проверки
+	Tenants client.ClientRepo
+	Logger  *slog.Logger
+}
+
+// sendRepoError отвечает на ошибку изменения репозитория: ErrNoClient — 404 с notFound,
+// остальные — хранилище не приняло изменение — 503
+func sendRepoError(w http.ResponseWriter, err error, notFound string) {
+	if errors.Is(err, client.ErrNoClient) {
+		SendJSONError(w, http.StatusNotFound, notFound)
+		return
+	}
+	SendJSONError(w, http.StatusServiceUnavailable, "fail to save changes")
+}
+
+// tenantExists проверяет организацию из запроса; отсутствующая или пустая — не проверяется
+func (h *ClientHandler) tenantExists(tenant *string) bool {
+	return tenant == nil || *tenant == "" || h.Tenants == nil || h.Tenants.GetClient(*tenant) != nil
 }
 
 // POST /clients
@@ -44,6 +109,17 @@ func (h *ClientHandler) Create(w http.ResponseWriter, r *http.Request) {
 		SendJSONError(w, http.StatusBadRequest, "client_id is required")
 		return
 	}
+	limit, err := req.limit(client.Limit{})
+	if err != nil {
+		h.Logger.Error("Create client - invalid algorithm", "err", err)
+		SendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.tenantExists(req.Tenant) {
+		h.Logger.Error("Create client", "err", ErrNoTenant, "tenant", *req.Tenant)
+		SendJSONError(w, http.StatusBadRequest, ErrNoTenant)
+		return
+	}
 	cl := h.Repo.GetClient(req.ClientID)
 	if cl != nil {
 		h.Logger.Error("Create client - already exist")
@@ -51,13 +127,16 @@ func (h *ClientHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cl = h.Repo.AddClient(req.ClientID, req.Capacity, req.RPS)
-	resp := clientResponse{
-		ClientID:      cl.ID,
-		Capacity:      cl.TokenBucket.Capacity,
-		CurrentTokens: cl.TokenBucket.CurrentTokens,
-		RPS:           cl.TokenBucket.RPS,
+	tenant := ""
+	if req.Tenant != nil {
+		tenant = *req.Tenant
+	}
+	if cl, err = h.Repo.AddClient(req.ClientID, limit, tenant); err != nil {
+		h.Logger.Error("Create client", "err", err)
+		sendRepoError(w, err, ErrNoClient)
+		return
 	}
+	resp := newClientResponse(cl)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -80,12 +159,7 @@ func (h *ClientHandler) Get(w http.ResponseWriter, r *http.Request) {
 		SendJSONError(w, http.StatusNotFound, ErrNoClient)
 		return
 	}
-	resp := clientResponse{
-		ClientID:      cl.ID,
-		Capacity:      cl.TokenBucket.Capacity,
-		CurrentTokens: cl.TokenBucket.CurrentTokens,
-		RPS:           cl.TokenBucket.RPS,
-	}
+	resp := newClientResponse(cl)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		h.Logger.Error("Get client - fail to send clientResponse", "err", err)
 		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
@@ -105,18 +179,40 @@ func (h *ClientHandler) Update(w http.ResponseWriter, r *http.Request) {
 		SendJSONError(w, http.StatusBadRequest, "client_id is required")
 		return
 	}
-	cl, err := h.Repo.UpdateClient(req.ClientID, req.Capacity, req.RPS)
-	if err != nil {
+	if _, err := req.limit(client.Limit{}); err != nil {
+		h.Logger.Error("Update client - invalid algorithm", "err", err)
+		SendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.tenantExists(req.Tenant) {
+		h.Logger.Error("Update client", "err", ErrNoTenant, "tenant", *req.Tenant)
+		SendJSONError(w, http.StatusBadRequest, ErrNoTenant)
+		return
+	}
+	cl := h.Repo.GetClient(req.ClientID)
+	if cl == nil {
 		h.Logger.Error("Update client", "err", ErrNoClient)
 		SendJSONError(w, http.StatusNotFound, ErrNoClient)
 		return
 	}
-	resp := clientResponse{
-		ClientID:      cl.ID,
-		Capacity:      cl.TokenBucket.Capacity,
-		CurrentTokens: cl.TokenBucket.CurrentTokens,
-		RPS:           cl.TokenBucket.RPS,
-	}
+	// UpdateClient сбрасывает состояние лимита, поэтому вызывается, только если лимит в запросе есть
+	var err error
+	if req.hasLimit() {
+		limit, _ := req.limit(cl.Limit)
+		if cl, err = h.Repo.UpdateClient(req.ClientID, limit); err != nil {
+			h.Logger.Error("Update client", "err", err)
+			sendRepoError(w, err, ErrNoClient)
+			return
+		}
+	}
+	if req.Tenant != nil {
+		if cl, err = h.Repo.SetTenant(req.ClientID, *req.Tenant); err != nil {
+			h.Logger.Error("Update client - can't set tenant", "err", err)
+			sendRepoError(w, err, ErrNoClient)
+			return
+		}
+	}
+	resp := newClientResponse(cl)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		h.Logger.Error("Update client - fail to send clientResponse", "err", err)
 		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
@@ -132,8 +228,8 @@ func (h *ClientHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.Repo.DeleteClient(id); err != nil {
-		h.Logger.Error("Delete client", "err", ErrNoClient)
-		SendJSONError(w, http.StatusNotFound, ErrNoClient)
+		h.Logger.Error("Delete client", "err", err)
+		sendRepoError(w, err, ErrNoClient)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"log/slog"
@@ -73,7 +74,7 @@ func TestCreate_MissingID(t *testing.T) {
 func TestCreate_Conflict(t *testing.T) {
 	h, repo := newTestHandler()
 	// заранее создаём клиента
-	repo.AddClient("u1", 3, 1)
+	repo.AddClient("u1", client.Limit{Capacity: 3, RPS: 1}, "")
 
 	body := `{"client_id":"u1","capacity":5,"rate_per_sec":1}`
 	req := httptest.NewRequest(http.MethodPost, "/clients", bytes.NewBufferString(body))
@@ -88,7 +89,7 @@ func TestCreate_Conflict(t *testing.T) {
 
 func TestGet_Success(t *testing.T) {
 	h, repo := newTestHandler()
-	repo.AddClient("u2", 7, 2)
+	repo.AddClient("u2", client.Limit{Capacity: 7, RPS: 2}, "")
 
 	req := httptest.NewRequest(http.MethodGet, "/clients?client_id=u2", nil)
 	rr := httptest.NewRecorder()
@@ -133,7 +134,7 @@ func TestGet_NotFound(t *testing.T) {
 
 func TestUpdate_Success(t *testing.T) {
 	h, repo := newTestHandler()
-	repo.AddClient("u3", 4, 1)
+	repo.AddClient("u3", client.Limit{Capacity: 4, RPS: 1}, "")
 
 	body := `{"client_id":"u3","capacity":10,"rate_per_sec":5}`
 	req := httptest.NewRequest(http.MethodPut, "/clients", bytes.NewBufferString(body))
@@ -193,7 +194,7 @@ func TestUpdate_NotFound(t *testing.T) {
 
 func TestDelete_Success(t *testing.T) {
 	h, repo := newTestHandler()
-	repo.AddClient("u4", 3, 1)
+	repo.AddClient("u4", client.Limit{Capacity: 3, RPS: 1}, "")
 
 	req := httptest.NewRequest(http.MethodDelete, "/clients?client_id=u4", nil)
 	rr := httptest.NewRecorder()
@@ -228,3 +229,163 @@ func TestDelete_NotFound(t *testing.T) {
 		t.Errorf("want %d, got %d", http.StatusNotFound, rr.Code)
 	}
 }
+
+func TestCreate_Algorithm(t *testing.T) {
+	h, repo := newTestHandler()
+	body := `{"client_id":"u5","algorithm":"gcra","capacity":5,"rate_per_sec":1}`
+	req := httptest.NewRequest(http.MethodPost, "/clients", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+
+	h.Create(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want %d, got %d", http.StatusCreated, rr.Code)
+	}
+	var resp clientResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Algorithm != "gcra" || resp.CurrentTokens != 5 {
+		t.Errorf("unexpected resp: %+v", resp)
+	}
+	if cl := repo.GetClient("u5"); cl == nil || cl.Limit.Algorithm != client.AlgorithmGCRA {
+		t.Errorf("stored client = %+v; want gcra", cl)
+	}
+}
+
+func TestCreate_InvalidAlgorithm(t *testing.T) {
+	h, repo := newTestHandler()
+	body := `{"client_id":"u6","algorithm":"fixed_window","capacity":5,"rate_per_sec":1}`
+	req := httptest.NewRequest(http.MethodPost, "/clients", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+
+	h.Create(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if repo.GetClient("u6") != nil {
+		t.Error("client created with invalid algorithm")
+	}
+}
+
+func TestCreate_Tenant(t *testing.T) {
+	h, repo := newTestHandler()
+	h.Tenants = client.NewMemoryRepo(100, 10, h.Logger)
+	h.Tenants.AddClient("acme", client.Limit{Capacity: 100, RPS: 10}, "")
+
+	body := `{"client_id":"app-1","capacity":5,"rate_per_sec":1,"tenant":"acme"}`
+	rr := httptest.NewRecorder()
+	h.Create(rr, httptest.NewRequest(http.MethodPost, "/clients", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want %d, got %d", http.StatusCreated, rr.Code)
+	}
+	var resp clientResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Tenant != "acme" || repo.GetClient("app-1").Tenant != "acme" {
+		t.Errorf("unexpected resp: %+v", resp)
+	}
+
+	// несуществующая организация
+	body = `{"client_id":"app-2","capacity":5,"rate_per_sec":1,"tenant":"nope"}`
+	rr = httptest.NewRecorder()
+	h.Create(rr, httptest.NewRequest(http.MethodPost, "/clients", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusBadRequest || repo.GetClient("app-2") != nil {
+		t.Errorf("unknown tenant: want %d and no client, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestUpdate_Tenant(t *testing.T) {
+	h, repo := newTestHandler()
+	repo.AddClient("app-1", client.Limit{Capacity: 5, RPS: 1}, "")
+	repo.SetTenant("app-1", "acme")
+
+	for _, tc := range []struct {
+		body, want string
+	}{
+		// без поля tenant организация остаётся прежней
+		{`{"client_id":"app-1","capacity":6,"rate_per_sec":1}`, "acme"},
+		{`{"client_id":"app-1","capacity":6,"rate_per_sec":1,"tenant":"globex"}`, "globex"},
+		{`{"client_id":"app-1","capacity":6,"rate_per_sec":1,"tenant":""}`, ""},
+	} {
+		rr := httptest.NewRecorder()
+		h.Update(rr, httptest.NewRequest(http.MethodPut, "/clients", bytes.NewBufferString(tc.body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: want %d, got %d", tc.body, http.StatusOK, rr.Code)
+		}
+		if got := repo.GetClient("app-1").Tenant; got != tc.want {
+			t.Errorf("%s: tenant = %q; want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestUpdate_PartialKeepsLimit(t *testing.T) {
+	h, repo := newTestHandler()
+	repo.AddClient("app-1", client.Limit{Algorithm: client.AlgorithmGCRA, Capacity: 5, RPS: 1}, "")
+	repo.Consume("app-1", 3)
+
+	// только организация: лимит и его состояние не трогаются
+	rr := httptest.NewRecorder()
+	h.Update(rr, httptest.NewRequest(http.MethodPut, "/clients", bytes.NewBufferString(`{"client_id":"app-1","tenant":"acme"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("tenant only: want %d, got %d", http.StatusOK, rr.Code)
+	}
+	if cl := repo.GetClient("app-1"); cl.Tenant != "acme" || cl.Limit.Capacity != 5 || cl.Limit.RPS != 1 || cl.Remaining != 2 {
+		t.Errorf("tenant only: client = %+v; want limit and state kept", cl)
+	}
+
+	// только capacity: алгоритм и rps берутся у клиента
+	rr = httptest.NewRecorder()
+	h.Update(rr, httptest.NewRequest(http.MethodPut, "/clients", bytes.NewBufferString(`{"client_id":"app-1","capacity":8}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("capacity only: want %d, got %d", http.StatusOK, rr.Code)
+	}
+	want := client.Limit{Algorithm: client.AlgorithmGCRA, Capacity: 8, RPS: 1}
+	if cl := repo.GetClient("app-1"); cl.Limit != want || cl.Tenant != "acme" {
+		t.Errorf("capacity only: client = %+v; want limit %+v and tenant acme", cl, want)
+	}
+}
+
+func TestClientHandler_StoreUnavailable(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	store, err := client.OpenBoltStore(filepath.Join(t.TempDir(), "clients.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore: %v", err)
+	}
+	repo, err := client.NewBoltRepo(store, "clients", 10, 2, logger)
+	if err != nil {
+		t.Fatalf("NewBoltRepo: %v", err)
+	}
+	repo.AddClient("u1", client.Limit{Capacity: 5, RPS: 1}, "")
+	store.Close()
+	h := &ClientHandler{Repo: repo, Logger: logger}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		call   func(w http.ResponseWriter, r *http.Request)
+	}{
+		{"create", http.MethodPost, "/clients", `{"client_id":"u2","capacity":5,"rate_per_sec":1}`, h.Create},
+		{"update", http.MethodPut, "/clients", `{"client_id":"u1","capacity":9,"rate_per_sec":1}`, h.Update},
+		{"delete", http.MethodDelete, "/clients?client_id=u1", "", h.Delete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.call(rr, httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body)))
+			if rr.Code != http.StatusServiceUnavailable {
+				t.Errorf("want %d, got %d", http.StatusServiceUnavailable, rr.Code)
+			}
+		})
+	}
+	if cl := repo.GetClient("u1"); cl == nil || cl.Limit.Capacity != 5 {
+		t.Errorf("client changed although nothing was saved: %+v", cl)
+	}
+	if repo.GetClient("u2") != nil {
+		t.Error("client created although nothing was saved")
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/P1coFly/LoadBalancer/pkg/router"
+)
+
+type splitRequest struct {
+	Weights map[string]int `json:"weights"`
+	// Version — версия весов из GET; если задана (или пришёл If-Match), веса меняются,
+	// только пока она актуальна, иначе 409
+	Version uint64 `json:"version"`
+}
+
+type splitResponse struct {
+	Name    string         `json:"name"`
+	Weights map[string]int `json:"weights"`
+	Version uint64         `json:"version"`
+}
+
+func newSplitResponse(s *router.Split) splitResponse {
+	weights, version := s.Snapshot()
+	return splitResponse{Name: s.Name(), Weights: weights, Version: version}
+}
+
+// splitETag — ETag весов сплита для If-Match
+func splitETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseIfMatch возвращает версию из If-Match; 0 — заголовка нет или "*"
+func parseIfMatch(h string) (uint64, error) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return 0, nil
+	}
+	return strconv.ParseUint(strings.Trim(strings.TrimPrefix(h, "W/"), `"`), 10, 64)
+}
+
+// SplitHandler хранит реестр сплитов и логгер
+type SplitHandler struct {
+	Splits *router.SplitRegistry
+	Logger *slog.Logger
+}
+
+// GET /admin/splits — все сплиты, GET /admin/splits?name=… — один
+func (h *SplitHandler) Get(w http.ResponseWriter, r *http.Request) {
+	var resp any
+	if name := r.URL.Query().Get("name"); name != "" {
+		s, err := h.Splits.Get(name)
+		if err != nil {
+			h.Logger.Error("Get split", "err", err)
+			SendJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		sr := newSplitResponse(s)
+		w.Header().Set("ETag", splitETag(sr.Version))
+		resp = sr
+	} else {
+		list := make([]splitResponse, 0)
+		for _, s := range h.Splits.List() {
+			list = append(list, newSplitResponse(s))
+		}
+		resp = list
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Logger.Error("Get split - fail to send splitResponse", "err", err)
+		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
+	}
+}
+
+// PUT /admin/splits?name=… — меняет веса версий на лету. С If-Match или version
+// в теле — только если веса не менялись с тех пор, как их прочитали
+func (h *SplitHandler) Update(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		h.Logger.Error("Update split - no name in query")
+		SendJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	s, err := h.Splits.Get(name)
+	if err != nil {
+		h.Logger.Error("Update split", "err", err)
+		SendJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	var req splitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.Logger.Error("Update split - can't decode body", "err", err)
+		SendJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	expected, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		h.Logger.Error("Update split - invalid If-Match", "err", err)
+		SendJSONError(w, http.StatusBadRequest, "invalid If-Match")
+		return
+	}
+	if expected == 0 {
+		expected = req.Version
+	}
+	if _, err := s.CompareAndSetWeights(expected, req.Weights); err != nil {
+		h.Logger.Error("Update split", "err", err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, router.ErrInvalidSplit):
+			status = http.StatusBadRequest
+		case errors.Is(err, router.ErrVersionMismatch):
+			status = http.StatusConflict
+		}
+		SendJSONError(w, status, err.Error())
+		return
+	}
+
+	resp := newSplitResponse(s)
+	h.Logger.Info("split weights updated", "name", name, "weights", resp.Weights, "version", resp.Version)
+	w.Header().Set("ETag", splitETag(resp.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Logger.Error("Update split - fail to send splitResponse", "err", err)
+		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
+	}
+}
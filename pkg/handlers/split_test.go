@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/P1coFly/LoadBalancer/pkg/router"
+)
+
+func TestSplitUpdateIfMatch(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s, err := router.NewSplit("api", []router.SplitTarget{
+		{Name: "default", Weight: 100, Handler: http.NotFoundHandler()},
+		{Name: "v2", Weight: 0, Handler: http.NotFoundHandler()},
+	}, router.Pin{})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+	reg := router.NewSplitRegistry()
+	if err := reg.Add(s); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	h := &SplitHandler{Splits: reg, Logger: logger}
+
+	rr := httptest.NewRecorder()
+	h.Get(rr, httptest.NewRequest(http.MethodGet, "/admin/splits?name=api", nil))
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("ETag = %q; want \"1\"", etag)
+	}
+
+	update := func(ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/splits?name=api", bytes.NewBufferString(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		h.Update(rr, req)
+		return rr
+	}
+	if rr := update(etag, `{"weights":{"v2":10}}`); rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update with current ETag: %d ETag %q; want 200 \"2\"", rr.Code, rr.Header().Get("ETag"))
+	}
+	// тот же ETag уже устарел: веса не меняются
+	if rr := update(etag, `{"weights":{"v2":100}}`); rr.Code != http.StatusConflict {
+		t.Errorf("update with stale ETag: %d; want 409", rr.Code)
+	}
+	if rr := update("", `{"weights":{"v2":100},"version":1}`); rr.Code != http.StatusConflict {
+		t.Errorf("update with stale body version: %d; want 409", rr.Code)
+	}
+	if w := s.Weights(); w["v2"] != 10 {
+		t.Errorf("v2 weight = %d after conflicts; want 10", w["v2"])
+	}
+	// без версии — как раньше, без проверки
+	if rr := update("", `{"weights":{"v2":50}}`); rr.Code != http.StatusOK {
+		t.Errorf("unconditional update: %d; want 200", rr.Code)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+type tenantRequest struct {
+	TenantID string `json:"tenant_id"`
+	// Algorithm — token_bucket (по умолчанию), sliding_log, sliding_window, gcra или leaky_bucket
+	Algorithm string  `json:"algorithm"`
+	Capacity  int     `json:"capacity"`
+	RPS       float64 `json:"rate_per_sec"`
+}
+
+type tenantResponse struct {
+	TenantID  string `json:"tenant_id"`
+	Algorithm string `json:"algorithm"`
+	Capacity  int    `json:"capacity"`
+	// CurrentTokens — сколько запросов всех клиентов организации пройдёт сейчас
+	CurrentTokens int     `json:"current_tokens"`
+	RPS           float64 `json:"rate_per_sec"`
+}
+
+func newTenantResponse(t *client.Client) tenantResponse {
+	cr := newClientResponse(t)
+	return tenantResponse{
+		TenantID:      cr.ClientID,
+		Algorithm:     cr.Algorithm,
+		Capacity:      cr.Capacity,
+		CurrentTokens: cr.CurrentTokens,
+		RPS:           cr.RPS,
+	}
+}
+
+func (req tenantRequest) limit() (client.Limit, error) {
+	return clientRequest{Algorithm: &req.Algorithm, Capacity: &req.Capacity, RPS: &req.RPS}.limit(client.Limit{})
+}
+
+// TenantHandler управляет лимитами организаций. Организация хранится в отдельном
+// репозитории как «клиент», чей лимит делят все её клиенты
+type TenantHandler struct {
+	Repo   client.ClientRepo
+	Logger *slog.Logger
+}
+
+// POST /tenants
+func (h *TenantHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.Logger.Error("Create tenant - can't decode body", "err", err)
+		SendJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.TenantID == "" {
+		h.Logger.Error("Create tenant - no tenant id in body")
+		SendJSONError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	limit, err := req.limit()
+	if err != nil {
+		h.Logger.Error("Create tenant - invalid algorithm", "err", err)
+		SendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if h.Repo.GetClient(req.TenantID) != nil {
+		h.Logger.Error("Create tenant - already exist")
+		SendJSONError(w, http.StatusConflict, "already exist")
+		return
+	}
+
+	t, err := h.Repo.AddClient(req.TenantID, limit, "")
+	if err != nil {
+		h.Logger.Error("Create tenant", "err", err)
+		sendRepoError(w, err, ErrNoTenant)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newTenantResponse(t)); err != nil {
+		h.Logger.Error("Create tenant - fail to send tenantResponse", "err", err)
+		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
+	}
+}
+
+// GET /tenants?tenant_id=…
+func (h *TenantHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("tenant_id")
+	if id == "" {
+		h.Logger.Error("Get tenant - no tenant id in query")
+		SendJSONError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	t := h.Repo.GetClient(id)
+	if t == nil {
+		h.Logger.Error("Get tenant", "err", ErrNoTenant)
+		SendJSONError(w, http.StatusNotFound, ErrNoTenant)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(newTenantResponse(t)); err != nil {
+		h.Logger.Error("Get tenant - fail to send tenantResponse", "err", err)
+		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
+	}
+}
+
+// PUT /tenants
+func (h *TenantHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.Logger.Error("Update tenant - can't decode body", "err", err)
+		SendJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.TenantID == "" {
+		h.Logger.Error("Update tenant - no tenant id in body")
+		SendJSONError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	limit, err := req.limit()
+	if err != nil {
+		h.Logger.Error("Update tenant - invalid algorithm", "err", err)
+		SendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := h.Repo.UpdateClient(req.TenantID, limit)
+	if err != nil {
+		h.Logger.Error("Update tenant", "err", err)
+		sendRepoError(w, err, ErrNoTenant)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(newTenantResponse(t)); err != nil {
+		h.Logger.Error("Update tenant - fail to send tenantResponse", "err", err)
+		SendJSONError(w, http.StatusInternalServerError, "fail to send response")
+	}
+}
+
+// DELETE /tenants?tenant_id=… Клиенты остаются привязаны к организации и
+// получают её лимит по умолчанию, пока её не создадут снова
+func (h *TenantHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("tenant_id")
+	if id == "" {
+		h.Logger.Error("Delete tenant - no tenant id in query")
+		SendJSONError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	if err := h.Repo.DeleteClient(id); err != nil {
+		h.Logger.Error("Delete tenant", "err", err)
+		sendRepoError(w, err, ErrNoTenant)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
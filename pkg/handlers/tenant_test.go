@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"log/slog"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+func newTestTenantHandler() (*TenantHandler, *client.ClientMemoryRepository) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	repo := client.NewMemoryRepo(100, 10, logger)
+	return &TenantHandler{Repo: repo, Logger: logger}, repo
+}
+
+func TestTenantCRUD(t *testing.T) {
+	h, repo := newTestTenantHandler()
+
+	rr := httptest.NewRecorder()
+	h.Create(rr, httptest.NewRequest(http.MethodPost, "/tenants",
+		bytes.NewBufferString(`{"tenant_id":"acme","algorithm":"gcra","capacity":500,"rate_per_sec":50}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: want %d, got %d", http.StatusCreated, rr.Code)
+	}
+	var resp tenantResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.TenantID != "acme" || resp.Algorithm != "gcra" || resp.Capacity != 500 || resp.CurrentTokens != 500 {
+		t.Errorf("unexpected resp: %+v", resp)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Create(rr, httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewBufferString(`{"tenant_id":"acme","capacity":1,"rate_per_sec":1}`)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("duplicate: want %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Update(rr, httptest.NewRequest(http.MethodPut, "/tenants", bytes.NewBufferString(`{"tenant_id":"acme","capacity":1000,"rate_per_sec":100}`)))
+	if rr.Code != http.StatusOK || repo.GetClient("acme").Limit.Capacity != 1000 {
+		t.Errorf("update: want %d and capacity 1000, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Get(rr, httptest.NewRequest(http.MethodGet, "/tenants?tenant_id=acme", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("get: want %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Delete(rr, httptest.NewRequest(http.MethodDelete, "/tenants?tenant_id=acme", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("delete: want %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.Get(rr, httptest.NewRequest(http.MethodGet, "/tenants?tenant_id=acme", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("get deleted: want %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestTenant_BadRequests(t *testing.T) {
+	h, _ := newTestTenantHandler()
+	for name, serve := range map[string]func(*httptest.ResponseRecorder){
+		"invalid json": func(rr *httptest.ResponseRecorder) {
+			h.Create(rr, httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewBufferString(`{`)))
+		},
+		"missing id": func(rr *httptest.ResponseRecorder) {
+			h.Create(rr, httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewBufferString(`{"capacity":1}`)))
+		},
+		"invalid algorithm": func(rr *httptest.ResponseRecorder) {
+			h.Create(rr, httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewBufferString(`{"tenant_id":"a","algorithm":"x"}`)))
+		},
+		"get without id": func(rr *httptest.ResponseRecorder) { h.Get(rr, httptest.NewRequest(http.MethodGet, "/tenants", nil)) },
+		"delete without id": func(rr *httptest.ResponseRecorder) {
+			h.Delete(rr, httptest.NewRequest(http.MethodDelete, "/tenants", nil))
+		},
+	} {
+		rr := httptest.NewRecorder()
+		serve(rr)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: want %d, got %d", name, http.StatusBadRequest, rr.Code)
+		}
+	}
+	rr := httptest.NewRecorder()
+	h.Update(rr, httptest.NewRequest(http.MethodPut, "/tenants", bytes.NewBufferString(`{"tenant_id":"nope","capacity":1,"rate_per_sec":1}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("update unknown: want %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,204 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var (
+	// ErrInvalidCredentials — учётные данные в запросе есть, но не прошли проверку
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrNoIdentity — ни один источник не нашёл клиента, а IP как запасной выключен
+	ErrNoIdentity = errors.New("client identity required")
+	// ErrUnknownClient — ключ не зарегистрирован через /clients, а политика reject
+	ErrUnknownClient = errors.New("unknown client")
+	ErrInvalidConfig = errors.New("invalid identity config")
+)
+
+// Source — источник ID клиента. ok == false — в запросе его нет, проверяется
+// следующий источник. Ошибка — данные есть, но неверны, запрос отклоняется
+type Source interface {
+	Identify(r *http.Request) (id string, ok bool, err error)
+}
+
+// Policy — что делать с ключом, которого нет в репозитории клиентов
+type Policy string
+
+const (
+	// PolicyDefault — клиент создаётся на первом запросе с лимитами по умолчанию
+	PolicyDefault Policy = "default"
+	// PolicyReject — запрос отклоняется с 403
+	PolicyReject Policy = "reject"
+)
+
+// ParsePolicy проверяет политику из конфига. Пустая — PolicyReject, если среди
+// источников есть header или query: такой ключ ничем не подписан, и с PolicyDefault
+// каждый новый ключ получал бы свежий бакет. Иначе пустая — PolicyDefault
+func ParsePolicy(s string, sources []SourceConfig) (Policy, error) {
+	switch p := Policy(s); p {
+	case "":
+		for _, sc := range sources {
+			if sc.Type == "header" || sc.Type == "query" {
+				return PolicyReject, nil
+			}
+		}
+		return PolicyDefault, nil
+	case PolicyDefault, PolicyReject:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: unknown policy %q: want default or reject", ErrInvalidConfig, s)
+}
+
+// Redact — форма ID клиента для логов: API-ключ из header или query — секрет, в лог
+// попадает только начало его SHA-256
+func Redact(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// Chain опрашивает источники по порядку, первый найденный задаёт клиента
+type Chain struct {
+	Sources []Source
+	// Fallback — источник, если остальные ничего не нашли (обычно IP); nil — ErrNoIdentity.
+	// Политика неизвестных ключей к нему не применяется
+	Fallback func(r *http.Request) string
+	Unknown  Policy
+	// Known сообщает, зарегистрирован ли клиент; нужен для PolicyReject
+	Known func(id string) bool
+}
+
+// Identify возвращает ID клиента запроса
+func (c *Chain) Identify(r *http.Request) (string, error) {
+	for _, src := range c.Sources {
+		id, ok, err := src.Identify(r)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if c.Unknown == PolicyReject && c.Known != nil && !c.Known(id) {
+			return "", ErrUnknownClient
+		}
+		return id, nil
+	}
+	if c.Fallback != nil {
+		if id := c.Fallback(r); id != "" {
+			return id, nil
+		}
+	}
+	return "", ErrNoIdentity
+}
+
+// SourceConfig описывает источник в конфиге
+type SourceConfig struct {
+	// Type — header (API-ключ), query, jwt или mtls
+	Type string
+	// Name — заголовок для header и jwt (по умолчанию Authorization) или параметр для query
+	Name string
+	// Claim — claim JWT с ID клиента, по умолчанию sub
+	Claim string
+	// Secret — ключ HMAC для JWT HS256/384/512
+	Secret string
+	// PublicKeyFile — PEM с открытым ключом или сертификатом RSA/ECDSA для JWT RS*, PS*, ES*
+	PublicKeyFile string
+	// Field — cn (по умолчанию) или dn для mtls
+	Field string
+	// Prefix дописывается к ID, чтобы ключи разных источников не совпадали
+	Prefix string
+}
+
+// NewSource создаёт источник по конфигу
+func NewSource(c SourceConfig) (Source, error) {
+	var src Source
+	switch c.Type {
+	case "header", "query":
+		if c.Name == "" {
+			return nil, fmt.Errorf("%w: %s source requires name", ErrInvalidConfig, c.Type)
+		}
+		if c.Type == "header" {
+			src = Header(c.Name)
+		} else {
+			src = Query(c.Name)
+		}
+	case "jwt":
+		j := &JWT{Header: c.Name, Claim: c.Claim, Secret: []byte(c.Secret)}
+		if c.PublicKeyFile != "" {
+			data, err := os.ReadFile(c.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("%w: jwt public key: %v", ErrInvalidConfig, err)
+			}
+			if j.Key, err = ParsePublicKey(data); err != nil {
+				return nil, err
+			}
+		}
+		if len(j.Secret) == 0 && j.Key == nil {
+			return nil, fmt.Errorf("%w: jwt source requires secret or public_key_file", ErrInvalidConfig)
+		}
+		src = j
+	case "mtls":
+		switch c.Field {
+		case "", "cn", "dn":
+		default:
+			return nil, fmt.Errorf("%w: mtls field %q: want cn or dn", ErrInvalidConfig, c.Field)
+		}
+		src = ClientCert{DN: c.Field == "dn"}
+	default:
+		return nil, fmt.Errorf("%w: unknown source type %q: want header, query, jwt or mtls", ErrInvalidConfig, c.Type)
+	}
+	if c.Prefix != "" {
+		src = prefixed{Source: src, prefix: c.Prefix}
+	}
+	return src, nil
+}
+
+// Header — API-ключ в заголовке
+type Header string
+
+func (h Header) Identify(r *http.Request) (string, bool, error) {
+	v := r.Header.Get(string(h))
+	return v, v != "", nil
+}
+
+// Query — ключ в параметре запроса
+type Query string
+
+func (q Query) Identify(r *http.Request) (string, bool, error) {
+	v := r.URL.Query().Get(string(q))
+	return v, v != "", nil
+}
+
+// ClientCert — subject проверенного клиентского сертификата. Работает, только
+// если TLS с проверкой клиентских сертификатов завершается на балансировщике
+type ClientCert struct {
+	// DN — весь subject вместо CommonName
+	DN bool
+}
+
+func (c ClientCert) Identify(r *http.Request) (string, bool, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false, nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if c.DN {
+		return subject.String(), true, nil
+	}
+	return subject.CommonName, subject.CommonName != "", nil
+}
+
+type prefixed struct {
+	Source
+	prefix string
+}
+
+func (p prefixed) Identify(r *http.Request) (string, bool, error) {
+	id, ok, err := p.Source.Identify(r)
+	if !ok || err != nil {
+		return id, ok, err
+	}
+	return p.prefix + id, true, nil
+}
@@ -0,0 +1,126 @@
+package identity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChainOrderAndFallback(t *testing.T) {
+	apiKey, _ := NewSource(SourceConfig{Type: "header", Name: "X-API-Key"})
+	query, _ := NewSource(SourceConfig{Type: "query", Name: "api_key", Prefix: "q:"})
+	chain := &Chain{
+		Sources:  []Source{apiKey, query},
+		Fallback: func(r *http.Request) string { return "10.0.0.1" },
+	}
+
+	for _, tc := range []struct {
+		target, header, want string
+	}{
+		{"/?api_key=k2", "k1", "k1"},
+		{"/?api_key=k2", "", "q:k2"},
+		{"/", "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.header != "" {
+			r.Header.Set("X-API-Key", tc.header)
+		}
+		if got, err := chain.Identify(r); err != nil || got != tc.want {
+			t.Errorf("%s key=%q: Identify = %q, %v; want %q", tc.target, tc.header, got, err, tc.want)
+		}
+	}
+
+	chain.Fallback = nil
+	if _, err := chain.Identify(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("no source, no fallback: err = %v; want ErrNoIdentity", err)
+	}
+}
+
+func TestChainRejectsUnknownKeys(t *testing.T) {
+	chain := &Chain{
+		Sources:  []Source{Header("X-API-Key")},
+		Fallback: func(r *http.Request) string { return "10.0.0.1" },
+		Unknown:  PolicyReject,
+		Known:    func(id string) bool { return id == "known" },
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", "stranger")
+	if _, err := chain.Identify(r); !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("unknown key: err = %v; want ErrUnknownClient", err)
+	}
+	r.Header.Set("X-API-Key", "known")
+	if id, err := chain.Identify(r); err != nil || id != "known" {
+		t.Errorf("known key: Identify = %q, %v", id, err)
+	}
+	// IP как запасной вариант политике не подчиняется
+	if id, err := chain.Identify(httptest.NewRequest(http.MethodGet, "/", nil)); err != nil || id != "10.0.0.1" {
+		t.Errorf("ip fallback: Identify = %q, %v", id, err)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "svc-a", Organization: []string{"acme"}}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok, _ := (ClientCert{}).Identify(r); ok {
+		t.Error("plain HTTP request identified by certificate")
+	}
+	// непроверенный сертификат не учитывается
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if _, ok, _ := (ClientCert{}).Identify(r); ok {
+		t.Error("unverified certificate accepted")
+	}
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	if id, ok, _ := (ClientCert{}).Identify(r); !ok || id != "svc-a" {
+		t.Errorf("cn = %q, %v; want svc-a", id, ok)
+	}
+	if id, _, _ := (ClientCert{DN: true}).Identify(r); id != "CN=svc-a,O=acme" {
+		t.Errorf("dn = %q", id)
+	}
+}
+
+func TestNewSourceValidation(t *testing.T) {
+	for _, sc := range []SourceConfig{
+		{Type: "header"},
+		{Type: "jwt"},
+		{Type: "mtls", Field: "email"},
+		{Type: "cookie", Name: "sid"},
+	} {
+		if _, err := NewSource(sc); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewSource(%+v): err = %v; want ErrInvalidConfig", sc, err)
+		}
+	}
+	if _, err := ParsePolicy("allow", nil); err == nil {
+		t.Error("ParsePolicy(\"allow\"): want error")
+	}
+}
+
+func TestParsePolicyDefaultsBySource(t *testing.T) {
+	for _, tc := range []struct {
+		sources []SourceConfig
+		want    Policy
+	}{
+		{nil, PolicyDefault},
+		{[]SourceConfig{{Type: "jwt"}}, PolicyDefault},
+		{[]SourceConfig{{Type: "jwt"}, {Type: "header"}}, PolicyReject},
+		{[]SourceConfig{{Type: "query"}}, PolicyReject},
+	} {
+		if got, err := ParsePolicy("", tc.sources); err != nil || got != tc.want {
+			t.Errorf("ParsePolicy(\"\", %+v) = %q, %v; want %q", tc.sources, got, err, tc.want)
+		}
+	}
+	if got, _ := ParsePolicy("default", []SourceConfig{{Type: "header"}}); got != PolicyDefault {
+		t.Errorf("explicit default with header source = %q; want default", got)
+	}
+}
+
+func TestRedactHidesKey(t *testing.T) {
+	got := Redact("secret-api-key")
+	if strings.Contains(got, "secret") || got != Redact("secret-api-key") || got == Redact("other-key") {
+		t.Errorf("Redact = %q; want stable hash without the key", got)
+	}
+}
@@ -0,0 +1,225 @@
+package identity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// jwtLeeway — допуск расхождения часов при проверке exp и nbf
+const jwtLeeway = 30 * time.Second
+
+// JWT берёт ID клиента из claim'а токена после проверки подписи, exp и nbf.
+// Алгоритм ограничен типом ключа: HS* — только с Secret, RS*/PS* — с ключом RSA,
+// ES* — с ключом ECDSA, поэтому открытый ключ нельзя выдать за секрет HMAC
+type JWT struct {
+	// Header — заголовок с токеном, по умолчанию Authorization (с префиксом Bearer)
+	Header string
+	// Claim — claim с ID клиента, по умолчанию sub
+	Claim  string
+	Secret []byte
+	Key    crypto.PublicKey
+	// Now — часы для проверки exp и nbf, nil — time.Now
+	Now func() time.Time
+}
+
+func (j *JWT) Identify(r *http.Request) (string, bool, error) {
+	name := j.Header
+	if name == "" {
+		name = "Authorization"
+	}
+	token := r.Header.Get(name)
+	if token == "" {
+		return "", false, nil
+	}
+	if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	claims, err := j.verify(token)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: jwt: %v", ErrInvalidCredentials, err)
+	}
+	claim := j.Claim
+	if claim == "" {
+		claim = "sub"
+	}
+	var id string
+	switch v := claims[claim].(type) {
+	case string:
+		id = v
+	case json.Number:
+		id = v.String()
+	}
+	if id == "" {
+		return "", false, fmt.Errorf("%w: jwt: no %q claim", ErrInvalidCredentials, claim)
+	}
+	return id, true, nil
+}
+
+// verify проверяет подпись и сроки и возвращает claims
+func (j *JWT) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if err := j.checkSignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	now := time.Now()
+	if j.Now != nil {
+		now = j.Now()
+	}
+	if exp, ok := numericDate(claims["exp"]); ok && !now.Before(exp.Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+func (j *JWT) checkSignature(alg, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+
+	if alg[:2] == "HS" {
+		if len(j.Secret) == 0 {
+			return fmt.Errorf("alg %s: no secret configured", alg)
+		}
+		mac := hmac.New(hash.New, j.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch key := j.Key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			if rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		case "PS":
+			if rsa.VerifyPSS(key, hash, digest, sig, nil) != nil {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			// подпись JWS — r и s фиксированной длины подряд
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				return fmt.Errorf("invalid signature")
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(key, digest, r, s) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("alg %s does not match configured key", alg)
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// numericDate разбирает exp/nbf — секунды Unix, возможно дробные
+func numericDate(v any) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true
+}
+
+// ParsePublicKey читает открытый ключ RSA или ECDSA из PEM: PUBLIC KEY,
+// RSA PUBLIC KEY или CERTIFICATE
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: jwt public key: no PEM block", ErrInvalidConfig)
+	}
+	var key crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%w: jwt public key: unsupported PEM block %q", ErrInvalidConfig, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: jwt public key: %v", ErrInvalidConfig, err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: jwt public key: want RSA or ECDSA, got %T", ErrInvalidConfig, key)
+}
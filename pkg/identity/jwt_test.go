@@ -0,0 +1,118 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1_700_000_000, 0)
+
+// sign собирает токен; signer подписывает «header.payload»
+func sign(t *testing.T, alg string, claims map[string]any, signer func([]byte) []byte) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return input + "." + base64.RawURLEncoding.EncodeToString(signer([]byte(input)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(b []byte) []byte {
+		m := hmac.New(sha256.New, []byte(secret))
+		m.Write(b)
+		return m.Sum(nil)
+	}
+}
+
+func identify(j *JWT, token string) (string, bool, error) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return j.Identify(r)
+}
+
+func TestJWTHMAC(t *testing.T) {
+	j := &JWT{Claim: "tenant", Secret: []byte("s3cret"), Now: func() time.Time { return now }}
+	valid := sign(t, "HS256", map[string]any{"tenant": "acme", "exp": now.Add(time.Minute).Unix()}, hs256("s3cret"))
+	if id, ok, err := identify(j, valid); err != nil || !ok || id != "acme" {
+		t.Fatalf("valid token: %q, %v, %v", id, ok, err)
+	}
+
+	for name, token := range map[string]string{
+		"wrong secret": sign(t, "HS256", map[string]any{"tenant": "acme"}, hs256("other")),
+		"expired":      sign(t, "HS256", map[string]any{"tenant": "acme", "exp": now.Add(-time.Minute).Unix()}, hs256("s3cret")),
+		"not yet":      sign(t, "HS256", map[string]any{"tenant": "acme", "nbf": now.Add(time.Minute).Unix()}, hs256("s3cret")),
+		"no claim":     sign(t, "HS256", map[string]any{"sub": "acme"}, hs256("s3cret")),
+		"alg none":     sign(t, "none", map[string]any{"tenant": "acme"}, func([]byte) []byte { return nil }),
+		"malformed":    "abc.def",
+	} {
+		if _, _, err := identify(j, token); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: err = %v; want ErrInvalidCredentials", name, err)
+		}
+	}
+
+	// без заголовка источник пропускается
+	if _, ok, err := j.Identify(httptest.NewRequest(http.MethodGet, "/", nil)); ok || err != nil {
+		t.Errorf("no token: ok = %v, err = %v", ok, err)
+	}
+}
+
+func TestJWTPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := func(b []byte) []byte { h := sha256.Sum256(b); return h[:] }
+	rs256 := func(b []byte) []byte {
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(b))
+		return sig
+	}
+	es256 := func(b []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest(b))
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	claims := map[string]any{"sub": "svc"}
+
+	rsaPEM, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPEM}))
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %v", err)
+	}
+	rsaJWT := &JWT{Key: pub}
+	if id, _, err := identify(rsaJWT, sign(t, "RS256", claims, rs256)); err != nil || id != "svc" {
+		t.Errorf("RS256: %q, %v", id, err)
+	}
+	// HS256, подписанный открытым ключом как секретом, не принимается
+	confused := sign(t, "HS256", claims, hs256(string(rsaPEM)))
+	if _, _, err := identify(rsaJWT, confused); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("HS256 with RSA key: err = %v; want ErrInvalidCredentials", err)
+	}
+
+	ecJWT := &JWT{Key: &ecKey.PublicKey}
+	if id, _, err := identify(ecJWT, sign(t, "ES256", claims, es256)); err != nil || id != "svc" {
+		t.Errorf("ES256: %q, %v", id, err)
+	}
+	if _, _, err := identify(ecJWT, sign(t, "RS256", claims, rs256)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("RS256 with ECDSA key: err = %v; want ErrInvalidCredentials", err)
+	}
+}
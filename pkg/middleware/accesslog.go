@@ -15,7 +15,7 @@ func AccessLog(logger *slog.Logger, next http.Handler) http.Handler {
 		logger.Info("New request",
 			slog.String("method", r.Method),
 			slog.String("path", r.URL.Path),
-			slog.String("client_ip", r.RemoteAddr),
+			slog.String("client_ip", ClientIP(r)),
 			slog.String("user_agent", r.UserAgent()),
 			slog.Int("status code", sw.status),
 			slog.String("duration", time.Since(start).String()),
@@ -34,3 +34,8 @@ func (w *statusResponseWriter) WriteHeader(code int) {
 	w.status = code
 	w.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap даёт http.ResponseController добраться до Flush/Hijack исходного writer'а
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
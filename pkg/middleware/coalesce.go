@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CoalesceConfig — параметры схлопывания одинаковых GET-запросов
+type CoalesceConfig struct {
+	// VaryHeaders — заголовки запроса, которые входят в ключ вместе с методом и URL
+	VaryHeaders []string
+	// MaxResponseBytes — ответы больше этого размера ожидающим не раздаются
+	MaxResponseBytes int64
+}
+
+// Coalesce — middleware, схлопывающий одновременные одинаковые GET-запросы в один
+// запрос к бекенду. Ответ лидера раздаётся всем ожидающим. Запросы с Authorization
+// или Cookie схлопываются, только если заголовок указан в VaryHeaders. Ответы с
+// Set-Cookie, потоковые и больше MaxResponseBytes не раздаются — ожидающие идут на
+// бекенд сами, как только это становится ясно. Апгрейды соединения и запросы
+// потока событий идут мимо
+func Coalesce(cfg CoalesceConfig, next http.Handler) http.Handler {
+	c := &coalescer{
+		calls:       make(map[string]*coalescedCall),
+		varyHeaders: make([]string, 0, len(cfg.VaryHeaders)),
+		limit:       cfg.MaxResponseBytes,
+	}
+	for _, h := range cfg.VaryHeaders {
+		h = http.CanonicalHeaderKey(h)
+		c.varyHeaders = append(c.varyHeaders, h)
+		switch h {
+		case "Authorization":
+			c.varyAuth = true
+		case "Cookie":
+			c.varyCookie = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !c.coalescable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.key(r)
+		call, leader := c.join(key)
+		if !leader {
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if call.shared {
+				call.writeTo(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// ожидающих отпускаем сразу, как только ответ лидера нельзя будет раздать
+		released := false
+		release := func() {
+			if !released {
+				released = true
+				c.leave(key, call)
+			}
+		}
+		defer release()
+		tw := &teeResponseWriter{ResponseWriter: w, limit: c.limit, onOverflow: release}
+		tw.onHeader = func() {
+			if !shareable(tw.header, c.limit) {
+				release()
+			}
+		}
+		next.ServeHTTP(tw, r)
+		if !released {
+			call.finish(tw)
+		}
+	})
+}
+
+// coalescable — запрос не привязан к клиенту и не открывает поток
+func (c *coalescer) coalescable(r *http.Request) bool {
+	if !c.varyAuth && r.Header.Get("Authorization") != "" {
+		return false
+	}
+	if !c.varyCookie && r.Header.Get("Cookie") != "" {
+		return false
+	}
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Accept") {
+		if strings.Contains(v, "text/event-stream") {
+			return false
+		}
+	}
+	return true
+}
+
+// shareable — по заголовкам ответ лидера ещё можно раздать ожидающим
+func shareable(h http.Header, limit int64) bool {
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	if mt, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mt == "text/event-stream" {
+		return false
+	}
+	if cl, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && cl > limit {
+		return false
+	}
+	return true
+}
+
+type coalescer struct {
+	mu          sync.Mutex
+	calls       map[string]*coalescedCall
+	varyHeaders []string
+	varyAuth    bool
+	varyCookie  bool
+	limit       int64
+}
+
+// coalescedCall — запрос лидера, результат которого ждут остальные
+type coalescedCall struct {
+	done   chan struct{}
+	shared bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (c *coalescer) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.Host + r.URL.RequestURI())
+	for _, h := range c.varyHeaders {
+		b.WriteString("\n" + h + ":" + strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+func (c *coalescer) join(key string) (*coalescedCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+func (c *coalescer) leave(key string, call *coalescedCall) {
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// finish решает, можно ли раздать ответ лидера ожидающим
+func (call *coalescedCall) finish(tw *teeResponseWriter) {
+	if tw.overflow || tw.header == nil || !shareable(tw.header, tw.limit) {
+		return
+	}
+	if cl, err := strconv.Atoi(tw.header.Get("Content-Length")); err == nil && cl != tw.body.Len() {
+		return
+	}
+	call.status = tw.status
+	call.header = tw.header
+	call.body = tw.body.Bytes()
+	call.shared = true
+}
+
+// writeTo отдаёт ответ лидера ожидающему. Заголовки, уже выставленные для
+// этого клиента внешними middleware, не перетираются
+func (call *coalescedCall) writeTo(w http.ResponseWriter) {
+	h := w.Header()
+	for k, vs := range call.header {
+		if _, ok := h[k]; ok {
+			continue
+		}
+		h[k] = append([]string(nil), vs...)
+	}
+	w.WriteHeader(call.status)
+	_, _ = w.Write(call.body)
+}
+
+// teeResponseWriter пишет ответ клиенту и параллельно копит его (не больше limit байт)
+type teeResponseWriter struct {
+	http.ResponseWriter
+	limit    int64
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+
+	// onHeader вызывается, когда заголовки ответа зафиксированы, onOverflow — при превышении limit
+	onHeader   func()
+	onOverflow func()
+}
+
+func (w *teeResponseWriter) WriteHeader(code int) {
+	if w.header == nil && code >= 200 {
+		w.status = code
+		w.header = w.ResponseWriter.Header().Clone()
+		if w.onHeader != nil {
+			w.onHeader()
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *teeResponseWriter) Write(p []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if int64(w.body.Len()+len(p)) > w.limit {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+			if w.onOverflow != nil {
+				w.onOverflow()
+			}
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *teeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
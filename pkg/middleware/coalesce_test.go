@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runConcurrent отправляет n одинаковых запросов одновременно и отпускает бекенд,
+// когда все они встали в очередь
+func runConcurrent(h http.Handler, n int, release chan struct{}, setup func(i int, r *http.Request)) []*httptest.ResponseRecorder {
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/dashboard?x=1", nil)
+			if setup != nil {
+				setup(i, req)
+			}
+			recs[i] = httptest.NewRecorder()
+			h.ServeHTTP(recs[i], req)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return recs
+}
+
+func TestCoalesce_SharesResponse(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := Coalesce(CoalesceConfig{MaxResponseBytes: 1024}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = io.WriteString(w, "payload")
+	}))
+
+	recs := runConcurrent(h, 30, release, nil)
+
+	if calls != 1 {
+		t.Errorf("upstream calls = %d; want 1", calls)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusAccepted || rec.Body.String() != "payload" || rec.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("request %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestCoalesce_VaryHeaders(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := Coalesce(CoalesceConfig{VaryHeaders: []string{"accept-language"}, MaxResponseBytes: 1024},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			_, _ = io.WriteString(w, r.Header.Get("Accept-Language"))
+		}))
+
+	langs := []string{"en", "ru"}
+	recs := runConcurrent(h, 10, release, func(i int, r *http.Request) {
+		r.Header.Set("Accept-Language", langs[i%2])
+	})
+
+	if calls != 2 {
+		t.Errorf("upstream calls = %d; want 2", calls)
+	}
+	for i, rec := range recs {
+		if rec.Body.String() != langs[i%2] {
+			t.Errorf("request %d: body %q; want %q", i, rec.Body.String(), langs[i%2])
+		}
+	}
+}
+
+func TestCoalesce_NotShared(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int64
+		cookie    bool
+		auth      bool
+		reqCookie bool
+		wantMin   int32
+	}{
+		{"too large", 3, false, false, false, 2},
+		{"set-cookie", 1024, true, false, false, 2},
+		{"authorization", 1024, false, true, false, 2},
+		{"cookie", 1024, false, false, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			release := make(chan struct{})
+			h := Coalesce(CoalesceConfig{MaxResponseBytes: tt.limit}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				if tt.cookie {
+					w.Header().Set("Set-Cookie", "sid=1")
+				}
+				_, _ = io.WriteString(w, "payload")
+			}))
+
+			recs := runConcurrent(h, 5, release, func(i int, r *http.Request) {
+				if tt.auth {
+					r.Header.Set("Authorization", "Bearer token")
+				}
+				if tt.reqCookie {
+					r.Header.Set("Cookie", "sid=1")
+				}
+			})
+
+			if calls < tt.wantMin {
+				t.Errorf("upstream calls = %d; want >= %d", calls, tt.wantMin)
+			}
+			for i, rec := range recs {
+				if rec.Body.String() != "payload" {
+					t.Errorf("request %d: body %q", i, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCoalesce_CookieInVary(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := Coalesce(CoalesceConfig{VaryHeaders: []string{"Cookie"}, MaxResponseBytes: 1024},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			_, _ = io.WriteString(w, r.Header.Get("Cookie"))
+		}))
+
+	cookies := []string{"sid=a", "sid=b"}
+	recs := runConcurrent(h, 10, release, func(i int, r *http.Request) {
+		r.Header.Set("Cookie", cookies[i%2])
+	})
+
+	if calls != 2 {
+		t.Errorf("upstream calls = %d; want 2", calls)
+	}
+	for i, rec := range recs {
+		if rec.Body.String() != cookies[i%2] {
+			t.Errorf("request %d: body %q; want %q", i, rec.Body.String(), cookies[i%2])
+		}
+	}
+}
+
+func TestCoalesce_StreamingNotSerialized(t *testing.T) {
+	var calls int32
+	stop := make(chan struct{})
+	h := Coalesce(CoalesceConfig{MaxResponseBytes: 1024}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: hello\n\n")
+		_ = http.NewResponseController(w).Flush()
+		<-stop
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	defer close(stop)
+
+	const event = "data: hello\n\n"
+	got := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := http.Get(srv.URL + "/events")
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			buf := make([]byte, len(event))
+			if _, err := io.ReadFull(resp.Body, buf); err != nil {
+				got <- err.Error()
+				return
+			}
+			got <- string(buf)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case b := <-got:
+			if b != event {
+				t.Errorf("stream %d got %q", i, b)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("stream %d blocked behind another stream", i)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("upstream calls = %d; want 2", n)
+	}
+}
@@ -0,0 +1,326 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+)
+
+var ErrUnknownEncoding = errors.New("unknown content encoding")
+
+// DefaultCompressMIMETypes — типы, которые сжимаются, если MIMETypes не задан
+var DefaultCompressMIMETypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressConfig — параметры сжатия ответов
+type CompressConfig struct {
+	// MinSize — ответы меньше этого размера (в байтах) отдаются как есть
+	MinSize int
+	// Encodings — поддерживаемые кодировки в порядке предпочтения сервера
+	Encodings []string
+	// MIMETypes — разрешённые Content-Type, допускается маска вида "text/*".
+	// Пустой список — DefaultCompressMIMETypes
+	MIMETypes []string
+}
+
+// encoder — общий интерфейс gzip/brotli/zstd писателей
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	EncodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}},
+	EncodingZstd: {New: func() any {
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+// Compress — middleware, сжимающий ответы по Accept-Encoding клиента.
+// Уже закодированные ответы, неподходящие типы и маленькие тела не трогает.
+// Ответ, который сжался бы для другого клиента, получает Vary: Accept-Encoding и
+// несжатым. Flush пробрасывается, поэтому стриминг (SSE и т.п.) продолжает работать
+func Compress(cfg CompressConfig, next http.Handler) (http.Handler, error) {
+	for _, enc := range cfg.Encodings {
+		if _, ok := encoderPools[enc]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownEncoding, enc)
+		}
+	}
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	}
+	if len(cfg.MIMETypes) == 0 {
+		cfg.MIMETypes = DefaultCompressMIMETypes
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), cfg.Encodings)
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			encoding = ""
+		}
+
+		cw := &compressResponseWriter{
+			ResponseWriter: w,
+			cfg:            &cfg,
+			encoding:       encoding,
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	}), nil
+}
+
+// negotiateEncoding выбирает кодировку с наибольшим q, при равенстве — по порядку сервера
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := weights[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// compressResponseWriter буферизует начало ответа, пока не станет ясно,
+// стоит ли его сжимать, после чего пишет через encoder
+type compressResponseWriter struct {
+	http.ResponseWriter
+	cfg *CompressConfig
+	// encoding — выбранная кодировка; пустая — не сжимать, только отметить Vary
+	encoding string
+
+	status      int
+	buf         []byte
+	decided     bool
+	compressing bool
+	enc         encoder
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+	// информационные ответы пропускаем как есть
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	if !w.compressible() {
+		w.passthrough()
+	}
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.compressing {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.cfg.MinSize {
+		if err := w.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush коммитит решение о сжатии и сбрасывает данные клиенту
+func (w *compressResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		// стрим: не ждём MinSize, иначе клиент ничего не получит
+		if err := w.startCompression(); err != nil {
+			return
+		}
+	}
+	if w.compressing {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressible проверяет заголовки ответа: кодировку, тип, размер и статус
+func (w *compressResponseWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch {
+	case w.status == http.StatusNoContent, w.status == http.StatusNotModified,
+		w.status == http.StatusPartialContent, w.status == http.StatusSwitchingProtocols:
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < w.cfg.MinSize {
+			return false
+		}
+	}
+	return allowedMIME(h.Get("Content-Type"), w.cfg.MIMETypes)
+}
+
+// startCompression вызывается, когда ответ подходит для сжатия по типу и размеру
+func (w *compressResponseWriter) startCompression() error {
+	w.addVary()
+	if w.encoding == "" {
+		w.passthrough()
+		return nil
+	}
+	w.decided = true
+	w.compressing = true
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", w.encoding)
+	// сжатое представление не побайтово равно исходному — strong ETag становится weak
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.enc = encoderPools[w.encoding].Get().(encoder)
+	w.enc.Reset(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.enc.Write(buf)
+	return err
+}
+
+// addVary отмечает, что представление ответа зависит от Accept-Encoding: сжатое
+// или нет, кеши не должны отдавать его клиенту с другим Accept-Encoding
+func (w *compressResponseWriter) addVary() {
+	if h := w.Header(); !varyContains(h, "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+}
+
+func (w *compressResponseWriter) passthrough() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// close дописывает хвост: маленький ответ отдаёт без сжатия, encoder закрывает и возвращает в пул
+func (w *compressResponseWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			// обработчик ничего не написал — пусть net/http ответит сам
+			return
+		}
+		// тело не дописано до MinSize (HEAD), но его размер уже объявлен
+		if n, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil && n >= w.cfg.MinSize {
+			w.addVary()
+		}
+		w.passthrough()
+		return
+	}
+	if w.compressing {
+		_ = w.enc.Close()
+		w.enc.Reset(io.Discard)
+		encoderPools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+func allowedMIME(contentType string, allowed []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func varyContains(h http.Header, name string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func newCompressHandler(t *testing.T, cfg CompressConfig, next http.Handler) http.Handler {
+	t.Helper()
+	h, err := Compress(cfg, next)
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	return h
+}
+
+func textHandler(body string, headers map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func decode(t *testing.T, encoding string, r io.Reader) string {
+	t.Helper()
+	var dec io.Reader
+	switch encoding {
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("gzip reader: %v", err)
+		}
+		dec = zr
+	case EncodingBrotli:
+		dec = brotli.NewReader(r)
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatalf("zstd reader: %v", err)
+		}
+		defer zr.Close()
+		dec = zr
+	default:
+		dec = r
+	}
+	b, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+	return string(b)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	tests := []struct {
+		accept, want string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", EncodingGzip},
+		{"gzip, br", EncodingBrotli},
+		{"gzip;q=1, br;q=0.5, zstd;q=0.8", EncodingGzip},
+		{"*", EncodingZstd},
+		{"*, zstd;q=0", EncodingBrotli},
+		{"gzip;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept, supported); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q; want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompress_Encodings(t *testing.T) {
+	body := strings.Repeat("hello balancer ", 200)
+	h := newCompressHandler(t, CompressConfig{MinSize: 100}, textHandler(body, map[string]string{"ETag": `"v1"`}))
+
+	for _, enc := range []string{EncodingGzip, EncodingBrotli, EncodingZstd} {
+		t.Run(enc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", enc)
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Content-Encoding"); got != enc {
+				t.Fatalf("Content-Encoding = %q; want %q", got, enc)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q", got)
+			}
+			if got := rr.Header().Get("ETag"); got != `W/"v1"` {
+				t.Errorf("ETag = %q", got)
+			}
+			if got := decode(t, enc, rr.Body); got != body {
+				t.Errorf("decoded body mismatch, len %d", len(got))
+			}
+		})
+	}
+}
+
+func TestCompress_Skip(t *testing.T) {
+	long := strings.Repeat("x", 2048)
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"below min size", textHandler("short", nil)},
+		{"already encoded", textHandler(long, map[string]string{"Content-Encoding": "gzip"})},
+		{"mime not allowed", textHandler(long, map[string]string{"Content-Type": "image/png"})},
+		{"small content length", textHandler("tiny", map[string]string{"Content-Length": "4"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCompressHandler(t, CompressConfig{MinSize: 1024}, tt.handler)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			if rr.Header().Get("Content-Encoding") == EncodingGzip && tt.name != "already encoded" {
+				t.Errorf("response should not be compressed")
+			}
+			if tt.name == "below min size" && rr.Body.String() != "short" {
+				t.Errorf("body = %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestCompress_VaryWithoutCompression: ответ, который сжался бы для другого клиента,
+// отдаётся как есть, но с Vary — иначе кеш отдаст несжатый вариант всем
+func TestCompress_VaryWithoutCompression(t *testing.T) {
+	long := strings.Repeat("x", 2048)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.Handler
+		wantVary       bool
+	}{
+		{"no accept-encoding", "", textHandler(long, nil), true},
+		{"unsupported encoding", "compress", textHandler(long, nil), true},
+		{"below min size", "", textHandler("short", nil), false},
+		{"mime not allowed", "", textHandler(long, map[string]string{"Content-Type": "image/png"}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCompressHandler(t, CompressConfig{MinSize: 1024}, tt.handler)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q; want none", got)
+			}
+			if got := rr.Header().Get("Vary") == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("Vary = %q; want Accept-Encoding: %v", rr.Header().Get("Vary"), tt.wantVary)
+			}
+			if rr.Body.Len() != 2048 && rr.Body.String() != "short" {
+				t.Errorf("body len = %d", rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestCompress_UnknownEncoding(t *testing.T) {
+	if _, err := Compress(CompressConfig{Encodings: []string{"lzma"}}, http.NotFoundHandler()); err == nil {
+		t.Error("expected error for unknown encoding")
+	}
+}
+
+// TestCompress_Streaming проверяет, что Flush доходит до клиента до конца ответа
+func TestCompress_Streaming(t *testing.T) {
+	release := make(chan struct{})
+	h := newCompressHandler(t, CompressConfig{MinSize: 1024}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+		}
+		<-release
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Content-Encoding = %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	lines := make(chan string, 1)
+	go func() {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			lines <- "error: " + err.Error()
+			return
+		}
+		line, _ := bufio.NewReader(zr).ReadString('\n')
+		lines <- line
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "data: first\n" {
+			t.Errorf("first line = %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("flushed data did not reach client")
+	}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	// HeaderMirrored помечает теневые запросы, чтобы бекенд мог отличить их от боевых
+	HeaderMirrored = "X-Mirrored"
+
+	defaultMirrorTimeout = 10 * time.Second
+)
+
+// mirrorStats — счётчики зеркалирования, доступны через /debug/vars
+var mirrorStats = expvar.NewMap("mirror")
+
+// MirrorConfig — параметры зеркалирования трафика
+type MirrorConfig struct {
+	// Percent — доля запросов (0–100), которые дублируются в теневой пул
+	Percent float64
+	// MaxConcurrency — сколько теневых запросов может выполняться одновременно
+	MaxConcurrency int
+	// MaxBodyBytes — запросы с телом больше этого размера не зеркалируются
+	MaxBodyBytes int64
+	// Timeout — таймаут теневого запроса
+	Timeout time.Duration
+}
+
+// Mirror — middleware, дублирующий часть запросов в shadow. Теневой запрос уходит
+// после ответа клиенту, его ответ отбрасывается, а статус и задержка сравниваются
+// с основным ответом и пишутся в лог и счётчики
+func Mirror(cfg MirrorConfig, shadow http.Handler, logger *slog.Logger, next http.Handler) http.Handler {
+	return NewMirror(cfg, shadow, logger)(next)
+}
+
+// NewMirror — Mirror для нескольких обработчиков с общим лимитом MaxConcurrency,
+// например для каждого маршрута после того, как роутер выбрал его и положил в
+// контекст правила перезаписи: теневой запрос переписывается так же, как основной
+func NewMirror(cfg MirrorConfig, shadow http.Handler, logger *slog.Logger) func(next http.Handler) http.Handler {
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultMirrorTimeout
+	}
+	sem := make(chan struct{}, cfg.MaxConcurrency)
+
+	return func(next http.Handler) http.Handler {
+		return mirror(cfg, sem, shadow, logger, next)
+	}
+}
+
+func mirror(cfg MirrorConfig, sem chan struct{}, shadow http.Handler, logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Percent <= 0 || rand.Float64()*100 >= cfg.Percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// тело читает основной запрос, мы лишь копим копию
+		body := &limitedBuffer{limit: cfg.MaxBodyBytes}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, body), r.Body}
+		}
+
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		primaryLatency := time.Since(start)
+		primaryStatus := sw.status
+		if primaryStatus == 0 {
+			primaryStatus = http.StatusOK
+		}
+
+		// тело слишком большое или основной запрос дочитал его не до конца
+		if body.overflow || (r.ContentLength > 0 && int64(body.buf.Len()) != r.ContentLength) {
+			mirrorStats.Add("skipped_body", 1)
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			mirrorStats.Add("dropped", 1)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), cfg.Timeout)
+		req := r.Clone(ctx)
+		req.Body = io.NopCloser(bytes.NewReader(body.buf.Bytes()))
+		req.ContentLength = int64(body.buf.Len())
+		req.Header.Set(HeaderMirrored, "true")
+
+		go func() {
+			defer func() { <-sem }()
+			defer cancel()
+			defer func() {
+				// ReverseProxy паникует с ErrAbortHandler при обрыве копирования тела
+				if rec := recover(); rec != nil {
+					mirrorStats.Add("errors", 1)
+					logger.Error("mirror request aborted", "path", req.URL.Path, "panic", rec)
+				}
+			}()
+
+			start := time.Now()
+			dw := &discardResponseWriter{header: http.Header{}}
+			shadow.ServeHTTP(dw, req)
+			shadowLatency := time.Since(start)
+			if dw.status == 0 {
+				dw.status = http.StatusOK
+			}
+
+			mirrorStats.Add("requests", 1)
+			match := dw.status == primaryStatus
+			if !match {
+				mirrorStats.Add("status_mismatch", 1)
+			}
+			logger.Info("mirror result",
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.Int("primary_status", primaryStatus),
+				slog.Int("shadow_status", dw.status),
+				slog.Bool("status_match", match),
+				slog.String("primary_latency", primaryLatency.String()),
+				slog.String("shadow_latency", shadowLatency.String()),
+			)
+		}()
+	})
+}
+
+// limitedBuffer копит данные до limit байт, дальше только отмечает переполнение
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow {
+		if int64(b.buf.Len()+len(p)) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// discardResponseWriter запоминает статус теневого ответа и отбрасывает тело
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(p), nil
+}
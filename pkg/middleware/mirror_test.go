@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMirror_TeesBodyAndDiscardsShadow(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	shadowBodies := make(chan string, 1)
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if r.Header.Get(HeaderMirrored) != "true" {
+			t.Errorf("shadow request without %s header", HeaderMirrored)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("shadow"))
+		shadowBodies <- string(b)
+	})
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(append([]byte("primary:"), b...))
+	})
+
+	h := Mirror(MirrorConfig{Percent: 100, MaxConcurrency: 1, MaxBodyBytes: 1024}, shadow, logger, primary)
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(`{"id":1}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated || rr.Body.String() != `primary:{"id":1}` {
+		t.Errorf("primary response changed: %d %q", rr.Code, rr.Body.String())
+	}
+	select {
+	case got := <-shadowBodies:
+		if got != `{"id":1}` {
+			t.Errorf("shadow body = %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request was not sent")
+	}
+}
+
+func TestMirror_DoesNotBlockPrimary(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	release := make(chan struct{})
+	defer close(release)
+	shadowCalls := make(chan struct{}, 10)
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowCalls <- struct{}{}
+		<-release
+	})
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := Mirror(MirrorConfig{Percent: 100, MaxConcurrency: 1, MaxBodyBytes: 1024}, shadow, logger, primary)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("request %d: status %d", i, rr.Code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("slow shadow delayed primary: %s", elapsed)
+	}
+
+	// лимит конкурентности: пока первый теневой запрос висит, остальные отбрасываются
+	time.Sleep(50 * time.Millisecond)
+	if n := len(shadowCalls); n != 1 {
+		t.Errorf("shadow calls = %d; want 1", n)
+	}
+}
+
+func TestMirror_SkipsLargeBody(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	called := make(chan struct{}, 1)
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- struct{}{}
+	})
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	})
+
+	h := Mirror(MirrorConfig{Percent: 100, MaxConcurrency: 1, MaxBodyBytes: 4}, shadow, logger, primary)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("too large")))
+
+	select {
+	case <-called:
+		t.Error("request with large body should not be mirrored")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
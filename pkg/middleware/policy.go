@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+)
+
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// RatePolicy — правила лимита для запросов, подходящих под шаблон
+type RatePolicy struct {
+	// Name — имя политики; отдельный бакет клиента хранится в репозитории бакетов маршрутов
+	// под ID "<клиент>#<Name>", поэтому у политики с Limit в имени не может быть '#'
+	Name string
+	// Pattern — шаблон http.ServeMux: "[METHOD ][host]/path", например "POST /upload"
+	Pattern string
+	// Cost — сколько токенов стоит запрос
+	Cost int
+	// Limit — отдельный лимит маршрута на каждого клиента; nil — Cost списывается
+	// с основного лимита клиента
+	Limit *client.Limit
+}
+
+// key возвращает ID отдельного бакета клиента для политики с Limit
+func (p *RatePolicy) key(clientID string) string {
+	return clientID + "#" + p.Name
+}
+
+// RatePolicies выбирает политику запроса по правилам http.ServeMux: из подходящих
+// шаблонов побеждает самый конкретный
+type RatePolicies struct {
+	mux       *http.ServeMux
+	byPattern map[string]*RatePolicy
+}
+
+// NewRatePolicies проверяет политики и собирает их в RatePolicies
+func NewRatePolicies(policies []RatePolicy) (*RatePolicies, error) {
+	ps := &RatePolicies{mux: http.NewServeMux(), byPattern: make(map[string]*RatePolicy, len(policies))}
+	names := make(map[string]bool, len(policies))
+	for i := range policies {
+		p := policies[i]
+		if p.Name == "" {
+			p.Name = p.Pattern
+		}
+		if p.Cost == 0 {
+			p.Cost = 1
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%w: duplicate name %q", ErrInvalidPolicy, p.Name)
+		}
+		names[p.Name] = true
+		if p.Cost < 0 {
+			return nil, fmt.Errorf("%w: %q: cost must be positive", ErrInvalidPolicy, p.Name)
+		}
+		if p.Limit != nil {
+			if p.Limit.Capacity <= 0 || p.Limit.RPS <= 0 {
+				return nil, fmt.Errorf("%w: %q: capacity and rps must be positive", ErrInvalidPolicy, p.Name)
+			}
+			if strings.Contains(p.Name, "#") {
+				return nil, fmt.Errorf("%w: %q: name of a policy with its own limit must not contain '#'", ErrInvalidPolicy, p.Name)
+			}
+			if p.Cost > p.Limit.Capacity {
+				return nil, fmt.Errorf("%w: %q: cost %d exceeds capacity %d", ErrInvalidPolicy, p.Name, p.Cost, p.Limit.Capacity)
+			}
+		}
+		if err := ps.register(&p); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// register добавляет шаблон в mux; ServeMux паникует на неверных и конфликтующих
+// шаблонах, паника превращается в ошибку конфига
+func (ps *RatePolicies) register(p *RatePolicy) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %q: %v", ErrInvalidPolicy, p.Name, r)
+		}
+	}()
+	ps.mux.Handle(p.Pattern, http.NotFoundHandler())
+	ps.byPattern[p.Pattern] = p
+	return nil
+}
+
+// MatchPolicy возвращает политику запроса или nil
+func (ps *RatePolicies) MatchPolicy(r *http.Request) *RatePolicy {
+	if ps == nil || len(ps.byPattern) == 0 {
+		return nil
+	}
+	_, pattern := ps.mux.Handler(r)
+	return ps.byPattern[pattern]
+}
@@ -1,44 +1,157 @@
 package middleware
 
 import (
-	"net"
+	"context"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"log/slog"
 
 	"github.com/P1coFly/LoadBalancer/pkg/client"
 	"github.com/P1coFly/LoadBalancer/pkg/handlers"
+	"github.com/P1coFly/LoadBalancer/pkg/identity"
 )
 
 const (
 	RateLimit = "rate limit exceeded"
 )
 
-// RateLimitMiddleware проверяет и обновляет capacity у клиента
-func RateLimitMiddleware(repo client.ClientRepo, logger *slog.Logger, next http.Handler) http.Handler {
+const clientIDKey contextKey = "client_id"
+
+// Identifier определяет клиента запроса (см. identity.Chain)
+type Identifier interface {
+	Identify(r *http.Request) (string, error)
+}
+
+// PolicyMatcher выбирает политику лимита для запроса (см. RatePolicies)
+type PolicyMatcher interface {
+	MatchPolicy(r *http.Request) *RatePolicy
+}
+
+// ParentLimits — лимиты над клиентом: организации и общий (см. client.Hierarchy)
+type ParentLimits interface {
+	ConsumeParents(tenant string, n int) client.Decision
+}
+
+// RateLimitMiddleware проверяет и обновляет capacity у клиента. Клиента определяет
+// ident, nil — по IP; найденный ID доступен дальше через ClientID. Политика из policies
+// задаёт стоимость запроса и, возможно, отдельный бакет маршрута в policyRepo — отдельно
+// от клиентов, чтобы их не было видно через /clients; без политики запрос стоит один токен. Прошедший лимит клиента запрос проверяется parents: лимитом его
+// организации и общим; при их отказе потраченное клиентом возвращается. Ответ получает заголовки RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset (draft-ietf-httpapi-ratelimit-headers) самого строгого уровня и
+// Retry-After — у отклонённых запросов и у пропущенных, после которых лимит исчерпан
+func RateLimitMiddleware(repo, policyRepo client.ClientRepo, ident Identifier, policies PolicyMatcher, parents ParentLimits, logger *slog.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Определяем client ID (например, из заголовка)
-		clientID := extractClientIP(r)
+		clientID := ClientIP(r)
+		if ident != nil {
+			var err error
+			if clientID, err = ident.Identify(r); err != nil {
+				status := http.StatusUnauthorized
+				if errors.Is(err, identity.ErrUnknownClient) {
+					status = http.StatusForbidden
+				}
+				handlers.SendJSONError(w, status, err.Error())
+				logger.Info("client not identified", "ip", ClientIP(r), "error", err)
+				return
+			}
+		}
 		if clientID == "" {
 			handlers.SendJSONError(w, http.StatusBadRequest, "cannot determine client IP")
 			return
 		}
+		r = r.WithContext(context.WithValue(r.Context(), clientIDKey, clientID))
 
-		remaining := repo.Consume(clientID, 1)
-		if !remaining {
+		var policy *RatePolicy
+		if policies != nil {
+			policy = policies.MatchPolicy(r)
+		}
+		cost := 1
+		if policy != nil {
+			cost = policy.Cost
+		}
+		separate := policy != nil && policy.Limit != nil
+		var d client.Decision
+		if separate {
+			d = policyRepo.ConsumeWith(policy.key(clientID), cost, *policy.Limit)
+		} else {
+			d = repo.Consume(clientID, cost)
+		}
+		tenant := ""
+		if d.Allowed && parents != nil {
+			tenant = d.Tenant
+			// бакет маршрута не знает организацию, она записана у самого клиента
+			if separate {
+				tenant = ""
+				if cl := repo.GetClient(clientID); cl != nil {
+					tenant = cl.Tenant
+				}
+			}
+			pd := parents.ConsumeParents(tenant, cost)
+			if !pd.Allowed {
+				// отказ организации или общего лимита не должен стоить клиенту токенов
+				if separate {
+					policyRepo.Refund(d)
+				} else {
+					repo.Refund(d)
+				}
+			}
+			d = d.And(pd)
+		}
+		setRateLimitHeaders(w.Header(), d)
+		if !d.Allowed {
 			handlers.SendJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
-			logger.Info("rate limit exceeded", "client", clientID)
+			// ID из ident может быть API-ключом, в лог попадает только его хэш
+			logged := clientID
+			if ident != nil {
+				logged = identity.Redact(clientID)
+			}
+			attrs := []any{"client", logged, "ip", ClientIP(r)}
+			if policy != nil {
+				attrs = append(attrs, "policy", policy.Name)
+			}
+			if tenant != "" {
+				attrs = append(attrs, "tenant", tenant)
+			}
+			logger.Info("rate limit exceeded", attrs...)
 			return
 		}
+		// leaky_bucket: запрос ждёт своей очереди, пока клиент не отключился
+		if d.Delay > 0 {
+			t := time.NewTimer(d.Delay)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func extractClientIP(r *http.Request) string {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		// если не удалось, возвращаем весь RemoteAddr
-		return r.RemoteAddr
+// ClientID возвращает клиента, определённого RateLimitMiddleware, а без него — IP
+func ClientID(r *http.Request) string {
+	if id, ok := r.Context().Value(clientIDKey).(string); ok {
+		return id
 	}
-	return host
+	return ClientIP(r)
+}
+
+func setRateLimitHeaders(h http.Header, d client.Decision) {
+	if d.Limit > 0 {
+		h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", deltaSeconds(d.Reset))
+	}
+	if d.RetryAfter > 0 && (!d.Allowed || d.Remaining == 0) {
+		h.Set("Retry-After", deltaSeconds(d.RetryAfter))
+	}
+}
+
+// deltaSeconds округляет вверх: клиент, пришедший раньше, снова получит отказ
+func deltaSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
 }
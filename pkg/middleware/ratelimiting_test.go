@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/P1coFly/LoadBalancer/pkg/client"
+	"github.com/P1coFly/LoadBalancer/pkg/identity"
+)
+
+func TestRateLimit_Headers(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	repo := client.NewMemoryRepo(10, 1, logger)
+	repo.AddClient("192.0.2.1", client.Limit{Capacity: 2, RPS: 0.5}, "")
+	h := RateLimitMiddleware(repo, nil, nil, nil, nil, logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, want := range []struct {
+		code                         int
+		remaining, reset, retryAfter string
+	}{
+		{http.StatusOK, "1", "2", ""},
+		// последний токен потрачен: следующий появится через 2 секунды
+		{http.StatusOK, "0", "4", "2"},
+		{http.StatusTooManyRequests, "0", "4", "2"},
+	} {
+		rr := serve()
+		h := rr.Header()
+		if rr.Code != want.code || h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != want.remaining ||
+			h.Get("RateLimit-Reset") != want.reset || h.Get("Retry-After") != want.retryAfter {
+			t.Errorf("request %d: %d limit=%q remaining=%q reset=%q retry-after=%q; want %d 2 %s %s %q", i, rr.Code,
+				h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"), h.Get("RateLimit-Reset"), h.Get("Retry-After"),
+				want.code, want.remaining, want.reset, want.retryAfter)
+		}
+	}
+}
+
+func TestRateLimit_Identity(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	repo := client.NewMemoryRepo(10, 1, logger)
+	repo.AddClient("key-1", client.Limit{Capacity: 1, RPS: 0.001}, "")
+	chain := &identity.Chain{
+		Sources: []identity.Source{identity.Header("X-API-Key")},
+		Unknown: identity.PolicyReject,
+		Known:   func(id string) bool { return repo.GetClient(id) != nil },
+	}
+	var seen string
+	h := RateLimitMiddleware(repo, nil, chain, nil, nil, logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientID(r)
+	}))
+
+	serve := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := serve("key-1"); code != http.StatusOK || seen != "key-1" {
+		t.Errorf("known key: %d, client %q; want 200, key-1", code, seen)
+	}
+	// лимит считается по ключу, а не по IP
+	if code := serve("key-1"); code != http.StatusTooManyRequests {
+		t.Errorf("known key over limit: %d; want 429", code)
+	}
+	if code := serve("stranger"); code != http.StatusForbidden {
+		t.Errorf("unknown key: %d; want 403", code)
+	}
+	if code := serve(""); code != http.StatusUnauthorized {
+		t.Errorf("no key without fallback: %d; want 401", code)
+	}
+}
+
+func TestRateLimit_Policies(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	repo := client.NewMemoryRepo(20, 0.001, logger)
+	policies, err := NewRatePolicies([]RatePolicy{
+		{Pattern: "POST /upload", Cost: 10},
+		{Name: "search", Pattern: "GET /search", Limit: &client.Limit{Capacity: 5, RPS: 0.001}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policyRepo := client.NewMemoryRepo(0, 0, logger)
+	h := RateLimitMiddleware(repo, policyRepo, nil, policies, nil, logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// поиск — отдельный бакет на 5 запросов, основной лимит клиента не тратится
+	for i := range 5 {
+		if rr := serve(http.MethodGet, "/search?q=x"); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "5" {
+			t.Fatalf("search %d: %d limit=%q; want 200 with limit 5", i, rr.Code, rr.Header().Get("RateLimit-Limit"))
+		}
+	}
+	if rr := serve(http.MethodGet, "/search"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("6th search: %d; want 429", rr.Code)
+	}
+	if cl := policyRepo.GetClient("192.0.2.1#search"); cl == nil || cl.Limit.Capacity != 5 {
+		t.Errorf("search bucket = %+v; want capacity 5", cl)
+	}
+	// бакет маршрута не виден и не изменяем через репозиторий клиентов (/clients)
+	if cl := repo.GetClient("192.0.2.1#search"); cl != nil {
+		t.Errorf("search bucket in client repo: %+v", cl)
+	}
+
+	// загрузка стоит 10 токенов из 20 основных
+	for i, want := range []string{"10", "0"} {
+		if rr := serve(http.MethodPost, "/upload"); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != want {
+			t.Errorf("upload %d: %d remaining=%q; want 200 %s", i, rr.Code, rr.Header().Get("RateLimit-Remaining"), want)
+		}
+	}
+	// метод не совпал — обычный запрос за один токен, которого уже нет
+	if rr := serve(http.MethodGet, "/upload"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("GET /upload: %d; want 429", rr.Code)
+	}
+}
+
+func TestNewRatePolicies_Invalid(t *testing.T) {
+	for name, ps := range map[string][]RatePolicy{
+		"bad pattern":    {{Pattern: "upload"}},
+		"conflict":       {{Name: "a", Pattern: "/upload"}, {Name: "b", Pattern: "/upload"}},
+		"duplicate name": {{Name: "a", Pattern: "/a"}, {Name: "a", Pattern: "/b"}},
+		"negative cost":  {{Pattern: "/a", Cost: -1}},
+		"zero capacity":  {{Pattern: "/a", Limit: &client.Limit{RPS: 1}}},
+		"cost too high":  {{Pattern: "/a", Cost: 10, Limit: &client.Limit{Capacity: 5, RPS: 1}}},
+		"hash in name":   {{Name: "a#b", Pattern: "/a", Limit: &client.Limit{Capacity: 5, RPS: 1}}},
+	} {
+		if _, err := NewRatePolicies(ps); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: err = %v; want ErrInvalidPolicy", name, err)
+		}
+	}
+}
+
+func TestRateLimit_Tenants(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	repo := client.NewMemoryRepo(10, 0.001, logger)
+	parents := client.NewHierarchy(client.NewMemoryRepo(100, 0.001, logger), client.NewMemoryRepo(1, 1, logger))
+	parents.Tenants.AddClient("acme", client.Limit{Capacity: 3, RPS: 0.001}, "")
+	for _, id := range []string{"app-1", "app-2"} {
+		repo.AddClient(id, client.Limit{Capacity: 2, RPS: 0.001}, "")
+		repo.SetTenant(id, "acme")
+	}
+	h := RateLimitMiddleware(repo, nil, &identity.Chain{Sources: []identity.Source{identity.Header("X-API-Key")}},
+		nil, parents, logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// заголовки — у самого строгого уровня: у клиента осталась 1 из 2, у организации 2 из 3
+	if rr := serve("app-1"); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("app-1: %d limit=%q remaining=%q; want 200 2 1", rr.Code, rr.Header().Get("RateLimit-Limit"), rr.Header().Get("RateLimit-Remaining"))
+	}
+	for i := range 2 {
+		if rr := serve("app-2"); rr.Code != http.StatusOK {
+			t.Fatalf("app-2 request %d: %d; want 200", i, rr.Code)
+		}
+	}
+	// у app-1 есть свой токен, но организация исчерпала 3 запроса на двоих
+	if rr := serve("app-1"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("RateLimit-Limit") != "3" {
+		t.Errorf("app-1 over tenant limit: %d limit=%q; want 429 with tenant limit 3", rr.Code, rr.Header().Get("RateLimit-Limit"))
+	}
+	// отказ организации не тратит токен клиента
+	if cl := repo.GetClient("app-1"); cl.Remaining != 1 {
+		t.Errorf("app-1 remaining after tenant rejection = %d; want 1", cl.Remaining)
+	}
+	// клиент без организации проверяется только своим лимитом
+	if rr := serve("solo"); rr.Code != http.StatusOK {
+		t.Errorf("client without tenant: %d; want 200", rr.Code)
+	}
+}
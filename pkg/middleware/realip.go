@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/P1coFly/LoadBalancer/pkg/forwarded"
+)
+
+type contextKey string
+
+const clientIPKey contextKey = "client_ip"
+
+// RealIP — middleware, определяющий IP клиента с учётом доверенных прокси.
+// Результат кладётся в контекст и используется rate limiting'ом и логами
+func RealIP(policy *forwarded.Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := policy.ClientIP(r)
+		ctx := context.WithValue(r.Context(), clientIPKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP возвращает IP, определённый RealIP, а без него — хост из RemoteAddr
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return (*forwarded.Policy)(nil).ClientIP(r)
+}
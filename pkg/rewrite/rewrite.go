@@ -0,0 +1,177 @@
+package rewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type contextKey string
+
+const rulesKey contextKey = "rewrite_rules"
+
+var ErrInvalidRule = errors.New("invalid rewrite rule")
+
+// HeaderOps — операции над заголовками. Порядок применения: remove, set, add
+type HeaderOps struct {
+	Set    map[string]string
+	Add    map[string]string
+	Remove []string
+}
+
+// Replacement — замена префикса From на To
+type Replacement struct {
+	From string
+	To   string
+}
+
+// Spec — декларативное описание правил маршрута
+type Spec struct {
+	RequestHeaders  HeaderOps
+	PathRegex       string
+	PathReplacement string
+	Query           map[string]string
+	ResponseHeaders HeaderOps
+	// Location — замены префикса в заголовке Location ответа
+	Location []Replacement
+	// CookieDomain — замены атрибута Domain в Set-Cookie ответа. Пустой To убирает Domain
+	CookieDomain []Replacement
+}
+
+// Rules — скомпилированные правила перезаписи запроса и ответа
+type Rules struct {
+	spec Spec
+	path *regexp.Regexp
+}
+
+// New проверяет и компилирует правила
+func New(spec Spec) (*Rules, error) {
+	r := &Rules{spec: spec}
+	if spec.PathRegex != "" {
+		re, err := regexp.Compile(spec.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: path regex: %w", ErrInvalidRule, err)
+		}
+		r.path = re
+	}
+	for _, rep := range spec.Location {
+		if rep.From == "" {
+			return nil, fmt.Errorf("%w: empty location prefix", ErrInvalidRule)
+		}
+	}
+	for _, rep := range spec.CookieDomain {
+		if rep.From == "" {
+			return nil, fmt.Errorf("%w: empty cookie domain", ErrInvalidRule)
+		}
+	}
+	return r, nil
+}
+
+// WithRules кладёт правила маршрута в контекст запроса
+func WithRules(ctx context.Context, r *Rules) context.Context {
+	return context.WithValue(ctx, rulesKey, r)
+}
+
+// FromContext возвращает правила маршрута или nil
+func FromContext(ctx context.Context) *Rules {
+	r, _ := ctx.Value(rulesKey).(*Rules)
+	return r
+}
+
+// RewriteURL переписывает путь и query исходящего запроса. Вызывается до
+// склейки пути с адресом бекенда. Безопасен для nil
+func (r *Rules) RewriteURL(u *url.URL) {
+	if r == nil {
+		return
+	}
+	if r.path != nil {
+		u.Path = r.path.ReplaceAllString(u.Path, r.spec.PathReplacement)
+		if !strings.HasPrefix(u.Path, "/") {
+			u.Path = "/" + u.Path
+		}
+		u.RawPath = ""
+	}
+	if len(r.spec.Query) > 0 {
+		q := u.Query()
+		for k, v := range r.spec.Query {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+}
+
+// ApplyRequestHeaders правит заголовки исходящего запроса. Безопасен для nil
+func (r *Rules) ApplyRequestHeaders(h http.Header) {
+	if r == nil {
+		return
+	}
+	r.spec.RequestHeaders.apply(h)
+}
+
+// ApplyResponse правит заголовки ответа бекенда. Безопасен для nil
+func (r *Rules) ApplyResponse(resp *http.Response) {
+	if r == nil {
+		return
+	}
+	r.spec.ResponseHeaders.apply(resp.Header)
+
+	if loc := resp.Header.Get("Location"); loc != "" {
+		for _, rep := range r.spec.Location {
+			if strings.HasPrefix(loc, rep.From) {
+				resp.Header.Set("Location", rep.To+strings.TrimPrefix(loc, rep.From))
+				break
+			}
+		}
+	}
+
+	if len(r.spec.CookieDomain) > 0 {
+		cookies := resp.Header.Values("Set-Cookie")
+		for i, c := range cookies {
+			cookies[i] = r.rewriteCookieDomain(c)
+		}
+	}
+}
+
+// rewriteCookieDomain меняет только атрибут Domain, остальные атрибуты не трогает
+func (r *Rules) rewriteCookieDomain(cookie string) string {
+	attrs := strings.Split(cookie, ";")
+	out := attrs[:1]
+	for _, attr := range attrs[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		if !strings.EqualFold(k, "domain") {
+			out = append(out, attr)
+			continue
+		}
+		domain := strings.TrimPrefix(strings.TrimSpace(v), ".")
+		replaced := false
+		for _, rep := range r.spec.CookieDomain {
+			if strings.EqualFold(domain, strings.TrimPrefix(rep.From, ".")) {
+				if rep.To != "" {
+					out = append(out, " Domain="+rep.To)
+				}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			out = append(out, attr)
+		}
+	}
+	return strings.Join(out, ";")
+}
+
+func (o HeaderOps) apply(h http.Header) {
+	for _, k := range o.Remove {
+		h.Del(k)
+	}
+	for k, v := range o.Set {
+		h.Set(k, v)
+	}
+	for k, v := range o.Add {
+		h.Add(k, v)
+	}
+}
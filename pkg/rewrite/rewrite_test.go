@@ -0,0 +1,119 @@
+package rewrite
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func mustRules(t *testing.T, spec Spec) *Rules {
+	t.Helper()
+	r, err := New(spec)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return r
+}
+
+func TestNew_InvalidRegex(t *testing.T) {
+	if _, err := New(Spec{PathRegex: "("}); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
+
+func TestRewriteURL(t *testing.T) {
+	r := mustRules(t, Spec{
+		PathRegex:       "^/legacy(/.*)?$",
+		PathReplacement: "$1",
+		Query:           map[string]string{"source": "lb"},
+	})
+
+	tests := []struct {
+		in, path, query string
+	}{
+		{"/legacy/app/index.php?id=1", "/app/index.php", "id=1&source=lb"},
+		{"/legacy", "/", "source=lb"},
+		{"/other", "/other", "source=lb"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.in)
+		r.RewriteURL(u)
+		if u.Path != tt.path || u.RawQuery != tt.query {
+			t.Errorf("%s: got path=%q query=%q; want %q %q", tt.in, u.Path, u.RawQuery, tt.path, tt.query)
+		}
+	}
+}
+
+func TestApplyRequestHeaders(t *testing.T) {
+	r := mustRules(t, Spec{RequestHeaders: HeaderOps{
+		Set:    map[string]string{"X-Mounted-At": "/legacy"},
+		Add:    map[string]string{"X-Tag": "b"},
+		Remove: []string{"Authorization"},
+	}})
+	h := http.Header{}
+	h.Set("Authorization", "secret")
+	h.Set("X-Mounted-At", "old")
+	h.Set("X-Tag", "a")
+
+	r.ApplyRequestHeaders(h)
+
+	if h.Get("Authorization") != "" {
+		t.Error("Authorization should be removed")
+	}
+	if h.Get("X-Mounted-At") != "/legacy" {
+		t.Errorf("X-Mounted-At = %q", h.Get("X-Mounted-At"))
+	}
+	if got := h.Values("X-Tag"); len(got) != 2 {
+		t.Errorf("X-Tag = %v", got)
+	}
+}
+
+func TestApplyResponse(t *testing.T) {
+	r := mustRules(t, Spec{
+		ResponseHeaders: HeaderOps{Remove: []string{"Server"}},
+		Location: []Replacement{
+			{From: "http://backend1:8081/", To: "/legacy/"},
+		},
+		CookieDomain: []Replacement{
+			{From: "backend1", To: "example.com"},
+			{From: "internal.local", To: ""},
+		},
+	})
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Server", "legacy/1.0")
+	resp.Header.Set("Location", "http://backend1:8081/login?next=/")
+	resp.Header.Add("Set-Cookie", "sid=1; Path=/; Domain=.backend1; HttpOnly")
+	resp.Header.Add("Set-Cookie", "a=2; Domain=internal.local; Secure")
+	resp.Header.Add("Set-Cookie", "b=3; Domain=other.org")
+
+	r.ApplyResponse(resp)
+
+	if resp.Header.Get("Server") != "" {
+		t.Error("Server should be removed")
+	}
+	if got := resp.Header.Get("Location"); got != "/legacy/login?next=/" {
+		t.Errorf("Location = %q", got)
+	}
+	want := []string{
+		"sid=1; Path=/; Domain=example.com; HttpOnly",
+		"a=2; Secure",
+		"b=3; Domain=other.org",
+	}
+	got := resp.Header.Values("Set-Cookie")
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Set-Cookie[%d] = %q; want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNilRules(t *testing.T) {
+	var r *Rules
+	u, _ := url.Parse("/x")
+	r.RewriteURL(u)
+	r.ApplyRequestHeaders(http.Header{})
+	r.ApplyResponse(&http.Response{Header: http.Header{}})
+	if u.Path != "/x" {
+		t.Errorf("nil rules changed path: %q", u.Path)
+	}
+}
@@ -0,0 +1,58 @@
+package router
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/P1coFly/LoadBalancer/pkg/rewrite"
+)
+
+// Route описывает маршрут: префикс пути, правила перезаписи и обработчик
+type Route struct {
+	PathPrefix string
+	Rules      *rewrite.Rules
+	Handler    http.Handler
+}
+
+// Router выбирает маршрут по самому длинному совпавшему префиксу.
+// Если ни один не подошёл, запрос уходит в fallback
+type Router struct {
+	routes   []Route
+	fallback http.Handler
+}
+
+func New(fallback http.Handler, routes []Route) *Router {
+	rs := make([]Route, len(routes))
+	copy(rs, routes)
+	for i := range rs {
+		if rs[i].Handler == nil {
+			rs[i].Handler = fallback
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return len(rs[i].PathPrefix) > len(rs[j].PathPrefix)
+	})
+	return &Router{routes: rs, fallback: fallback}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.routes {
+		if matchPrefix(r.URL.Path, route.PathPrefix) {
+			if route.Rules != nil {
+				r = r.WithContext(rewrite.WithRules(r.Context(), route.Rules))
+			}
+			route.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	rt.fallback.ServeHTTP(w, r)
+}
+
+// matchPrefix — "/legacy" совпадает с "/legacy" и "/legacy/…", но не с "/legacyfoo"
+func matchPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
@@ -0,0 +1,260 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type PinBy string
+
+const (
+	PinNone     PinBy = ""
+	PinHeader   PinBy = "header"
+	PinCookie   PinBy = "cookie"
+	PinClientID PinBy = "client_id"
+)
+
+var (
+	ErrInvalidSplit    = errors.New("invalid traffic split")
+	ErrNoSplit         = errors.New("split not found")
+	ErrVersionMismatch = errors.New("split version mismatch")
+)
+
+// SplitTarget — версия (пул), между которыми делится трафик
+type SplitTarget struct {
+	Name    string
+	Weight  int
+	Handler http.Handler
+}
+
+// Pin задаёт, по какому признаку клиент закрепляется за версией
+type Pin struct {
+	By  PinBy
+	Key string
+	// ClientID возвращает идентификатор клиента для PinClientID
+	ClientID func(*http.Request) string
+}
+
+// Split делит трафик маршрута между версиями по весам. Веса меняются на лету
+type Split struct {
+	name  string
+	pin   Pin
+	state atomic.Pointer[splitState]
+}
+
+// splitState — веса версий и номер их изменения. Меняется целиком
+type splitState struct {
+	targets []SplitTarget
+	version uint64
+}
+
+func NewSplit(name string, targets []SplitTarget, pin Pin) (*Split, error) {
+	switch pin.By {
+	case PinNone, PinClientID:
+	case PinHeader, PinCookie:
+		if pin.Key == "" {
+			return nil, fmt.Errorf("%w: %s pin requires key", ErrInvalidSplit, pin.By)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown pin %q", ErrInvalidSplit, pin.By)
+	}
+	if pin.By == PinClientID && pin.ClientID == nil {
+		return nil, fmt.Errorf("%w: client_id pin requires extractor", ErrInvalidSplit)
+	}
+	ts := make([]SplitTarget, len(targets))
+	copy(ts, targets)
+	// порядок версий фиксирован, чтобы хеш закрепления не зависел от порядка в конфиге
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+	if err := validateWeights(ts); err != nil {
+		return nil, err
+	}
+
+	s := &Split{name: name, pin: pin}
+	s.state.Store(&splitState{targets: ts, version: 1})
+	return s, nil
+}
+
+func (s *Split) Name() string {
+	return s.name
+}
+
+// Weights возвращает текущие веса версий
+func (s *Split) Weights() map[string]int {
+	weights, _ := s.Snapshot()
+	return weights
+}
+
+// Snapshot возвращает текущие веса вместе с их версией. Версия начинается с 1 и
+// растёт на каждое изменение весов
+func (s *Split) Snapshot() (map[string]int, uint64) {
+	st := s.state.Load()
+	weights := make(map[string]int, len(st.targets))
+	for _, t := range st.targets {
+		weights[t.Name] = t.Weight
+	}
+	return weights, st.version
+}
+
+// SetWeights атомарно меняет веса. Версии, не упомянутые в weights, сохраняют свой вес
+func (s *Split) SetWeights(weights map[string]int) error {
+	_, err := s.setWeights(weights, 0)
+	return err
+}
+
+// CompareAndSetWeights меняет веса, только если их версия всё ещё version, и
+// возвращает новую. Иначе — ErrVersionMismatch: веса успел изменить кто-то другой
+func (s *Split) CompareAndSetWeights(version uint64, weights map[string]int) (uint64, error) {
+	return s.setWeights(weights, version)
+}
+
+// setWeights применяет weights к текущему состоянию; expected 0 — без проверки версии
+func (s *Split) setWeights(weights map[string]int, expected uint64) (uint64, error) {
+	for {
+		current := s.state.Load()
+		if expected != 0 && current.version != expected {
+			return current.version, fmt.Errorf("%w: have %d, want %d", ErrVersionMismatch, current.version, expected)
+		}
+		ts := make([]SplitTarget, len(current.targets))
+		copy(ts, current.targets)
+		for name, w := range weights {
+			found := false
+			for i := range ts {
+				if ts[i].Name == name {
+					ts[i].Weight = w
+					found = true
+					break
+				}
+			}
+			if !found {
+				return current.version, fmt.Errorf("%w: unknown version %q", ErrInvalidSplit, name)
+			}
+		}
+		if err := validateWeights(ts); err != nil {
+			return current.version, err
+		}
+		next := &splitState{targets: ts, version: current.version + 1}
+		// между чтением и записью веса мог поменять другой запрос
+		if s.state.CompareAndSwap(current, next) {
+			return next.version, nil
+		}
+	}
+}
+
+func (s *Split) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts := s.state.Load().targets
+	total := 0
+	for _, t := range ts {
+		total += t.Weight
+	}
+
+	var point int
+	if key, ok := s.pinKey(r); ok {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		point = int(h.Sum32() % uint32(total))
+	} else {
+		point = rand.IntN(total)
+	}
+	for _, t := range ts {
+		if point < t.Weight {
+			t.Handler.ServeHTTP(w, r)
+			return
+		}
+		point -= t.Weight
+	}
+}
+
+func (s *Split) pinKey(r *http.Request) (string, bool) {
+	var key string
+	switch s.pin.By {
+	case PinHeader:
+		key = r.Header.Get(s.pin.Key)
+	case PinCookie:
+		if c, err := r.Cookie(s.pin.Key); err == nil {
+			key = c.Value
+		}
+	case PinClientID:
+		key = s.pin.ClientID(r)
+	}
+	return key, key != ""
+}
+
+func validateWeights(ts []SplitTarget) error {
+	if len(ts) == 0 {
+		return fmt.Errorf("%w: no versions", ErrInvalidSplit)
+	}
+	total := 0
+	for _, t := range ts {
+		if t.Weight < 0 {
+			return fmt.Errorf("%w: negative weight for %q", ErrInvalidSplit, t.Name)
+		}
+		total += t.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("%w: total weight is zero", ErrInvalidSplit)
+	}
+	return nil
+}
+
+// SplitRegistry хранит сплиты по именам для admin API
+type SplitRegistry struct {
+	mu     sync.RWMutex
+	splits map[string]*Split
+}
+
+func NewSplitRegistry() *SplitRegistry {
+	return &SplitRegistry{splits: make(map[string]*Split)}
+}
+
+func (sr *SplitRegistry) Add(s *Split) error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if _, ok := sr.splits[s.name]; ok {
+		return fmt.Errorf("%w: duplicate name %q", ErrInvalidSplit, s.name)
+	}
+	sr.splits[s.name] = s
+	return nil
+}
+
+// Replace атомарно заменяет набор сплитов, например при перезагрузке конфига
+func (sr *SplitRegistry) Replace(list []*Split) error {
+	splits := make(map[string]*Split, len(list))
+	for _, s := range list {
+		if _, ok := splits[s.name]; ok {
+			return fmt.Errorf("%w: duplicate name %q", ErrInvalidSplit, s.name)
+		}
+		splits[s.name] = s
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.splits = splits
+	return nil
+}
+
+func (sr *SplitRegistry) Get(name string) (*Split, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	s, ok := sr.splits[name]
+	if !ok {
+		return nil, ErrNoSplit
+	}
+	return s, nil
+}
+
+// List возвращает сплиты, отсортированные по имени
+func (sr *SplitRegistry) List() []*Split {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	list := make([]*Split, 0, len(sr.splits))
+	for _, s := range sr.splits {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
@@ -0,0 +1,175 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func versionHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Version", name)
+	})
+}
+
+func serveVersion(h http.Handler, r *http.Request) string {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Header().Get("X-Version")
+}
+
+func TestSplit_Weights(t *testing.T) {
+	s, err := NewSplit("api", []SplitTarget{
+		{Name: "v1", Weight: 90, Handler: versionHandler("v1")},
+		{Name: "v2", Weight: 10, Handler: versionHandler("v2")},
+	}, Pin{})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[serveVersion(s, httptest.NewRequest(http.MethodGet, "/", nil))]++
+	}
+	if counts["v2"] < 700 || counts["v2"] > 1300 {
+		t.Errorf("v2 got %d of 10000 requests; want ~1000", counts["v2"])
+	}
+
+	// blue-green: весь трафик на v2
+	if err := s.SetWeights(map[string]int{"v1": 0, "v2": 1}); err != nil {
+		t.Fatalf("SetWeights: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if v := serveVersion(s, httptest.NewRequest(http.MethodGet, "/", nil)); v != "v2" {
+			t.Fatalf("got %s after switching to v2", v)
+		}
+	}
+}
+
+func TestSplit_SetWeightsInvalid(t *testing.T) {
+	s, err := NewSplit("api", []SplitTarget{
+		{Name: "v1", Weight: 1, Handler: versionHandler("v1")},
+		{Name: "v2", Weight: 1, Handler: versionHandler("v2")},
+	}, Pin{})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+
+	cases := []map[string]int{
+		{"v3": 10},
+		{"v1": -1},
+		{"v1": 0, "v2": 0},
+	}
+	for _, weights := range cases {
+		if err := s.SetWeights(weights); !errors.Is(err, ErrInvalidSplit) {
+			t.Errorf("SetWeights(%v) = %v; want ErrInvalidSplit", weights, err)
+		}
+	}
+	if w := s.Weights(); w["v1"] != 1 || w["v2"] != 1 {
+		t.Errorf("weights changed after invalid update: %v", w)
+	}
+}
+
+func TestSplit_CompareAndSetWeights(t *testing.T) {
+	s, err := NewSplit("api", []SplitTarget{
+		{Name: "v1", Weight: 1, Handler: versionHandler("v1")},
+		{Name: "v2", Weight: 1, Handler: versionHandler("v2")},
+	}, Pin{})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+	_, version := s.Snapshot()
+	next, err := s.CompareAndSetWeights(version, map[string]int{"v2": 9})
+	if err != nil || next != version+1 {
+		t.Fatalf("CompareAndSetWeights = %d, %v; want version %d", next, err, version+1)
+	}
+	// второй администратор правит по устаревшей версии
+	if _, err := s.CompareAndSetWeights(version, map[string]int{"v2": 0}); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale version = %v; want ErrVersionMismatch", err)
+	}
+	if w, v := s.Snapshot(); w["v2"] != 9 || v != next {
+		t.Errorf("after conflict: weights %v version %d; want v2=9 version %d", w, v, next)
+	}
+}
+
+func TestSplit_PinByHeader(t *testing.T) {
+	s, err := NewSplit("api", []SplitTarget{
+		{Name: "v1", Weight: 50, Handler: versionHandler("v1")},
+		{Name: "v2", Weight: 50, Handler: versionHandler("v2")},
+	}, Pin{By: PinHeader, Key: "X-User"})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+
+	seen := map[string]bool{}
+	for u := 0; u < 50; u++ {
+		user := "user-" + strconv.Itoa(u)
+		var first string
+		for i := 0; i < 10; i++ {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-User", user)
+			v := serveVersion(s, r)
+			if first == "" {
+				first = v
+			} else if v != first {
+				t.Fatalf("%s moved from %s to %s", user, first, v)
+			}
+		}
+		seen[first] = true
+	}
+	if !seen["v1"] || !seen["v2"] {
+		t.Errorf("pinned users should spread across versions, got %v", seen)
+	}
+}
+
+func TestSplit_PinByCookieAndClientID(t *testing.T) {
+	targets := []SplitTarget{
+		{Name: "v1", Weight: 50, Handler: versionHandler("v1")},
+		{Name: "v2", Weight: 50, Handler: versionHandler("v2")},
+	}
+	byCookie, err := NewSplit("cookie", targets, Pin{By: PinCookie, Key: "uid"})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+	byClient, err := NewSplit("client", targets, Pin{
+		By:       PinClientID,
+		ClientID: func(r *http.Request) string { return r.RemoteAddr },
+	})
+	if err != nil {
+		t.Fatalf("NewSplit: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "uid", Value: "42"})
+	r.RemoteAddr = "10.0.0.7:1234"
+	wantCookie, wantClient := serveVersion(byCookie, r), serveVersion(byClient, r)
+	for i := 0; i < 20; i++ {
+		if v := serveVersion(byCookie, r); v != wantCookie {
+			t.Fatalf("cookie pin moved from %s to %s", wantCookie, v)
+		}
+		if v := serveVersion(byClient, r); v != wantClient {
+			t.Fatalf("client pin moved from %s to %s", wantClient, v)
+		}
+	}
+}
+
+func TestNewSplit_Invalid(t *testing.T) {
+	target := []SplitTarget{{Name: "v1", Weight: 1, Handler: versionHandler("v1")}}
+	cases := []struct {
+		name    string
+		targets []SplitTarget
+		pin     Pin
+	}{
+		{"no versions", nil, Pin{}},
+		{"unknown pin", target, Pin{By: "ip"}},
+		{"header without key", target, Pin{By: PinHeader}},
+		{"client without extractor", target, Pin{By: PinClientID}},
+	}
+	for _, tc := range cases {
+		if _, err := NewSplit("api", tc.targets, tc.pin); !errors.Is(err, ErrInvalidSplit) {
+			t.Errorf("%s: err = %v; want ErrInvalidSplit", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// envListenerFD — номер дескриптора унаследованного слушающего сокета
+	envListenerFD = "LB_LISTENER_FD"
+	// envReadyFD — номер дескриптора пайпа, в который новый процесс сообщает о готовности
+	envReadyFD = "LB_READY_FD"
+)
+
+var (
+	ErrUnsupported = errors.New("binary upgrade is not supported on this platform")
+	ErrNotReady    = errors.New("new process did not become ready")
+)
+
+// Listener — слушающий TCP-сокет, который можно передать новому процессу.
+// После Handoff он перестаёт принимать соединения, но не закрывается:
+// очередь ядра общая, и новые соединения забирает уже новый процесс
+type Listener struct {
+	*net.TCPListener
+
+	paused    chan struct{}
+	pauseOnce sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// accepting — вызовы Accept, которые сейчас ждут соединения
+	accepting atomic.Int64
+	// fresh — принятые соединения, по которым ещё не пришёл первый запрос
+	fresh      sync.Map
+	freshCount atomic.Int64
+}
+
+// handoffSettle — пауза после того, как все принятые соединения прочитали запрос:
+// http.Server проверяет shuttingDown сразу после перевода соединения в StateActive
+const handoffSettle = 50 * time.Millisecond
+
+// Listen возвращает слушающий сокет, унаследованный от старого процесса, а если
+// его нет — открывает новый на addr. inherited сообщает, откуда взят сокет
+func Listen(addr string) (ln *Listener, inherited bool, err error) {
+	var l net.Listener
+	if raw := os.Getenv(envListenerFD); raw != "" {
+		fd, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s %q: %w", envListenerFD, raw, err)
+		}
+		f := os.NewFile(uintptr(fd), "listener")
+		defer f.Close()
+		if l, err = net.FileListener(f); err != nil {
+			return nil, false, fmt.Errorf("inherit listener: %w", err)
+		}
+		inherited = true
+	} else if l, err = net.Listen("tcp", addr); err != nil {
+		return nil, false, err
+	}
+
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, false, fmt.Errorf("listener %T is not TCP", l)
+	}
+	return &Listener{
+		TCPListener: tl,
+		paused:      make(chan struct{}),
+		closed:      make(chan struct{}),
+	}, inherited, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	l.accepting.Add(1)
+	select {
+	case <-l.paused:
+		l.accepting.Add(-1)
+		<-l.closed
+		return nil, net.ErrClosed
+	default:
+	}
+	c, err := l.TCPListener.Accept()
+	if err == nil {
+		l.fresh.Store(c, struct{}{})
+		l.freshCount.Add(1)
+	}
+	l.accepting.Add(-1)
+	if err != nil {
+		select {
+		case <-l.paused:
+			// Accept прерван дедлайном из Handoff — ждём Close от http.Server
+			<-l.closed
+			return nil, net.ErrClosed
+		default:
+		}
+	}
+	return c, err
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.TCPListener.Close()
+}
+
+// ConnState подключается к http.Server.ConnState и отмечает соединения,
+// по которым прочитан первый запрос. TLS-соединения разворачиваются до принятых
+func (l *Listener) ConnState(c net.Conn, st http.ConnState) {
+	if st == http.StateNew {
+		return
+	}
+	if tc, ok := c.(interface{ NetConn() net.Conn }); ok {
+		c = tc.NetConn()
+	}
+	if _, ok := l.fresh.LoadAndDelete(c); ok {
+		l.freshCount.Add(-1)
+	}
+}
+
+// Handoff перестаёт принимать соединения и ждёт (не дольше timeout), пока по уже
+// принятым придёт первый запрос. Иначе http.Server.Shutdown закроет их без ответа
+func (l *Listener) Handoff(timeout time.Duration) {
+	l.pauseOnce.Do(func() { close(l.paused) })
+	_ = l.TCPListener.SetDeadline(time.Now())
+
+	deadline := time.Now().Add(timeout)
+	for l.accepting.Load() > 0 || l.freshCount.Load() > 0 {
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(handoffSettle)
+}
+
+// Ready сообщает старому процессу, что новый начал принимать соединения и
+// старый может уходить в graceful shutdown. Без родителя ничего не делает
+func Ready() error {
+	raw := os.Getenv(envReadyFD)
+	if raw == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", envReadyFD, raw, err)
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package upgrade
+
+import (
+	"os"
+	"time"
+)
+
+// Signals — на этой платформе обновление бинаря не поддерживается
+func Signals() []os.Signal {
+	return nil
+}
+
+func Spawn(ln *Listener, timeout time.Duration) (*os.Process, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,83 @@
+//go:build unix
+
+package upgrade
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Signals — сигналы, по которым запускается обновление бинаря
+func Signals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR2}
+}
+
+// Spawn запускает текущий бинарь с теми же аргументами и передаёт ему слушающий
+// сокет ln. Возвращается, когда новый процесс вызвал Ready; если он упал или не
+// успел за timeout — процесс убивается и возвращается ошибка, старый продолжает работать
+func Spawn(ln *Listener, timeout time.Duration) (*os.Process, error) {
+	lnFile, err := ln.File()
+	if err != nil {
+		return nil, fmt.Errorf("dup listener: %w", err)
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles[i] становится дескриптором 3+i в новом процессе
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(filterEnv(os.Environ()), envListenerFD+"=3", envReadyFD+"=4")
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return nil, fmt.Errorf("start new process: %w", err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := io.ReadFull(readyR, buf)
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("timeout after %s", timeout)
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("%w: %w", ErrNotReady, err)
+	}
+	// ждём в фоне, чтобы не оставить зомби, если новый процесс выйдет раньше старого
+	go func() { _ = cmd.Wait() }()
+	return cmd.Process, nil
+}
+
+func filterEnv(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		if strings.HasPrefix(kv, envListenerFD+"=") || strings.HasPrefix(kv, envReadyFD+"=") {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}